@@ -4,6 +4,7 @@
 // Usage:
 //
 //	go run benchmark/main.go -tasks 100000
+//	go run benchmark/main.go -tasks 100000 -batch 500
 package main
 
 import (
@@ -14,7 +15,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/guido-cesarano/distributedq/pkg/queue"
 	"github.com/guido-cesarano/distributedq/pkg/tasks"
 )
@@ -22,6 +22,7 @@ import (
 func main() {
 	numTasks := flag.Int("tasks", 100000, "Number of tasks to enqueue")
 	numWorkers := flag.Int("workers", 10, "Number of concurrent enqueuers")
+	batchSize := flag.Int("batch", 0, "Tasks per EnqueueBatch call (0 enqueues one task at a time)")
 	flag.Parse()
 
 	client := queue.NewClient("localhost:6379")
@@ -30,7 +31,8 @@ func main() {
 	fmt.Printf("GoQueue Benchmark\n")
 	fmt.Printf("=================\n")
 	fmt.Printf("Tasks to enqueue: %d\n", *numTasks)
-	fmt.Printf("Concurrent workers: %d\n\n", *numWorkers)
+	fmt.Printf("Concurrent workers: %d\n", *numWorkers)
+	fmt.Printf("Batch size: %d\n\n", *batchSize)
 
 	// Enqueue phase
 	fmt.Printf("Starting enqueue phase...\n")
@@ -44,12 +46,31 @@ func main() {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			var batch []tasks.Task
 			for j := 0; j < tasksPerWorker; j++ {
-				task := tasks.Task{
-					ID:        uuid.New().String(),
-					Type:      "benchmark",
-					Payload:   map[string]interface{}{"worker": workerID, "task": j},
-					CreatedAt: time.Now(),
+				task, err := queue.NewTask("benchmark", map[string]interface{}{"worker": workerID, "task": j})
+				if err != nil {
+					fmt.Printf("Error creating task: %v\n", err)
+					return
+				}
+				if *batchSize > 0 {
+					// Flush the batch when full or at the last task
+					batch = append(batch, task)
+					if len(batch) < *batchSize && j < tasksPerWorker-1 {
+						continue
+					}
+					errs, err := client.EnqueueBatch(ctx, batch)
+					if err != nil {
+						fmt.Printf("Error enqueuing batch: %v\n", err)
+						return
+					}
+					for _, itemErr := range errs {
+						if itemErr == nil {
+							enqueued.Add(1)
+						}
+					}
+					batch = batch[:0]
+					continue
 				}
 				if err := client.Enqueue(ctx, task); err != nil {
 					fmt.Printf("Error enqueuing: %v\n", err)
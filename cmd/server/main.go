@@ -4,6 +4,8 @@
 // API Endpoints:
 //
 //	POST /enqueue - Enqueues a new task to the distributed queue
+//	POST /enqueue/batch - Enqueues many tasks at once (JSON array or NDJSON)
+//	GET /metrics - Prometheus metrics (e.g. goqueue_cron_leader)
 //
 // Request Format:
 //
@@ -27,20 +29,49 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/guido-cesarano/distributedq/pkg/logger"
 	"github.com/guido-cesarano/distributedq/pkg/queue"
 	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// maxResultWait caps the long-polling duration accepted by GET /result?wait=...
+	// and POST /enqueue?sync=true&timeout=...
+	maxResultWait = 60 * time.Second
+
+	// defaultSyncTimeout is used by POST /enqueue?sync=true when no timeout is given.
+	defaultSyncTimeout = 30 * time.Second
+
+	// maxBatchSize and maxBatchBytes bound the tasks and body size accepted by POST /enqueue/batch.
+	maxBatchSize  = 10000
+	maxBatchBytes = 32 << 20
+)
+
+// cronLeader is 1 while this replica is the cron leader (the only replica firing
+// the cron schedules), and 0 otherwise.
+var cronLeader = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "goqueue_cron_leader",
+	Help: "Whether this server replica is the cron leader",
+})
+
 // authMiddleware wraps an http.HandlerFunc and enforces API Key authentication.
 func authMiddleware(next http.HandlerFunc, requiredKey string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -98,43 +129,48 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 			return
 		}
 
-		// Parse request body
-		var req struct {
-			Type     string      `json:"type"`     // Task type
-			Payload  interface{} `json:"payload"`  // Task data
-			Priority int         `json:"priority"` // Optional: 0=Low, 1=Default, 2=High
+		// ?sync=true waits for the task to finish and returns its result
+		sync := r.URL.Query().Get("sync") == "true"
+		timeout := defaultSyncTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			var err error
+			if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+			timeout = min(timeout, maxResultWait)
 		}
+
+		// Parse request body
+		var req enqueueRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Set default priority if not specified (or if 0, which is Low)
-		// If user sends 0 explicitly, it's Low. If they omit it, it's 0 (Low).
-		// To make Default (1) the actual default, we need logic.
-		// Let's assume 0 is Low, 1 is Default, 2 is High.
-		// If user wants Default, they should send 1 or we default to 1?
-		// Standard int default is 0. Let's force default to 1 if 0 is passed?
-		// No, 0 is a valid priority (Low).
-		// Let's use a pointer or specific logic if we want "Default" to be default.
-		// For simplicity: 0=Low, 1=Default, 2=High.
-		// If omitted, it's 0 (Low).
-		// Let's change logic: If user provides nothing, we want Default (1).
-		// But JSON unmarshal gives 0.
-		// Let's use a pointer for Priority to check presence.
-
 		// Create task with unique ID and current timestamp
-		task := tasks.Task{
-			ID:        uuid.New().String(),
-			Type:      req.Type,
-			Payload:   req.Payload,
-			CreatedAt: time.Now(),
-			Priority:  req.Priority,
+		task, err := req.newTask()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if sync {
+			if err := checkSync(task); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			enqueueAndWait(w, r, client, task, timeout)
+			return
 		}
 
 		// Enqueue task to Redis
 		if err := client.Enqueue(context.Background(), task); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if errors.Is(err, queue.ErrSingletonSkipped) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
@@ -142,7 +178,79 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 		fmt.Fprintf(w, "Task enqueued: %s\n", task.ID)
 	}, apiKey)))
 
-	// resultHandler retrieves the result of a task
+	// enqueueBatchHandler enqueues many tasks in a single Redis round trip.
+	// The body is either a JSON array of tasks or NDJSON (one task per line).
+	mux.HandleFunc("/enqueue/batch", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		items, err := decodeBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Batch exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(items) > maxBatchSize {
+			http.Error(w, fmt.Sprintf("Batch exceeds %d tasks", maxBatchSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Build the tasks, keeping track of their position in the request
+		results := make([]batchItemResult, len(items))
+		var batch []tasks.Task
+		var positions []int
+		for i, item := range items {
+			if item.err != nil {
+				results[i].Error = item.err.Error()
+				continue
+			}
+			task, err := item.req.newTask()
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].TaskID = task.ID
+			batch = append(batch, task)
+			positions = append(positions, i)
+		}
+
+		errs, err := client.EnqueueBatch(r.Context(), batch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var resp batchResponse
+		for j, itemErr := range errs {
+			if itemErr != nil {
+				results[positions[j]] = batchItemResult{Error: itemErr.Error()}
+			}
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				resp.Failed++
+			} else {
+				resp.Enqueued++
+			}
+		}
+		resp.Results = results
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}, apiKey)))
+
+	// resultHandler retrieves the result of a task.
+	// With ?wait=<duration> it long-polls until the result is stored or the wait expires.
+	// With ?envelope=true it returns the whole TaskResult instead of the handler value.
 	mux.HandleFunc("/result", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		taskID := r.URL.Query().Get("id")
 		if taskID == "" {
@@ -150,8 +258,24 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 			return
 		}
 
-		result, err := client.GetResult(context.Background(), taskID)
-		if err == redis.Nil {
+		var wait time.Duration
+		if v := r.URL.Query().Get("wait"); v != "" {
+			var err error
+			if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+				http.Error(w, "Invalid wait duration", http.StatusBadRequest)
+				return
+			}
+			wait = min(wait, maxResultWait)
+		}
+
+		var result string
+		var err error
+		if wait > 0 {
+			result, err = client.WaitResult(r.Context(), taskID, wait)
+		} else {
+			result, err = client.GetResult(context.Background(), taskID)
+		}
+		if err == redis.Nil || err == queue.ErrResultTimeout {
 			http.Error(w, "Result not found", http.StatusNotFound)
 			return
 		}
@@ -160,45 +284,131 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(result))
+		writeResult(w, taskID, result, r.URL.Query().Get("envelope") == "true")
 	}, apiKey)))
 
-	// scheduleHandler registers a new cron job
+	// scheduleHandler persists a new cron schedule
 	mux.HandleFunc("/schedule", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		var req struct {
-			Spec     string      `json:"spec"`     // Cron expression (e.g. "@every 1m")
-			Type     string      `json:"type"`     // Task type
-			Payload  interface{} `json:"payload"`  // Task data
-			Priority int         `json:"priority"` // Optional priority
-		}
+		var req scheduleRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		task := tasks.Task{
-			ID: uuid.New().String(), // Template ID, will be reused/ignored?
-			// Actually, in Schedule() we should probably generate new IDs.
-			// But for now let's just use this.
-			Type:      req.Type,
-			Payload:   req.Payload,
-			CreatedAt: time.Now(),
-			Priority:  req.Priority,
+		schedule, err := req.newSchedule(uuid.New().String())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := client.PutSchedule(r.Context(), schedule); err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+
+		fmt.Fprintf(w, "Job scheduled with ID: %s\n", schedule.ID)
+	}, apiKey)))
+
+	// schedulesHandler lists the persistent cron schedules
+	mux.HandleFunc("/schedules", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		schedules, err := client.ListSchedules(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, schedules)
+	}, apiKey)))
+
+	// scheduleByIDHandler reads (GET), creates or replaces (PUT) and deletes (DELETE) a cron schedule
+	mux.HandleFunc("/schedules/{id}", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch r.Method {
+		case http.MethodGet:
+			schedule, err := client.GetSchedule(r.Context(), id)
+			if err != nil {
+				writeScheduleError(w, err)
+				return
+			}
+			writeJSON(w, schedule)
+		case http.MethodPut:
+			var req scheduleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			schedule, err := req.newSchedule(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := client.PutSchedule(r.Context(), schedule); err != nil {
+				writeScheduleError(w, err)
+				return
+			}
+			writeJSON(w, schedule)
+		case http.MethodDelete:
+			if err := client.DeleteSchedule(r.Context(), id); err != nil {
+				writeScheduleError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}, apiKey)))
+
+	// schedulePauseHandler stops a cron schedule from firing until it is resumed
+	mux.HandleFunc("/schedules/{id}/pause", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		schedule, err := client.PauseSchedule(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		writeJSON(w, schedule)
+	}, apiKey)))
+
+	// scheduleResumeHandler lets a paused cron schedule fire again
+	mux.HandleFunc("/schedules/{id}/resume", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
 
-		entryID, err := client.Schedule(context.Background(), req.Spec, task)
+		schedule, err := client.ResumeSchedule(r.Context(), r.PathValue("id"))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid cron spec: %v", err), http.StatusBadRequest)
+			writeScheduleError(w, err)
 			return
 		}
+		writeJSON(w, schedule)
+	}, apiKey)))
 
-		fmt.Fprintf(w, "Job scheduled with EntryID: %d\n", entryID)
+	// scheduleInfoHandler returns a cron schedule with its latest runs and next fire times
+	mux.HandleFunc("/schedules/{id}/info", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		info, err := client.ScheduleInfo(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		writeJSON(w, info)
 	}, apiKey)))
 
 	// statsHandler returns the current queue depths
@@ -220,6 +430,47 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 		}
 	}, apiKey)))
 
+	// workersHandler lists the live workers, with their active tasks
+	mux.HandleFunc("/workers", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		workers, err := client.ListWorkers(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, workers)
+	}, apiKey)))
+
+	// workerCommandsHandler sends pause, resume, drain or shutdown to a worker, or
+	// to all workers ("all"), optionally only those taking tasks from a queue
+	mux.HandleFunc("/workers/{id}/commands", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req workerCommandRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cmd := queue.WorkerCommand{Command: req.Command, Queue: req.Queue}
+		if id := r.PathValue("id"); id != allWorkers {
+			cmd.WorkerID = id
+		}
+
+		targets, err := client.SendWorkerCommand(r.Context(), cmd)
+		if err != nil {
+			writeWorkerError(w, err)
+			return
+		}
+		writeJSON(w, workerCommandResponse{Command: req.Command, Workers: targets})
+	}, apiKey)))
+
 	// tasksHandler returns a list of tasks from a specific queue
 	mux.HandleFunc("/tasks", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -247,16 +498,460 @@ func setupRouter(client *queue.Client, apiKey string) *http.ServeMux {
 		}
 	}, apiKey)))
 
+	// chainHandler returns the state of a task chain
+	mux.HandleFunc("/chains/{id}", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state, err := client.GetChain(r.Context(), r.PathValue("id"))
+		if err == redis.Nil {
+			http.Error(w, "Chain not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}, apiKey)))
+
+	// groupHandler returns the state of a task group
+	mux.HandleFunc("/groups/{id}", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state, err := client.GetGroup(r.Context(), r.PathValue("id"))
+		if err == redis.Nil {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}, apiKey)))
+
+	// workflowHandler returns the state of a workflow and its nodes
+	mux.HandleFunc("/workflows/{id}", enableCORS(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state, err := client.GetWorkflow(r.Context(), r.PathValue("id"))
+		if err == redis.Nil {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}, apiKey)))
+
 	return mux
 }
 
+// enqueueRequest is the body of POST /enqueue and one item of POST /enqueue/batch.
+type enqueueRequest struct {
+	Type             string          `json:"type"`              // Task type
+	Payload          json.RawMessage `json:"payload"`           // Task data
+	Priority         int             `json:"priority"`          // Optional: 0=Low, 1=Default, 2=High
+	ResultTTL        string          `json:"result_ttl"`        // Optional: result retention (e.g. "1h")
+	ExpiresIn        string          `json:"expires_in"`        // Optional: discard the task if not processed within (e.g. "2h")
+	OrderingKey      string          `json:"ordering_key"`      // Optional: run one task of this key at a time, in order
+	SingletonKey     string          `json:"singleton_key"`     // Optional: at most one task of this key pending and one running
+	SingletonPolicy  string          `json:"singleton_policy"`  // Optional: "skip" (default), "queue" or "replace"
+	DebounceKey      string          `json:"debounce_key"`      // Optional: only run the last task of a burst with this key
+	DebounceWindow   string          `json:"debounce_window"`   // Optional: quiet period before the last task runs (e.g. "5s")
+	ThrottleKey      string          `json:"throttle_key"`      // Optional: run at most one task with this key per window
+	ThrottleWindow   string          `json:"throttle_window"`   // Optional: throttle window (e.g. "5s")
+	AggregationKey   string          `json:"aggregation_key"`   // Optional: deliver tasks with this key to the worker in batches
+	AggregationSize  int             `json:"aggregation_size"`  // Optional: maximum number of tasks per batch
+	AggregationDelay string          `json:"aggregation_delay"` // Optional: maximum time a task waits for its batch (e.g. "10s")
+}
+
+// newTask validates the request and creates the task with a unique ID and the current timestamp.
+func (req enqueueRequest) newTask() (tasks.Task, error) {
+	var resultTTL time.Duration
+	if req.ResultTTL != "" {
+		var err error
+		if resultTTL, err = time.ParseDuration(req.ResultTTL); err != nil || resultTTL < 0 {
+			return tasks.Task{}, errors.New("Invalid result_ttl")
+		}
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if req.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return tasks.Task{}, errors.New("Invalid expires_in")
+		}
+		expiresAt = now.Add(expiresIn)
+	}
+
+	var debounceWindow, throttleWindow time.Duration
+	if req.DebounceWindow != "" {
+		var err error
+		if debounceWindow, err = time.ParseDuration(req.DebounceWindow); err != nil || debounceWindow <= 0 {
+			return tasks.Task{}, errors.New("Invalid debounce_window")
+		}
+	}
+	if req.ThrottleWindow != "" {
+		var err error
+		if throttleWindow, err = time.ParseDuration(req.ThrottleWindow); err != nil || throttleWindow <= 0 {
+			return tasks.Task{}, errors.New("Invalid throttle_window")
+		}
+	}
+
+	var aggregationDelay time.Duration
+	if req.AggregationDelay != "" {
+		var err error
+		if aggregationDelay, err = time.ParseDuration(req.AggregationDelay); err != nil || aggregationDelay <= 0 {
+			return tasks.Task{}, errors.New("Invalid aggregation_delay")
+		}
+	}
+	if req.AggregationKey != "" && (req.AggregationSize <= 0 || aggregationDelay <= 0) {
+		return tasks.Task{}, errors.New("aggregation_key requires a positive aggregation_size and an aggregation_delay")
+	}
+
+	if (req.DebounceKey != "") != (debounceWindow > 0) {
+		return tasks.Task{}, errors.New("debounce_key and debounce_window must be set together")
+	}
+	if (req.ThrottleKey != "") != (throttleWindow > 0) {
+		return tasks.Task{}, errors.New("throttle_key and throttle_window must be set together")
+	}
+
+	switch req.SingletonPolicy {
+	case "", tasks.SingletonSkip, tasks.SingletonQueue, tasks.SingletonReplace:
+	default:
+		return tasks.Task{}, errors.New("Invalid singleton_policy")
+	}
+
+	// Combinations the queue rejects, reported as bad requests rather than enqueue failures
+	switch {
+	case req.SingletonKey != "" && req.OrderingKey != "":
+		return tasks.Task{}, errors.New("singleton_key cannot be combined with ordering_key")
+	case req.DebounceKey != "" && req.ThrottleKey != "":
+		return tasks.Task{}, errors.New("debounce_key cannot be combined with throttle_key")
+	case (req.DebounceKey != "" || req.ThrottleKey != "") && (req.OrderingKey != "" || req.SingletonKey != ""):
+		return tasks.Task{}, errors.New("debounce_key and throttle_key cannot be combined with ordering_key or singleton_key")
+	case req.AggregationKey != "" && (req.OrderingKey != "" || req.SingletonKey != "" || req.DebounceKey != "" || req.ThrottleKey != ""):
+		return tasks.Task{}, errors.New("aggregation_key cannot be combined with ordering_key, singleton_key, debounce_key or throttle_key")
+	}
+
+	// Set default priority if not specified (or if 0, which is Low)
+	// If user sends 0 explicitly, it's Low. If they omit it, it's 0 (Low).
+	// To make Default (1) the actual default, we need logic.
+	// Let's assume 0 is Low, 1 is Default, 2 is High.
+	// If user wants Default, they should send 1 or we default to 1?
+	// Standard int default is 0. Let's force default to 1 if 0 is passed?
+	// No, 0 is a valid priority (Low).
+	// Let's use a pointer or specific logic if we want "Default" to be default.
+	// For simplicity: 0=Low, 1=Default, 2=High.
+	// If omitted, it's 0 (Low).
+	// Let's change logic: If user provides nothing, we want Default (1).
+	// But JSON unmarshal gives 0.
+	// Let's use a pointer for Priority to check presence.
+
+	return tasks.Task{
+		ID:               uuid.New().String(),
+		Type:             req.Type,
+		Payload:          req.Payload,
+		CreatedAt:        now,
+		Priority:         req.Priority,
+		ResultTTL:        resultTTL,
+		ExpiresAt:        expiresAt,
+		OrderingKey:      req.OrderingKey,
+		SingletonKey:     req.SingletonKey,
+		SingletonPolicy:  req.SingletonPolicy,
+		DebounceKey:      req.DebounceKey,
+		DebounceWindow:   debounceWindow,
+		ThrottleKey:      req.ThrottleKey,
+		ThrottleWindow:   throttleWindow,
+		AggregationKey:   req.AggregationKey,
+		AggregationSize:  req.AggregationSize,
+		AggregationDelay: aggregationDelay,
+	}, nil
+}
+
+// batchItem is one decoded item of a batch request, or the error that prevented decoding it.
+type batchItem struct {
+	req enqueueRequest
+	err error
+}
+
+// batchItemResult reports the outcome of one item of POST /enqueue/batch.
+type batchItemResult struct {
+	TaskID string `json:"task_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResponse is the body returned by POST /enqueue/batch.
+// Results are in the same order as the tasks in the request.
+type batchResponse struct {
+	Enqueued int               `json:"enqueued"`
+	Failed   int               `json:"failed"`
+	Results  []batchItemResult `json:"results"`
+}
+
+// decodeBatch reads the body of POST /enqueue/batch, which is either a JSON array
+// of tasks or NDJSON (one task per line, blank lines ignored).
+//
+// A body that is not valid JSON as a whole (including a JSON array followed by
+// anything but whitespace) is rejected, while an item that does not decode into
+// a task (or an invalid NDJSON line) only fails that item.
+func decodeBatch(body io.Reader) ([]batchItem, error) {
+	reader := bufio.NewReader(body)
+
+	// Peek at the first non-whitespace byte to detect a JSON array
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil, errors.New("Empty batch")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			reader.UnreadByte()
+			break
+		}
+	}
+
+	var raws []json.RawMessage
+	if first, _ := reader.Peek(1); first[0] == '[' {
+		dec := json.NewDecoder(reader)
+		if err := dec.Decode(&raws); err != nil {
+			return nil, err
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, errors.New("Unexpected data after the JSON array")
+		}
+	} else {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), maxBatchBytes)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				raws = append(raws, json.RawMessage(bytes.Clone(line)))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]batchItem, len(raws))
+	for i, raw := range raws {
+		items[i].err = json.Unmarshal(raw, &items[i].req)
+	}
+	return items, nil
+}
+
+// scheduleRequest is the body of POST /schedule and PUT /schedules/{id}.
+type scheduleRequest struct {
+	Spec     string          `json:"spec"`     // Cron expression (e.g. "@every 1m")
+	Type     string          `json:"type"`     // Task type
+	Payload  json.RawMessage `json:"payload"`  // Task data
+	Priority int             `json:"priority"` // Optional priority
+	Paused   bool            `json:"paused"`   // Optional: create the schedule paused
+
+	// Optional: "skip" (default), "fire_once" or "fire_all" the firings missed while no
+	// scheduler was running
+	MisfirePolicy string `json:"misfire_policy"`
+
+	TimeZone string `json:"time_zone"` // Optional: IANA time zone of the spec (e.g. "Europe/Rome")
+	Jitter   string `json:"jitter"`    // Optional: random delay of every firing, up to this duration (e.g. "30s")
+}
+
+// newSchedule creates the schedule with the given ID described by the request.
+func (req scheduleRequest) newSchedule(id string) (*queue.Schedule, error) {
+	var jitter time.Duration
+	if req.Jitter != "" {
+		var err error
+		if jitter, err = time.ParseDuration(req.Jitter); err != nil || jitter < 0 {
+			return nil, errors.New("Invalid jitter")
+		}
+	}
+
+	return &queue.Schedule{
+		ID:   id,
+		Spec: req.Spec,
+		Task: tasks.Task{
+			Type:     req.Type,
+			Payload:  req.Payload,
+			Priority: req.Priority,
+		},
+		Paused:        req.Paused,
+		MisfirePolicy: req.MisfirePolicy,
+		TimeZone:      req.TimeZone,
+		Jitter:        jitter,
+	}, nil
+}
+
+// writeScheduleError maps the errors of the schedule operations to HTTP responses.
+func writeScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case err == redis.Nil:
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+	case errors.Is(err, queue.ErrInvalidSchedule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// allWorkers is the worker ID of POST /workers/{id}/commands targeting all workers.
+const allWorkers = "all"
+
+// workerCommandRequest is the body of POST /workers/{id}/commands.
+type workerCommandRequest struct {
+	Command string `json:"command"`         // pause, resume, drain or shutdown
+	Queue   string `json:"queue,omitempty"` // Only the workers taking tasks from this queue
+}
+
+// workerCommandResponse lists the workers a command was sent to.
+type workerCommandResponse struct {
+	Command string   `json:"command"`
+	Workers []string `json:"workers"`
+}
+
+// writeWorkerError maps the errors of the worker APIs to HTTP statuses.
+func writeWorkerError(w http.ResponseWriter, err error) {
+	switch {
+	case err == redis.Nil:
+		http.Error(w, "Worker not found", http.StatusNotFound)
+	case errors.Is(err, queue.ErrInvalidWorkerCommand):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeResult writes a stored task result as the response of GET /result.
+//
+// By default only the value returned by the handler is written, as before results
+// were stored in a TaskResult envelope; a failed task is reported with 500 and an
+// expired one with 410. With envelope set, the TaskResult is written as stored.
+// Values stored without an envelope (see Client.SetResult) are written unchanged.
+func writeResult(w http.ResponseWriter, taskID, raw string, envelope bool) {
+	var result queue.TaskResult
+	if envelope || json.Unmarshal([]byte(raw), &result) != nil || result.TaskID != taskID {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(raw))
+		return
+	}
+
+	switch result.Status {
+	case queue.ResultFailed:
+		http.Error(w, "Task failed: "+result.Error, http.StatusInternalServerError)
+	case queue.ResultExpired:
+		http.Error(w, "Task expired before being processed", http.StatusGone)
+	default:
+		if len(result.Data) == 0 {
+			result.Data = json.RawMessage("null")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result.Data)
+	}
+}
+
+// checkSync rejects tasks whose outcome cannot be awaited under their own ID:
+// debounced, throttled and aggregated tasks may be dropped or merged into
+// another task, and a singleton with the replace policy may be replaced
+// before it runs, so a synchronous request would wait until its timeout.
+func checkSync(task tasks.Task) error {
+	switch {
+	case task.AggregationKey != "":
+		return errors.New("sync is not supported with aggregation_key")
+	case task.DebounceKey != "":
+		return errors.New("sync is not supported with debounce_key")
+	case task.ThrottleKey != "":
+		return errors.New("sync is not supported with throttle_key")
+	case task.SingletonKey != "" && task.SingletonPolicy == tasks.SingletonReplace:
+		return errors.New("sync is not supported with singleton_policy replace")
+	}
+	return nil
+}
+
+// enqueueAndWait enqueues the task and writes its outcome as the HTTP response:
+//   - 200 OK with the TaskResult when the task completes
+//   - 500 Internal Server Error with the TaskResult when the task is dead-lettered
+//   - 410 Gone with the TaskResult when the task expired before being processed
+//   - 409 Conflict when a singleton task was skipped
+//   - 202 Accepted with the task ID when the timeout expires first; the task keeps running
+func enqueueAndWait(w http.ResponseWriter, r *http.Request, client *queue.Client, task tasks.Task, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	result, err := client.EnqueueAndWait(ctx, task)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case err == nil:
+		json.NewEncoder(w).Encode(result)
+	case errors.Is(err, queue.ErrTaskFailed):
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(result)
+	case errors.Is(err, queue.ErrTaskExpired):
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(result)
+	case errors.Is(err, queue.ErrResultTimeout):
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"task_id": task.ID, "status": "pending"})
+	case errors.Is(err, queue.ErrSingletonSkipped):
+		w.Header().Del("Content-Type")
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		w.Header().Del("Content-Type")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // main initializes the HTTP server and registers the /enqueue endpoint handler.
 func main() {
 	client := queue.NewClient("127.0.0.1:6379")
 
+	// Report whether this replica fires the cron schedules
+	client.CronLeader().OnChange(func(leading bool, token int64) {
+		if leading {
+			cronLeader.Set(1)
+		} else {
+			cronLeader.Set(0)
+		}
+	})
+
 	// Start Cron Scheduler
 	client.StartCronScheduler()
-	defer client.StopCronScheduler()
 
 	apiKey := os.Getenv("API_KEY")
 	if apiKey == "" {
@@ -266,9 +961,23 @@ func main() {
 	}
 
 	mux := setupRouter(client, apiKey)
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: ":8081", Handler: mux}
+
+	// On shutdown, resign the cron leadership so that another replica takes over right away
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		logger.Log.Info().Msg("Shutting down server...")
+		client.StopCronScheduler()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
 
 	logger.Log.Info().Msg("Server listening on :8081")
-	if err := http.ListenAndServe(":8081", mux); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Log.Fatal().Err(err).Msg("Server failed")
 	}
 }
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/guido-cesarano/distributedq/pkg/queue"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
 )
 
 func TestAuthMiddleware(t *testing.T) {
@@ -89,3 +94,656 @@ func TestAuthDisabled(t *testing.T) {
 		t.Errorf("Expected auth to be disabled, got 401")
 	}
 }
+
+func TestResultWait(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.SetResult(context.Background(), "task-1", map[string]string{"status": "completed"})
+	}()
+
+	req := httptest.NewRequest("GET", "/result?id=task-1&wait=2s", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); body != `{"status":"completed"}` {
+		t.Errorf("Unexpected body: %s", body)
+	}
+
+	// A result that never arrives is reported as not found once the wait expires
+	req = httptest.NewRequest("GET", "/result?id=task-2&wait=100ms", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestResultEnvelope(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+	ctx := context.Background()
+
+	client.Enqueue(ctx, tasks.Task{ID: "ok", Type: "rpc", Priority: tasks.PriorityHigh})
+	task, raw, _ := client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, map[string]int{"width": 800})
+
+	client.Enqueue(ctx, tasks.Task{ID: "ko", Type: "rpc", Priority: tasks.PriorityHigh})
+	task, raw, _ = client.Dequeue(ctx)
+	task.LastError = "boom"
+	client.Fail(ctx, *task, raw)
+
+	// By default only the handler value is returned, as before results had an envelope
+	tests := []struct {
+		url      string
+		wantCode int
+		wantBody string
+	}{
+		{"/result?id=ok", http.StatusOK, `{"width":800}`},
+		{"/result?id=ko", http.StatusInternalServerError, "Task failed: boom\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.url, tt.wantCode, tt.wantBody, w.Code, w.Body.String())
+		}
+	}
+
+	for id, want := range map[string]string{"ok": queue.ResultCompleted, "ko": queue.ResultFailed} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/result?envelope=true&id="+id, nil))
+
+		var result queue.TaskResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode envelope: %v", err)
+		}
+		if w.Code != http.StatusOK || result.TaskID != id || result.Status != want {
+			t.Errorf("Unexpected envelope for %s: %d %+v", id, w.Code, result)
+		}
+	}
+}
+
+func TestEnqueueSync(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	// Simulated worker completing the task
+	go func() {
+		ctx := context.Background()
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil {
+			return
+		}
+		client.CompleteWithResult(ctx, *task, raw, map[string]string{"type": task.Type})
+	}()
+
+	body := strings.NewReader(`{"type":"rpc","priority":2,"payload":{}}`)
+	req := httptest.NewRequest("POST", "/enqueue?sync=true&timeout=5s", body)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result queue.TaskResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Status != queue.ResultCompleted || string(result.Data) != `{"type":"rpc"}` {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestEnqueueSyncTimeout(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	body := strings.NewReader(`{"type":"rpc","payload":{}}`)
+	req := httptest.NewRequest("POST", "/enqueue?sync=true&timeout=100ms", body)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp["status"] != "pending" || resp["task_id"] == "" {
+		t.Errorf("Unexpected response: %v", resp)
+	}
+}
+
+func TestEnqueueSyncUnsupported(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	for _, body := range []string{
+		`{"type":"rpc","aggregation_key":"a","aggregation_size":10,"aggregation_delay":"1s"}`,
+		`{"type":"rpc","debounce_key":"d","debounce_window":"1s"}`,
+		`{"type":"rpc","throttle_key":"t","throttle_window":"1s"}`,
+		`{"type":"rpc","singleton_key":"s","singleton_policy":"replace"}`,
+	} {
+		req := httptest.NewRequest("POST", "/enqueue?sync=true&timeout=100ms", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if keys := s.Keys(); len(keys) != 0 {
+		t.Errorf("Expected nothing to be enqueued, got keys %v", keys)
+	}
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "JSON array",
+			body: `[{"type":"a","priority":2},{"type":"b","result_ttl":"soon"},{"type":"c","priority":0}]`,
+		},
+		{
+			name: "NDJSON",
+			body: "{\"type\":\"a\",\"priority\":2}\n{not json}\n\n{\"type\":\"c\",\"priority\":0}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.FlushAll()
+
+			req := httptest.NewRequest("POST", "/enqueue/batch", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp batchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Enqueued != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+				t.Fatalf("Unexpected response: %+v", resp)
+			}
+			if resp.Results[0].TaskID == "" || resp.Results[1].Error == "" || resp.Results[2].TaskID == "" {
+				t.Errorf("Unexpected item results: %+v", resp.Results)
+			}
+
+			depths := client.GetQueueDepths(context.Background())
+			if depths["queue:high"] != 1 || depths["queue:low"] != 1 {
+				t.Errorf("Unexpected queue depths: %v", depths)
+			}
+		})
+	}
+}
+
+func TestEnqueueBatchInvalidBody(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	mux := setupRouter(queue.NewClient(s.Addr()), "")
+
+	for _, body := range []string{"", "  \n", `[{"type":"a"},`, `[{"type":"a"}] {"type":"b"}`, `[{"type":"a"}]]`} {
+		req := httptest.NewRequest("POST", "/enqueue/batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Body %q: expected status 400, got %d", body, w.Code)
+		}
+	}
+	if keys := s.Keys(); len(keys) != 0 {
+		t.Errorf("Expected nothing to be enqueued, got keys %v", keys)
+	}
+
+	// Trailing whitespace is fine
+	req := httptest.NewRequest("POST", "/enqueue/batch", strings.NewReader("[{\"type\":\"a\"}]\n"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with trailing whitespace, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/enqueue/batch", strings.NewReader("["+strings.Repeat(" ", maxBatchBytes)+"]"))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for an oversized body, got %d", w.Code)
+	}
+}
+
+func TestEnqueueSingleton(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	mux := setupRouter(queue.NewClient(s.Addr()), "")
+
+	body := `{"type":"report","payload":{},"singleton_key":"nightly"}`
+	for _, expected := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest("POST", "/enqueue", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Expected status %d, got %d", expected, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/enqueue", strings.NewReader(`{"type":"report","singleton_key":"k","singleton_policy":"never"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid policy, got %d", w.Code)
+	}
+}
+
+func TestEnqueueConflictingKeys(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	mux := setupRouter(queue.NewClient(s.Addr()), "")
+
+	for _, body := range []string{
+		`{"type":"a","singleton_key":"s","ordering_key":"o"}`,
+		`{"type":"a","debounce_key":"d","debounce_window":"1s","throttle_key":"t","throttle_window":"1s"}`,
+		`{"type":"a","debounce_key":"d","debounce_window":"1s","ordering_key":"o"}`,
+		`{"type":"a","throttle_key":"t","throttle_window":"1s","singleton_key":"s"}`,
+		`{"type":"a","aggregation_key":"g","aggregation_size":2,"aggregation_delay":"1s","debounce_key":"d","debounce_window":"1s"}`,
+		`{"type":"a","aggregation_key":"g","aggregation_size":2,"aggregation_delay":"1s","ordering_key":"o"}`,
+	} {
+		req := httptest.NewRequest("POST", "/enqueue", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status 400, got %d", body, w.Code)
+		}
+	}
+	if keys := s.Keys(); len(keys) != 0 {
+		t.Errorf("Expected nothing to be enqueued, got keys %v", keys)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	mux := setupRouter(queue.NewClient(s.Addr()), "")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := do("PUT", "/schedules/nightly", `{"spec":"0 0 2 * * *","type":"report","time_zone":"Europe/Rome","jitter":"1m"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := do("PUT", "/schedules/bad", `{"spec":"never","type":"report"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid spec, got %d", w.Code)
+	}
+	if w := do("PUT", "/schedules/bad", `{"spec":"@daily","type":"report","time_zone":"Nowhere/Town"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid time zone, got %d", w.Code)
+	}
+	if w := do("PUT", "/schedules/bad", `{"spec":"@daily","type":"report","jitter":"soon"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid jitter, got %d", w.Code)
+	}
+
+	w := do("POST", "/schedules/nightly/pause", "")
+	var schedule queue.Schedule
+	json.NewDecoder(w.Body).Decode(&schedule)
+	if w.Code != http.StatusOK || !schedule.Paused || schedule.Task.Type != "report" || schedule.TimeZone != "Europe/Rome" || schedule.Jitter != time.Minute {
+		t.Errorf("Expected the paused schedule, got %d: %+v", w.Code, schedule)
+	}
+
+	w = do("GET", "/schedules/nightly/info", "")
+	var info queue.ScheduleInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if w.Code != http.StatusOK || info.Schedule == nil || info.Schedule.ID != "nightly" || len(info.Runs) != 0 || len(info.NextFireTimes) != 0 {
+		t.Errorf("Expected the info of the paused schedule, got %d: %+v", w.Code, info)
+	}
+
+	w = do("GET", "/schedules", "")
+	var schedules []queue.Schedule
+	json.NewDecoder(w.Body).Decode(&schedules)
+	if len(schedules) != 1 || schedules[0].ID != "nightly" || !schedules[0].Paused {
+		t.Errorf("Unexpected schedules %+v", schedules)
+	}
+
+	if w := do("DELETE", "/schedules/nightly", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	if w := do("GET", "/schedules/nightly", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w := do("GET", "/schedules/nightly/info", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w := do("POST", "/schedules/nightly/resume", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestGetChain(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	chainID, err := client.EnqueueChain(context.Background(), queue.Chain{
+		Steps: []tasks.Task{{Type: "resize"}, {Type: "upload"}},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueChain failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/chains/"+chainID, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var state queue.ChainState
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if state.State != queue.ChainRunning || len(state.Steps) != 2 {
+		t.Errorf("Unexpected chain state: %+v", state)
+	}
+
+	req = httptest.NewRequest("GET", "/chains/missing", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	groupID, err := client.EnqueueGroup(context.Background(), queue.Group{
+		Tasks: []tasks.Task{{Type: "export"}, {Type: "export"}},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/groups/"+groupID, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var state queue.GroupState
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if state.State != queue.GroupRunning || state.Total != 2 {
+		t.Errorf("Unexpected group state: %+v", state)
+	}
+}
+
+func TestGetWorkflow(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	workflowID, err := client.EnqueueWorkflow(context.Background(), queue.Workflow{
+		Tasks: []tasks.Task{
+			{ID: "extract", Type: "extract"},
+			{ID: "load", Type: "load", DependsOn: []string{"extract"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/workflows/"+workflowID, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var state queue.WorkflowState
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(state.Nodes) != 2 || state.Nodes[0].State != queue.NodePending || state.Nodes[1].State != queue.NodeBlocked {
+		t.Errorf("Unexpected workflow state: %+v", state)
+	}
+
+	req = httptest.NewRequest("GET", "/workflows/missing", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestListWorkers(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	// No workers yet: an empty list, not null
+	req := httptest.NewRequest("GET", "/workers", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("Expected an empty list, got %d %q", w.Code, w.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reg := queue.NewWorkerRegistration(client, queue.WorkerInfo{Version: "1.2.3"})
+	reg.TaskStarted("task-1")
+	done := make(chan struct{})
+	go func() {
+		reg.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var workers []queue.WorkerInfo
+	deadline := time.Now().Add(time.Second)
+	for len(workers) == 0 && time.Now().Before(deadline) {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/workers", nil))
+		if err := json.NewDecoder(w.Body).Decode(&workers); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(workers) != 1 {
+		t.Fatalf("Expected 1 worker, got %d", len(workers))
+	}
+	if workers[0].ID != reg.ID() || workers[0].Version != "1.2.3" || len(workers[0].ActiveTasks) != 1 {
+		t.Errorf("Unexpected worker: %+v", workers[0])
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/workers", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestWorkerCommands(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer s.Close()
+
+	client := queue.NewClient(s.Addr())
+	mux := setupRouter(client, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reg := queue.NewWorkerRegistration(client, queue.WorkerInfo{Queues: []string{"queue:high"}})
+	done := make(chan struct{})
+	go func() {
+		reg.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if workers, _ := client.ListWorkers(ctx); len(workers) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Worker not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"One worker", "/workers/" + reg.ID() + "/commands", `{"command":"pause"}`, http.StatusOK, 1},
+		{"Queue workers", "/workers/all/commands", `{"command":"drain","queue":"queue:high"}`, http.StatusOK, 1},
+		{"Other queue", "/workers/all/commands", `{"command":"drain","queue":"queue:low"}`, http.StatusOK, 0},
+		{"All workers", "/workers/all/commands", `{"command":"resume"}`, http.StatusOK, 1},
+		{"Unknown worker", "/workers/missing/commands", `{"command":"pause"}`, http.StatusNotFound, 0},
+		{"Unknown command", "/workers/all/commands", `{"command":"restart"}`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp workerCommandResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp.Workers) != tt.expectedCount {
+				t.Errorf("Expected %d workers, got %v", tt.expectedCount, resp.Workers)
+			}
+		})
+	}
+
+	// The worker received the commands sent to it, in order
+	for _, expected := range []string{queue.WorkerPause, queue.WorkerDrain, queue.WorkerResume} {
+		select {
+		case cmd := <-reg.Commands():
+			if cmd.Command != expected {
+				t.Errorf("Expected %q, got %q", expected, cmd.Command)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Worker did not receive %q", expected)
+		}
+	}
+}
@@ -7,6 +7,8 @@
 //   - Automatic retry with exponential backoff
 //   - Dead Letter Queue for failed tasks
 //   - Background scheduler for delayed task processing
+//   - Registration in Redis with heartbeats, listed by GET /workers
+//   - Remote pause, resume, drain and shutdown (POST /workers/{id}/commands)
 //
 // Usage:
 //
@@ -17,26 +19,42 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/guido-cesarano/distributedq/pkg/logger"
 	"github.com/guido-cesarano/distributedq/pkg/queue"
 	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/guido-cesarano/distributedq/pkg/worker"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const (
+	// taskLease is how long a concurrency slot or singleton lock outlives a worker
+	// that stopped renewing it.
+	taskLease = 30 * time.Second
+
+	// concurrencyRetryDelay is how long a task waits before being retried when its
+	// concurrency limit is reached or its singleton lock is held.
+	concurrencyRetryDelay = time.Second
+)
+
+// version is the version reported in the worker registration, set at build time
+// with -ldflags "-X main.version=...".
+var version = "dev"
+
 // Prometheus metrics for monitoring task processing.
 var (
 	// tasksProcessed tracks the total number of processed tasks by status and type.
 	// Labels:
-	//   - status: "success", "retry", or "failed"
+	//   - status: "success", "retry", "failed", or "expired"
 	//   - type: task type (e.g., "email", "notification")
 	tasksProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "goqueue_processed_total",
@@ -62,6 +80,15 @@ var (
 		Help: "Number of tasks in each queue",
 	}, []string{"queue"})
 
+	// tasksThrottled tracks tasks put back in the queue without running because of a limit.
+	// Labels:
+	//   - reason: "rate_limit", "concurrency" or "singleton"
+	//   - type: task type (e.g., "email", "notification")
+	tasksThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goqueue_throttled_total",
+		Help: "The total number of tasks requeued because of a limit",
+	}, []string{"reason", "type"})
+
 	// queueLatency tracks the time a task spends in the queue before being processed.
 	// It is calculated as time.Now() - task.CreatedAt.
 	queueLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
@@ -75,6 +102,12 @@ var (
 // It supports graceful shutdown via SIGINT/SIGTERM signals.
 func main() {
 	client := queue.NewClient("127.0.0.1:6379")
+
+	// Load limits from the file named by WORKER_CONFIG, if any
+	cfg, err := worker.LoadConfig(os.Getenv("WORKER_CONFIG"))
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Failed to load worker config")
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Start Prometheus metrics server on port 8080
@@ -99,32 +132,105 @@ func main() {
 	// Start queue depth collector (updates metrics every 5 seconds)
 	go collectQueueMetrics(ctx, client)
 
-	startWorker(ctx, client)
+	// Count tasks archived because their deadline passed before they could run
+	client.OnTaskExpired(func(task tasks.Task) {
+		logger.Log.Warn().Str("task_id", task.ID).Time("expires_at", task.ExpiresAt).Msg("Task expired")
+		tasksProcessed.WithLabelValues("expired", task.Type).Inc()
+	})
+
+	// Register the worker, so that GET /workers lists it until it shuts down
+	reg := queue.NewWorkerRegistration(client, queue.WorkerInfo{Version: version})
+	registered := make(chan struct{})
+	go func() {
+		reg.Run(ctx)
+		close(registered)
+	}()
+	logger.Log.Info().Str("worker_id", reg.ID()).Msg("Worker registered")
+
+	// Apply the commands sent to the worker; shutdown cancels the worker context
+	control := newWorkerControl(reg, cancel)
+	go control.run(ctx)
+
+	startWorker(ctx, client, control, newServeMux(), cfg)
+
+	// Wait for the worker to deregister before exiting (after a drain, the
+	// context is still live)
+	cancel()
+	<-registered
+}
+
+// EmailPayload is the payload of "email" tasks.
+type EmailPayload struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+}
+
+// ImageResizePayload is the payload of "image_resize" tasks.
+type ImageResizePayload struct {
+	URL   string `json:"url"`
+	Width int    `json:"width"`
+}
+
+// ImageResizeResult is the result stored for completed "image_resize" tasks.
+type ImageResizeResult struct {
+	URL   string `json:"url"`
+	Width int    `json:"width"`
+}
+
+// newServeMux registers the handlers for all task types known to this worker.
+// Task types without a dedicated handler fall back to processGenericTask.
+func newServeMux() *worker.ServeMux {
+	mux := worker.NewServeMux()
+	worker.HandleTyped(mux, "email", processEmail)
+	worker.HandleTypedResult(mux, "image_resize", processImageResize)
+	mux.HandleFunc("slow", processSlowTask)
+	mux.HandleBatch("webhook", processWebhooks)
+	mux.HandleDefault(worker.HandlerFunc(processGenericTask))
+	return mux
 }
 
 // startWorker runs the main worker loop that dequeues and processes tasks.
-// It also starts the background scheduler for handling delayed tasks.
+// It also starts the background scheduler for handling delayed tasks, and the
+// aggregator flushing aggregation groups.
 //
 // Task Processing Flow:
 //  1. Dequeue task atomically from main_queue to processing_queue
-//  2. Process the task via processTask()
-//  3. On success: Ack (remove from processing_queue) and increment success metric
-//  4. On failure:
+//  2. Admit the task, or else requeue it to its own queue without consuming a
+//     retry. The cheapest checks come first, so that a task that cannot run
+//     never uses up rate limit quota:
+//     - take a slot of the task's cluster-wide concurrency limit, if configured
+//     - take a token from each of its rate limits, or from none if one of them
+//     is exhausted; the task then waits until the bucket refills
+//     - take the lock of its singleton key, if any; if it is held, the rate
+//     limit tokens are given back
+//  3. Process the task via processTask()
+//  4. On success: Complete (move to completed_queue, store the handler's result) and increment success metric
+//  5. On failure:
 //     - If retries < 3: Schedule retry with exponential backoff, increment retry metric
-//     - If retries >= 3: Move to dead_letter_queue, increment failed metric
+//     - If retries >= 3 or the error wraps worker.ErrSkipRetry: Move to dead_letter_queue, increment failed metric
 //
-// The function runs until the context is cancelled (graceful shutdown).
-// startWorker runs the main worker loop that dequeues and processes tasks.
-// It also starts the background scheduler for handling delayed tasks.
-func startWorker(ctx context.Context, client *queue.Client) {
+// While the worker is paused no task is dequeued; the task being dequeued when
+// the pause arrives still runs.
+//
+// The function runs until the context is cancelled (graceful shutdown), or until
+// the worker is drained.
+func startWorker(ctx context.Context, client *queue.Client, control *workerControl, mux *worker.ServeMux, cfg *worker.Config) {
 	// Start Scheduler in background to process delayed tasks
 	go client.StartScheduler(ctx)
 
+	// Flush aggregation groups whose delay has elapsed
+	go client.StartAggregator(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
+			// Wait while paused, and stop once drained
+			if !control.wait(ctx) {
+				return
+			}
+
 			task, raw, err := client.Dequeue(ctx)
 			if err != nil {
 				if err != context.Canceled {
@@ -133,29 +239,34 @@ func startWorker(ctx context.Context, client *queue.Client) {
 				continue
 			}
 
-			// Rate Limiting Check
-			// Define limits per type (hardcoded for now, could be config)
-			limit := 10 // 10 tasks/sec
-			burst := 20
-			allowed, err := client.Allow(ctx, fmt.Sprintf("ratelimit:%s", task.Type), limit, burst)
-			if err != nil {
-				logger.Log.Error().Err(err).Msg("Rate limit check failed")
-				// Fail open or closed? Let's fail open (process it) or retry?
-				// Let's process it to avoid stuck tasks.
-			} else if !allowed {
-				logger.Log.Warn().Str("type", task.Type).Msg("Rate limit exceeded, re-queueing")
-				// Re-queue to delayed_queue with 5s delay
-				// We use Retry logic but maybe we shouldn't increment retry count?
-				// If we use Retry, it increments count.
-				// Let's manually add to delayed queue without incrementing retry count.
-				// Or just use Retry but don't count it as a failure?
-				// Let's implement a specific Delay method or just hack it here.
-				// For simplicity, let's use Retry but decrement count first? No that's hacky.
-				// Let's just use Retry. It's fine if it counts as a retry, eventually it will DLQ if system is overloaded.
-				// Actually, rate limiting shouldn't consume retries.
-				// Let's add a Requeue method to client? Or just use Retry and accept it.
-				// Let's use Retry for now.
-				client.Retry(ctx, *task, raw)
+			// Cluster-wide concurrency limit
+			release, ok := acquireConcurrencySlot(ctx, client, cfg, task)
+			if !ok {
+				tasksThrottled.WithLabelValues("concurrency", task.Type).Inc()
+				requeue(ctx, client, task, raw, concurrencyRetryDelay)
+				continue
+			}
+
+			// Rate limits: postpone the task until its token buckets refill, without consuming a retry
+			buckets, delay := rateLimitDelay(ctx, client, cfg, task)
+			if delay > 0 {
+				release()
+				tasksThrottled.WithLabelValues("rate_limit", task.Type).Inc()
+				requeue(ctx, client, task, raw, delay)
+				continue
+			}
+
+			// Singleton lock: tasks of a key are released one at a time, so it is only
+			// held here in edge cases (e.g. the lease of a crashed holder)
+			unlock, ok := acquireSingletonLock(ctx, client, task)
+			if !ok {
+				// The task does not run: give its rate limit tokens back
+				if err := client.RefundRateLimits(ctx, buckets); err != nil {
+					logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Failed to refund rate limits")
+				}
+				release()
+				tasksThrottled.WithLabelValues("singleton", task.Type).Inc()
+				requeue(ctx, client, task, raw, concurrencyRetryDelay)
 				continue
 			}
 
@@ -164,36 +275,244 @@ func startWorker(ctx context.Context, client *queue.Client) {
 			latency := start.Sub(task.CreatedAt)
 			queueLatency.WithLabelValues(task.Type).Observe(latency.Seconds())
 
-			switch task.Type {
-			case "email":
-				err = processEmail(task)
-			case "slow":
-				logger.Log.Info().Str("task_id", task.ID).Msg("Processing slow simulation task (5s)...")
-				time.Sleep(5 * time.Second)
-				err = nil // Simulate success after delay
-			case "image_resize":
-				err = processImageResize(task)
-			default:
-				err = processGenericTask(task)
-			}
+			control.reg.TaskStarted(task.ID)
+			result, err := mux.ProcessTask(ctx, task)
+			control.reg.TaskFinished(task.ID)
 
 			if err != nil {
 				// Handle Failure
 				logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Task failed")
-				if task.RetryCount < 3 { // Max Retries = 3
-					client.Retry(ctx, *task, raw)
-					tasksProcessed.WithLabelValues("retry", task.Type).Inc()
+				task.LastError = err.Error()
+				if task.RetryCount < 3 && !errors.Is(err, worker.ErrSkipRetry) { // Max Retries = 3
+					// A retry past the task deadline archives it as expired instead
+					if err := client.Retry(ctx, *task, raw); err == nil {
+						tasksProcessed.WithLabelValues("retry", task.Type).Inc()
+					} else if !errors.Is(err, queue.ErrTaskExpired) {
+						logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Failed to retry task")
+					}
 				} else {
 					client.Fail(ctx, *task, raw)
 					tasksProcessed.WithLabelValues("failed", task.Type).Inc()
 				}
 			} else {
-				// Success
-				client.Complete(ctx, raw)
-				// Store dummy result for demonstration
-				client.SetResult(ctx, task.ID, map[string]string{"status": "completed", "timestamp": time.Now().Format(time.RFC3339)})
+				// Success: complete and store the handler's result
+				if err := client.CompleteWithResult(ctx, *task, raw, result); err != nil {
+					logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Failed to complete task")
+				}
 				tasksProcessed.WithLabelValues("success", task.Type).Inc()
 			}
+			unlock()
+			release()
+		}
+	}
+}
+
+// rateLimitDelay checks the rate limits that apply to the task (see
+// Config.RateLimitsFor) and returns how long the task must be postponed, or zero
+// if it may run now, together with the checked buckets.
+//
+// A token is taken from every bucket or from none of them (see
+// queue.Client.RateLimitsDelay). Errors fail open, so that a rate limiter outage
+// does not stall tasks.
+func rateLimitDelay(ctx context.Context, client *queue.Client, cfg *worker.Config, task *tasks.Task) ([]queue.RateLimitBucket, time.Duration) {
+	var buckets []queue.RateLimitBucket
+	for _, rule := range cfg.RateLimitsFor(task) {
+		buckets = append(buckets, queue.RateLimitBucket{Key: rule.Key, Limit: rule.Rate, Burst: rule.Burst})
+	}
+
+	delay, err := client.RateLimitsDelay(ctx, buckets)
+	if err != nil {
+		logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Rate limit check failed")
+		return nil, 0
+	}
+	if delay > 0 {
+		logger.Log.Warn().Str("task_id", task.ID).Dur("delay", delay).Msg("Rate limit exceeded, re-queueing")
+	}
+	return buckets, delay
+}
+
+// requeue postpones a task that may not run yet, without consuming a retry.
+func requeue(ctx context.Context, client *queue.Client, task *tasks.Task, raw string, delay time.Duration) {
+	if err := client.Requeue(ctx, *task, raw, delay); err != nil && !errors.Is(err, queue.ErrTaskExpired) {
+		logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Failed to requeue task")
+	}
+}
+
+// acquireConcurrencySlot takes a slot of the cluster-wide concurrency limit that
+// applies to the task, if any, and reports whether the task may run.
+//
+// While the slot is held its lease is renewed in the background (see renewLease).
+// The returned function stops the renewal and releases the slot.
+func acquireConcurrencySlot(ctx context.Context, client *queue.Client, cfg *worker.Config, task *tasks.Task) (func(), bool) {
+	key, limit := cfg.ConcurrencyLimit(task)
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	acquire := func() (bool, error) {
+		return client.AcquireSemaphore(ctx, key, limit, task.ID, taskLease)
+	}
+	acquired, err := acquire()
+	if err != nil {
+		// Fail closed: running the task could exceed the limit
+		logger.Log.Error().Err(err).Str("key", key).Msg("Concurrency limit check failed")
+		return nil, false
+	}
+	if !acquired {
+		logger.Log.Debug().Str("key", key).Int("limit", limit).Msg("Concurrency limit reached, re-queueing")
+		return nil, false
+	}
+
+	stop := renewLease(task, "concurrency slot "+key, acquire)
+	return func() {
+		stop()
+		// The worker context may already be cancelled during shutdown
+		if err := client.ReleaseSemaphore(context.Background(), key, task.ID); err != nil {
+			logger.Log.Error().Err(err).Str("key", key).Msg("Failed to release concurrency slot")
+		}
+	}, true
+}
+
+// acquireSingletonLock takes the lock of the task's singleton key, if any, and
+// reports whether the task may run.
+//
+// While the lock is held its lease is renewed in the background (see renewLease).
+// The returned function stops the renewal; the lock itself is released by the
+// queue when the task completes or is dead-lettered.
+func acquireSingletonLock(ctx context.Context, client *queue.Client, task *tasks.Task) (func(), bool) {
+	if task.SingletonKey == "" {
+		return func() {}, true
+	}
+
+	acquire := func() (bool, error) {
+		return client.AcquireSingleton(ctx, *task, taskLease)
+	}
+	acquired, err := acquire()
+	if err != nil {
+		// Fail closed: running the task could overlap another run
+		logger.Log.Error().Err(err).Str("key", task.SingletonKey).Msg("Singleton lock check failed")
+		return nil, false
+	}
+	if !acquired {
+		logger.Log.Debug().Str("key", task.SingletonKey).Msg("Singleton task already running, re-queueing")
+		return nil, false
+	}
+
+	return renewLease(task, "singleton lock "+task.SingletonKey, acquire), true
+}
+
+// renewLease calls renew every taskLease/3 until the returned function is called,
+// so that a long task keeps its lease while a crashed worker loses it after taskLease.
+func renewLease(task *tasks.Task, name string, renew func() (bool, error)) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(taskLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renewed, err := renew()
+				if err != nil {
+					logger.Log.Error().Err(err).Str("task_id", task.ID).Msg("Failed to renew " + name)
+				} else if !renewed {
+					logger.Log.Warn().Str("task_id", task.ID).Msg("Lost " + name)
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// workerControl applies the commands sent to the worker with
+// queue.Client.SendWorkerCommand, and reports the resulting state in the
+// worker registration:
+//   - pause: stop dequeuing tasks until resumed
+//   - resume: dequeue tasks again
+//   - drain: stop dequeuing tasks and exit once the running task finishes
+//   - shutdown: cancel the worker context, like SIGINT/SIGTERM
+type workerControl struct {
+	reg      *queue.WorkerRegistration
+	shutdown context.CancelFunc
+
+	mu       sync.Mutex
+	resume   chan struct{} // Non-nil while paused, closed when resumed or drained
+	draining bool
+}
+
+// newWorkerControl returns the control of the registered worker.
+func newWorkerControl(reg *queue.WorkerRegistration, shutdown context.CancelFunc) *workerControl {
+	return &workerControl{reg: reg, shutdown: shutdown}
+}
+
+// run applies the commands received by the worker until ctx is cancelled.
+func (c *workerControl) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-c.reg.Commands():
+			logger.Log.Info().Str("worker_id", c.reg.ID()).Str("command", cmd.Command).Msg("Worker command received")
+			c.apply(cmd.Command)
+		}
+	}
+}
+
+// apply applies a command. A drained worker ignores everything but shutdown.
+func (c *workerControl) apply(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if command == queue.WorkerShutdown {
+		c.reg.SetState(queue.WorkerStopping)
+		c.shutdown()
+		return
+	}
+	if c.draining {
+		return
+	}
+
+	switch command {
+	case queue.WorkerPause:
+		if c.resume == nil {
+			c.resume = make(chan struct{})
+		}
+		c.reg.SetState(queue.WorkerPaused)
+	case queue.WorkerResume:
+		if c.resume != nil {
+			close(c.resume)
+			c.resume = nil
+		}
+		c.reg.SetState(queue.WorkerRunning)
+	case queue.WorkerDrain:
+		c.draining = true
+		if c.resume != nil {
+			close(c.resume)
+			c.resume = nil
+		}
+		c.reg.SetState(queue.WorkerDraining)
+	}
+}
+
+// wait blocks while the worker is paused, and reports whether it may dequeue a
+// task: false once it is drained or ctx is cancelled.
+func (c *workerControl) wait(ctx context.Context) bool {
+	for {
+		c.mu.Lock()
+		resume, draining := c.resume, c.draining
+		c.mu.Unlock()
+
+		if draining {
+			return false
+		}
+		if resume == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-resume:
 		}
 	}
 }
@@ -207,7 +526,7 @@ func startWorker(ctx context.Context, client *queue.Client) {
 //   - Always succeeds (returns nil)
 //
 // To test retry logic, uncomment the simulated failure code.
-func processTask(task *tasks.Task) error {
+func processTask(ctx context.Context, task *tasks.Task) error {
 	start := time.Now()
 	logger.Log.Info().
 		Str("task_id", task.ID).
@@ -252,26 +571,42 @@ func collectQueueMetrics(ctx context.Context, client *queue.Client) {
 }
 
 // processEmail handles email tasks.
-func processEmail(task *tasks.Task) error {
+func processEmail(ctx context.Context, payload EmailPayload) error {
 	start := time.Now()
+	task, _ := worker.TaskFromContext(ctx)
 	logger.Log.Info().Str("task_id", task.ID).Msg("Sending email...")
 	time.Sleep(200 * time.Millisecond) // Simulate checking email service
 	duration := time.Since(start)
-	taskDuration.WithLabelValues(task.Type).Observe(duration.Seconds())
+	taskDuration.WithLabelValues("email").Observe(duration.Seconds())
 	return nil
 }
 
 // processImageResize handles image resizing tasks.
-func processImageResize(task *tasks.Task) error {
+func processImageResize(ctx context.Context, payload ImageResizePayload) (ImageResizeResult, error) {
 	start := time.Now()
-	logger.Log.Info().Str("task_id", task.ID).Msg("Resizing image...")
+	task, _ := worker.TaskFromContext(ctx)
+	logger.Log.Info().Str("task_id", task.ID).Str("url", payload.URL).Int("width", payload.Width).Msg("Resizing image...")
 	time.Sleep(500 * time.Millisecond) // Simulate CPU work
 	duration := time.Since(start)
-	taskDuration.WithLabelValues(task.Type).Observe(duration.Seconds())
-	return nil
+	taskDuration.WithLabelValues("image_resize").Observe(duration.Seconds())
+	return ImageResizeResult{URL: payload.URL, Width: payload.Width}, nil
+}
+
+// processSlowTask simulates a long-running task.
+func processSlowTask(ctx context.Context, task *tasks.Task) (interface{}, error) {
+	logger.Log.Info().Str("task_id", task.ID).Msg("Processing slow simulation task (5s)...")
+	time.Sleep(5 * time.Second)
+	return nil, nil // Simulate success after delay
+}
+
+// processWebhooks delivers aggregated webhook events in a single request.
+func processWebhooks(ctx context.Context, batch []*tasks.Task) (interface{}, error) {
+	logger.Log.Info().Int("events", len(batch)).Msg("Delivering webhook batch...")
+	time.Sleep(100 * time.Millisecond) // Simulate the HTTP request
+	return nil, nil
 }
 
 // processGenericTask handles unknown task types.
-func processGenericTask(task *tasks.Task) error {
-	return processTask(task)
+func processGenericTask(ctx context.Context, task *tasks.Task) (interface{}, error) {
+	return nil, processTask(ctx, task)
 }
@@ -2,6 +2,7 @@ package integration_tests
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -38,7 +39,7 @@ func TestIntegrationFlow(t *testing.T) {
 	task := tasks.Task{
 		ID:        "integration-test-1",
 		Type:      "integration",
-		Payload:   map[string]string{"msg": "hello"},
+		Payload:   json.RawMessage(`{"msg":"hello"}`),
 		CreatedAt: time.Now(),
 	}
 
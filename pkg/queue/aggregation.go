@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// aggregationGroups is the sorted set of open aggregation groups: member = aggregation
+// key, score = time at which the group must be flushed (Unix ms).
+const aggregationGroups = "aggregation_groups"
+
+// aggregationFlushLimit caps the number of due groups flushed per aggregator tick.
+const aggregationFlushLimit = 100
+
+// aggregationKey returns the Redis list holding the tasks of an open aggregation group.
+func aggregationKey(key string) string {
+	return fmt.Sprintf("aggregation:%s", key)
+}
+
+// aggregationFlushLua defines flush(group, groups, key, id, now), shared by the
+// aggregation scripts. It empties the group and pushes its batch task to the
+// priority queue of the first task of the group, and returns the number of tasks
+// in the batch.
+//
+// The tasks are embedded in the batch payload as they are, rather than decoded
+// and encoded again, so that they reach the handler unchanged.
+const aggregationFlushLua = `
+	local function flush(group, groups, key, id, now)
+		local items = redis.call('LRANGE', group, 0, -1)
+		redis.call('DEL', group)
+		redis.call('ZREM', groups, key)
+		if #items == 0 then
+			return 0
+		end
+
+		local first = cjson.decode(items[1])
+		local queue = 'queue:default'
+		if first.priority == 2 then
+			queue = 'queue:high'
+		elseif first.priority == 0 then
+			queue = 'queue:low'
+		end
+
+		local batch = cjson.encode({
+			id = id,
+			type = first.type,
+			priority = first.priority,
+			created_at = now,
+			aggregation_key = key,
+			aggregated = true,
+		})
+		batch = string.sub(batch, 1, -2) .. ',"payload":[' .. table.concat(items, ',') .. ']}'
+		redis.call('RPUSH', queue, batch)
+		return #items
+	end
+`
+
+// enqueueAggregatedScript adds a task with an AggregationKey to its group, opening
+// the group if needed, and flushes the group once it is full.
+//
+// KEYS[1]: Task list of the group
+// KEYS[2]: Open aggregation groups
+// ARGV[1]: Task JSON
+// ARGV[2]: Aggregation key
+// ARGV[3]: Aggregation size
+// ARGV[4]: Flush time of the group, if opened by this task (Unix ms)
+// ARGV[5]: Batch task ID, if the group is flushed
+// ARGV[6]: Batch task creation time (RFC 3339)
+// Returns the number of tasks flushed, 0 if the group is still open.
+var enqueueAggregatedScript = newScript(aggregationFlushLua + `
+	local size = redis.call('RPUSH', KEYS[1], ARGV[1])
+	if size == 1 then
+		redis.call('ZADD', KEYS[2], ARGV[4], ARGV[2])
+	end
+	if size >= tonumber(ARGV[3]) then
+		return flush(KEYS[1], KEYS[2], ARGV[2], ARGV[5], ARGV[6])
+	end
+	return 0
+`)
+
+// flushAggregationScript flushes a group whose delay has elapsed. The group may
+// have been flushed by another worker in the meantime, in which case nothing happens.
+//
+// KEYS[1]: Task list of the group
+// KEYS[2]: Open aggregation groups
+// ARGV[1]: Aggregation key
+// ARGV[2]: Current timestamp (ms)
+// ARGV[3]: Batch task ID
+// ARGV[4]: Batch task creation time (RFC 3339)
+// Returns the number of tasks flushed.
+var flushAggregationScript = newScript(aggregationFlushLua + `
+	local due = redis.call('ZSCORE', KEYS[2], ARGV[1])
+	if not due or tonumber(due) > tonumber(ARGV[2]) then
+		return 0
+	end
+	return flush(KEYS[1], KEYS[2], ARGV[1], ARGV[3], ARGV[4])
+`)
+
+// enqueueAggregatedArgs validates a task with an AggregationKey and returns the KEYS
+// and ARGV of enqueueAggregatedScript for it.
+func enqueueAggregatedArgs(task tasks.Task, data []byte, now time.Time) ([]string, []interface{}, error) {
+	if task.AggregationSize <= 0 || task.AggregationDelay <= 0 {
+		return nil, nil, errors.New("aggregation size and delay must be positive")
+	}
+	if task.OrderingKey != "" || task.SingletonKey != "" || task.DebounceKey != "" || task.ThrottleKey != "" {
+		return nil, nil, errors.New("aggregated tasks cannot have an ordering, singleton, debounce or throttle key")
+	}
+
+	keys := []string{aggregationKey(task.AggregationKey), aggregationGroups}
+	args := []interface{}{
+		data,
+		task.AggregationKey,
+		task.AggregationSize,
+		now.Add(task.AggregationDelay).UnixMilli(),
+		uuid.New().String(),
+		now.Format(time.RFC3339Nano),
+	}
+	return keys, args, nil
+}
+
+// StartAggregator runs a background loop that flushes the aggregation groups whose
+// AggregationDelay has elapsed, pushing their batch task to its priority queue.
+// Groups that fill up are flushed on enqueue instead.
+//
+// Every worker may run the aggregator: each group is flushed exactly once.
+func (c *Client) StartAggregator(ctx context.Context) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.flushAggregations(ctx, time.Now()); err != nil {
+				logger.Log.Error().Err(err).Msg("Aggregator error")
+			}
+		}
+	}
+}
+
+// flushAggregations flushes up to aggregationFlushLimit groups due at now, and
+// returns the number of groups it flushed.
+func (c *Client) flushAggregations(ctx context.Context, now time.Time) (int, error) {
+	due, err := c.rdb.ZRangeByScore(ctx, aggregationGroups, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: aggregationFlushLimit,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	flushed := 0
+	for _, key := range due {
+		n, err := c.runScript(ctx, flushAggregationScript,
+			[]string{aggregationKey(key), aggregationGroups},
+			key,
+			now.UnixMilli(),
+			uuid.New().String(),
+			now.Format(time.RFC3339Nano),
+		).Int()
+		if err != nil {
+			return flushed, err
+		}
+		if n > 0 {
+			flushed++
+		}
+	}
+	return flushed, nil
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func aggregatedTask(id string, size int) tasks.Task {
+	return tasks.Task{
+		ID:               id,
+		Type:             "webhook",
+		Priority:         tasks.PriorityHigh,
+		Payload:          json.RawMessage(fmt.Sprintf(`{"event":%q}`, id)),
+		AggregationKey:   "endpoint-1",
+		AggregationSize:  size,
+		AggregationDelay: time.Minute,
+	}
+}
+
+// batchIDs decodes the tasks carried by a batch task.
+func batchIDs(t *testing.T, batch *tasks.Task) []string {
+	t.Helper()
+	var items []tasks.Task
+	if err := json.Unmarshal(batch.Payload, &items); err != nil {
+		t.Fatalf("Invalid batch payload %s: %v", batch.Payload, err)
+	}
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestAggregationFlushesFullGroup(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, id := range []string{"e1", "e2", "e3", "e4"} {
+		if err := client.Enqueue(ctx, aggregatedTask(id, 3)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	batch, _, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if !batch.Aggregated || batch.Type != "webhook" || batch.AggregationKey != "endpoint-1" || batch.CreatedAt.IsZero() {
+		t.Errorf("Unexpected batch task %+v", batch)
+	}
+	if ids := batchIDs(t, batch); len(ids) != 3 || ids[0] != "e1" || ids[2] != "e3" {
+		t.Errorf("Expected the batch [e1 e2 e3], got %v", ids)
+	}
+
+	// The tasks reach the handler unchanged
+	var items []tasks.Task
+	json.Unmarshal(batch.Payload, &items)
+	if string(items[1].Payload) != `{"event":"e2"}` {
+		t.Errorf("Unexpected payload %s", items[1].Payload)
+	}
+
+	// e4 opened a new group
+	if ids := queuedIDs(t, client); len(ids) != 0 {
+		t.Errorf("Expected e4 to wait in its group, got %v", ids)
+	}
+	if n, _ := client.rdb.LLen(ctx, aggregationKey("endpoint-1")).Result(); n != 1 {
+		t.Errorf("Expected 1 task in the open group, got %d", n)
+	}
+}
+
+func TestAggregationFlushesAfterDelay(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.Enqueue(ctx, aggregatedTask("e1", 10))
+	client.Enqueue(ctx, aggregatedTask("e2", 10))
+
+	// Not due yet
+	if flushed, err := client.flushAggregations(ctx, time.Now()); err != nil || flushed != 0 {
+		t.Fatalf("Expected no group to be flushed, got %d (%v)", flushed, err)
+	}
+
+	flushed, err := client.flushAggregations(ctx, time.Now().Add(time.Minute+time.Second))
+	if err != nil || flushed != 1 {
+		t.Fatalf("Expected 1 group to be flushed, got %d (%v)", flushed, err)
+	}
+	batch, _, _ := client.Dequeue(ctx)
+	if ids := batchIDs(t, batch); len(ids) != 2 {
+		t.Errorf("Expected the batch [e1 e2], got %v", ids)
+	}
+
+	// A group is flushed once
+	if flushed, _ := client.flushAggregations(ctx, time.Now().Add(time.Hour)); flushed != 0 {
+		t.Errorf("Expected no group left, got %d", flushed)
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 0 {
+		t.Errorf("Expected no other batch, got %d", depth)
+	}
+}
+
+func TestAggregationValidation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	noDelay := aggregatedTask("a", 3)
+	noDelay.AggregationDelay = 0
+	ordered := aggregatedTask("b", 3)
+	ordered.OrderingKey = "k"
+	for _, task := range []tasks.Task{aggregatedTask("c", 0), noDelay, ordered} {
+		if err := client.Enqueue(ctx, task); err == nil {
+			t.Errorf("Expected an error for task %s", task.ID)
+		}
+	}
+
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{aggregatedTask("b1", 2), aggregatedTask("b2", 2), aggregatedTask("b3", 0)})
+	if err != nil {
+		t.Fatalf("EnqueueBatch failed: %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("Unexpected item errors: %v", errs)
+	}
+	if ids := queuedIDs(t, client); len(ids) != 1 {
+		t.Errorf("Expected the batch to be flushed, got %v", ids)
+	}
+}
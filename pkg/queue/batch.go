@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// batchChunkSize caps the number of tasks pushed by a single RPUSH command,
+// so that a large batch does not turn into one oversized Redis request.
+const batchChunkSize = 1000
+
+// batchPush is one command of a batch and the batch indexes of its tasks.
+// Singleton pushes reply 0 when the task is skipped.
+type batchPush struct {
+	cmd       redis.Cmder
+	indexes   []int
+	singleton bool
+
+	// Script pushes, run again if Redis lost its script cache
+	script *redis.Script
+	keys   []string
+	args   []interface{}
+}
+
+// scriptPush queues a registered script for task i of a batch in the pipeline.
+func scriptPush(ctx context.Context, pipe redis.Pipeliner, i int, script *redis.Script, keys []string, args ...interface{}) batchPush {
+	return batchPush{
+		cmd:     script.EvalSha(ctx, pipe, keys, args...),
+		indexes: []int{i},
+		script:  script,
+		keys:    keys,
+		args:    args,
+	}
+}
+
+// EnqueueBatch adds many tasks to their priority queues in a single round trip.
+//
+// Tasks are grouped by priority queue and pushed with variadic RPUSH commands
+// sent in one pipeline, preserving the order of the batch within each queue.
+// Tasks with an OrderingKey, a SingletonKey, a DebounceKey, a ThrottleKey or an
+// AggregationKey are enqueued one by one in the same pipeline, with the same
+// semantics as Enqueue (skipped singletons report ErrSingletonSkipped).
+// Unlike Enqueue, it does not stop at the first failure: the returned slice has
+// one entry per task, nil if the task was enqueued and the error otherwise
+// (a task that cannot be serialized fails alone, without affecting the others).
+//
+// The second return value is only set when the pipeline as a whole cannot be
+// executed, e.g. when Redis is unreachable; per-item errors are then also set.
+func (c *Client) EnqueueBatch(ctx context.Context, batch []tasks.Task) ([]error, error) {
+	errs := make([]error, len(batch))
+
+	// Serialize tasks and group them by queue, keeping the batch order
+	var pushes []batchPush
+	var queues []string
+	values := make(map[string][]interface{})
+	indexes := make(map[string][]int)
+	pipe := c.rdb.Pipeline()
+	now := time.Now()
+	for i, task := range batch {
+		data, err := json.Marshal(task)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if task.AggregationKey != "" && !task.Aggregated {
+			keys, args, err := enqueueAggregatedArgs(task, data, now)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			pushes = append(pushes, scriptPush(ctx, pipe, i, enqueueAggregatedScript, keys, args...))
+			continue
+		}
+		script, keys, args, err := windowedEnqueue(task, data, now)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if script != nil {
+			pushes = append(pushes, scriptPush(ctx, pipe, i, script, keys, args...))
+			continue
+		}
+		if task.SingletonKey != "" {
+			policy, err := singletonPolicy(task)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			keys, args := enqueueSingletonArgs(task, policy, data)
+			push := scriptPush(ctx, pipe, i, enqueueSingletonScript, keys, args...)
+			push.singleton = true
+			pushes = append(pushes, push)
+			continue
+		}
+		if task.OrderingKey != "" {
+			pushes = append(pushes, scriptPush(ctx, pipe, i, enqueueOrderedScript, enqueueOrderedKeys(task), data, task.ID))
+			continue
+		}
+		name := queueName(task.Priority)
+		if _, ok := values[name]; !ok {
+			queues = append(queues, name)
+		}
+		values[name] = append(values[name], data)
+		indexes[name] = append(indexes[name], i)
+	}
+
+	for _, name := range queues {
+		for start := 0; start < len(values[name]); start += batchChunkSize {
+			end := min(start+batchChunkSize, len(values[name]))
+			pushes = append(pushes, batchPush{
+				cmd:     pipe.RPush(ctx, name, values[name][start:end]...),
+				indexes: indexes[name][start:end],
+			})
+		}
+	}
+	if len(pushes) == 0 {
+		return errs, nil
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	// Errors replied by Redis to a single command are per-item errors only;
+	// any other error means no command of the pipeline can be trusted
+	var replyErr redis.Error
+	failed := err != nil && !errors.As(err, &replyErr)
+	for _, push := range pushes {
+		if !failed && push.script != nil && redis.HasErrorPrefix(push.cmd.Err(), "NOSCRIPT") {
+			// The script did not run: run it again once the registry is reloaded
+			push.cmd = c.runScript(ctx, push.script, push.keys, push.args...)
+		}
+		cmdErr := push.cmd.Err()
+		if failed {
+			cmdErr = err
+		} else if push.singleton && cmdErr == nil {
+			if enqueued, _ := push.cmd.(*redis.Cmd).Int(); enqueued == 0 {
+				cmdErr = ErrSingletonSkipped
+			}
+		}
+		if cmdErr != nil {
+			for _, i := range push.indexes {
+				errs[i] = cmdErr
+			}
+		}
+	}
+	if failed {
+		return errs, err
+	}
+	return errs, nil
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestEnqueueBatch(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	var batch []tasks.Task
+	for i := 0; i < 2500; i++ {
+		batch = append(batch, tasks.Task{ID: fmt.Sprintf("high-%d", i), Type: "bulk", Priority: tasks.PriorityHigh})
+	}
+	batch = append(batch,
+		tasks.Task{ID: "low", Type: "bulk", Priority: tasks.PriorityLow},
+		tasks.Task{ID: "broken", Type: "bulk", Payload: json.RawMessage(`{not json`)},
+		tasks.Task{ID: "default", Type: "bulk", Priority: tasks.PriorityDefault},
+	)
+
+	errs, err := client.EnqueueBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("EnqueueBatch failed: %v", err)
+	}
+	if len(errs) != len(batch) {
+		t.Fatalf("Expected %d item errors, got %d", len(batch), len(errs))
+	}
+	for i, itemErr := range errs {
+		if batch[i].ID == "broken" {
+			if itemErr == nil {
+				t.Error("Expected the task with an invalid payload to fail")
+			}
+		} else if itemErr != nil {
+			t.Errorf("Task %s failed: %v", batch[i].ID, itemErr)
+		}
+	}
+
+	depths := client.GetQueueDepths(ctx)
+	if depths["queue:high"] != 2500 || depths["queue:default"] != 1 || depths["queue:low"] != 1 {
+		t.Errorf("Unexpected queue depths: %v", depths)
+	}
+
+	// Order within a queue follows the batch, across chunk boundaries
+	queued, err := client.InspectQueue(ctx, "queue:high", 2500)
+	if err != nil {
+		t.Fatalf("InspectQueue failed: %v", err)
+	}
+	for i, task := range queued {
+		if want := fmt.Sprintf("high-%d", i); task.ID != want {
+			t.Fatalf("Expected %s at position %d, got %s", want, i, task.ID)
+		}
+	}
+}
+
+func TestEnqueueBatchPerQueueErrors(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// A key of the wrong type makes only the pushes to that queue fail
+	s.Set("queue:low", "not a list")
+
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{
+		{ID: "a", Type: "bulk", Priority: tasks.PriorityLow},
+		{ID: "b", Type: "bulk", Priority: tasks.PriorityHigh},
+	})
+	if err != nil {
+		t.Fatalf("Expected only per-item errors, got %v", err)
+	}
+	if errs[0] == nil || errs[1] != nil {
+		t.Errorf("Unexpected item errors: %v", errs)
+	}
+}
+
+func TestEnqueueBatchConnectionError(t *testing.T) {
+	s, client := setupTestRedis()
+	s.Close()
+
+	errs, err := client.EnqueueBatch(context.Background(), []tasks.Task{{ID: "a", Type: "bulk"}})
+	if err == nil {
+		t.Fatal("Expected an error when Redis is unreachable")
+	}
+	if errs[0] == nil {
+		t.Error("Expected the item error to be set as well")
+	}
+}
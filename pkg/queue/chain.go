@@ -0,0 +1,383 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// Chain states.
+const (
+	ChainRunning   = "running"
+	ChainCompleted = "completed"
+	ChainFailed    = "failed"
+)
+
+// chainTTL is how long chain state is kept in Redis after its last update.
+const chainTTL = 7 * 24 * time.Hour
+
+// Chain is a sequence of tasks executed one after another.
+// Step N+1 is enqueued only after step N completes; if a step is moved to the
+// Dead Letter Queue the chain halts and the remaining steps never run.
+type Chain struct {
+	// ID identifies the chain. A UUID is generated if empty.
+	ID string
+
+	// Steps are the tasks to run, in order. Steps without an ID get a generated one.
+	Steps []tasks.Task
+
+	// ResultKey, if set, injects the result of each step into the payload of the
+	// next step under this key. Payloads of all steps but the first must then be
+	// JSON objects (or empty).
+	ResultKey string
+}
+
+// ChainStepState describes one step of a chain.
+type ChainStepState struct {
+	TaskID string `json:"task_id"`
+	Type   string `json:"type"`
+}
+
+// ChainState is the current state of a chain as returned by GetChain.
+type ChainState struct {
+	ID          string           `json:"id"`
+	State       string           `json:"state"`
+	CurrentStep int              `json:"current_step"`
+	Steps       []ChainStepState `json:"steps"`
+	Error       string           `json:"error,omitempty"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// chainKey returns the Redis hash holding the state of a chain.
+func chainKey(chainID string) string {
+	return fmt.Sprintf("chain:%s", chainID)
+}
+
+// EnqueueChain stores the chain state and enqueues its first step.
+// It returns the chain ID, which can be passed to GetChain to follow progress.
+//
+// Redis layout (hash "chain:{id}"):
+//   - state: running, completed or failed
+//   - current: index of the step currently queued or running
+//   - total: number of steps
+//   - step:{n}: JSON of step n as it will be enqueued
+//   - result_key: key used to inject results into the next payload
+func (c *Client) EnqueueChain(ctx context.Context, chain Chain) (string, error) {
+	return c.enqueueChain(ctx, chain, nil)
+}
+
+// enqueueChain implements EnqueueChain, storing extra fields in the chain hash.
+func (c *Client) enqueueChain(ctx context.Context, chain Chain, extra map[string]interface{}) (string, error) {
+	fields, first, err := buildChain(&chain, extra)
+	if err != nil {
+		return "", err
+	}
+
+	key := chainKey(chain.ID)
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, chainTTL)
+	pipe.RPush(ctx, queueName(chain.Steps[0].Priority), first)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return chain.ID, nil
+}
+
+// buildChain assigns the chain ID if needed and returns the fields of the chain
+// hash, including extra, along with the JSON of the first step to enqueue.
+func buildChain(chain *Chain, extra map[string]interface{}) (map[string]interface{}, []byte, error) {
+	if len(chain.Steps) == 0 {
+		return nil, nil, errors.New("chain has no steps")
+	}
+	if chain.ID == "" {
+		chain.ID = uuid.New().String()
+	}
+
+	now := time.Now()
+	fields := map[string]interface{}{
+		"state":      ChainRunning,
+		"current":    0,
+		"total":      len(chain.Steps),
+		"result_key": chain.ResultKey,
+		"updated_at": now.Format(time.RFC3339Nano),
+	}
+	for field, value := range extra {
+		fields[field] = value
+	}
+
+	var first []byte
+	for i, step := range chain.Steps {
+		if step.ID == "" {
+			step.ID = uuid.New().String()
+		}
+		if step.CreatedAt.IsZero() {
+			step.CreatedAt = now
+		}
+		step.ChainID = chain.ID
+		step.ChainStep = i
+
+		if chain.ResultKey != "" && i > 0 {
+			if _, err := payloadObject(step.Payload); err != nil {
+				return nil, nil, fmt.Errorf("chain step %d: %w", i, err)
+			}
+		}
+
+		data, err := json.Marshal(step)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 {
+			first = data
+		}
+		fields[fmt.Sprintf("step:%d", i)] = data
+	}
+	return fields, first, nil
+}
+
+// GetChain returns the current state of a chain.
+// It returns redis.Nil if the chain does not exist or has expired.
+func (c *Client) GetChain(ctx context.Context, chainID string) (*ChainState, error) {
+	fields, err := c.rdb.HGetAll(ctx, chainKey(chainID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+
+	state := &ChainState{
+		ID:    chainID,
+		State: fields["state"],
+		Error: fields["error"],
+	}
+	state.CurrentStep, _ = strconv.Atoi(fields["current"])
+	state.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields["updated_at"])
+
+	total, _ := strconv.Atoi(fields["total"])
+	for i := 0; i < total; i++ {
+		var step tasks.Task
+		if err := json.Unmarshal([]byte(fields[fmt.Sprintf("step:%d", i)]), &step); err != nil {
+			return nil, err
+		}
+		state.Steps = append(state.Steps, ChainStepState{TaskID: step.ID, Type: step.Type})
+	}
+	return state, nil
+}
+
+// advanceChainScript moves the chain of a completed step to its next step,
+// atomically and idempotently.
+//
+// KEYS[1]: Chain hash
+// KEYS[2]: Queue of the next step
+// KEYS[3]: Hash of the workflow this chain compensates (empty if none)
+// ARGV[1]: Completed step
+// ARGV[2]: Next step JSON (empty if the chain is finished)
+// ARGV[3]: Current timestamp
+// ARGV[4]: Chain TTL (seconds)
+var advanceChainScript = newScript(`
+	local key = KEYS[1]
+	local step = tonumber(ARGV[1])
+
+	if redis.call('HGET', key, 'state') ~= 'running' then
+		return 0
+	end
+	if tonumber(redis.call('HGET', key, 'current')) ~= step then
+		return 0
+	end
+
+	if ARGV[2] == '' then
+		-- A saga chain that skipped failed compensations ends up failed
+		if redis.call('HEXISTS', key, 'failed_steps') == 1 then
+			redis.call('HSET', key, 'state', 'failed', 'updated_at', ARGV[3])
+			redis.call('HSET', KEYS[3], 'saga_state', 'compensation_failed')
+		else
+			redis.call('HSET', key, 'state', 'completed', 'updated_at', ARGV[3])
+
+			-- Record the outcome of the saga this chain compensates, if any
+			if KEYS[3] ~= '' then
+				redis.call('HSET', KEYS[3], 'saga_state', 'compensated')
+			end
+		end
+	else
+		redis.call('HSET', key, 'current', step + 1, 'updated_at', ARGV[3])
+		redis.call('RPUSH', KEYS[2], ARGV[2])
+	end
+	redis.call('EXPIRE', key, ARGV[4])
+	return 1
+`)
+
+// advanceChain is called when a chain step completes. It enqueues the next step,
+// injecting the step result into its payload if the chain has a ResultKey, or
+// marks the chain as completed after the last step.
+//
+// The transition is guarded by the chain's current step, so a duplicate
+// completion of the same step never enqueues the next step twice.
+func (c *Client) advanceChain(ctx context.Context, task tasks.Task, result json.RawMessage) error {
+	key := chainKey(task.ChainID)
+	values, err := c.rdb.HMGet(ctx, key, "total", "result_key", fmt.Sprintf("step:%d", task.ChainStep+1), "saga_workflow").Result()
+	if err != nil {
+		return err
+	}
+	if values[0] == nil {
+		return nil // Chain expired or was deleted
+	}
+
+	resultKey, _ := values[1].(string)
+	next, nextQueue, err := nextChainStep(values[2], resultKey, result)
+	if err != nil {
+		return err
+	}
+
+	return c.runScript(ctx, advanceChainScript,
+		[]string{key, nextQueue, sagaWorkflowKey(values[3])},
+		task.ChainStep,
+		next,
+		time.Now().Format(time.RFC3339Nano),
+		int(chainTTL.Seconds()),
+	).Err()
+}
+
+// nextChainStep decodes the stored JSON of the next chain step, injects result
+// under resultKey if set, and returns the step JSON to enqueue with its queue.
+// Both are empty if there is no next step.
+func nextChainStep(raw interface{}, resultKey string, result json.RawMessage) ([]byte, string, error) {
+	data, ok := raw.(string)
+	if !ok {
+		return nil, "", nil
+	}
+
+	var step tasks.Task
+	if err := json.Unmarshal([]byte(data), &step); err != nil {
+		return nil, "", err
+	}
+	if resultKey != "" {
+		var err error
+		if step.Payload, err = injectPayload(step.Payload, resultKey, result); err != nil {
+			return nil, "", err
+		}
+	}
+	step.CreatedAt = time.Now()
+	next, err := json.Marshal(step)
+	if err != nil {
+		return nil, "", err
+	}
+	return next, queueName(step.Priority), nil
+}
+
+// failChainScript handles a chain step moved to the Dead Letter Queue.
+// A plain chain halts. A saga chain records the failure and moves on to the
+// next compensation; the saga is marked compensation_failed once the chain ends.
+//
+// KEYS[1]: Chain hash
+// KEYS[2]: Queue of the next step
+// KEYS[3]: Hash of the workflow this chain compensates (empty if none)
+// ARGV[1]: Failed step
+// ARGV[2]: Error message
+// ARGV[3]: Current timestamp
+// ARGV[4]: Next step JSON (empty if the chain is finished or not a saga)
+// ARGV[5]: Chain TTL (seconds)
+// ARGV[6]: Failed task ID
+var failChainScript = newScript(`
+	local key = KEYS[1]
+	local step = tonumber(ARGV[1])
+
+	if redis.call('HGET', key, 'state') ~= 'running' then
+		return 0
+	end
+	if tonumber(redis.call('HGET', key, 'current')) ~= step then
+		return 0
+	end
+
+	if KEYS[3] == '' then
+		redis.call('HSET', key, 'state', 'failed', 'error', ARGV[2], 'updated_at', ARGV[3])
+		return 1
+	end
+
+	redis.call('HSET', key, 'error', ARGV[2], 'updated_at', ARGV[3])
+	redis.call('HINCRBY', key, 'failed_steps', 1)
+	redis.call('HSET', KEYS[3], 'saga_error:' .. ARGV[6], ARGV[2])
+
+	if ARGV[4] == '' then
+		redis.call('HSET', key, 'state', 'failed')
+		redis.call('HSET', KEYS[3], 'saga_state', 'compensation_failed')
+	else
+		redis.call('HSET', key, 'current', step + 1)
+		redis.call('RPUSH', KEYS[2], ARGV[4])
+	end
+	redis.call('EXPIRE', key, ARGV[5])
+	return 1
+`)
+
+// failChain is called when a chain step is moved to the Dead Letter Queue.
+// It halts the chain, unless the chain runs the compensations of a saga: one
+// failed compensation must not prevent the others from running, so the failure
+// is recorded in the workflow and the next compensation is enqueued.
+func (c *Client) failChain(ctx context.Context, task tasks.Task) error {
+	key := chainKey(task.ChainID)
+	values, err := c.rdb.HMGet(ctx, key, "saga_workflow", fmt.Sprintf("step:%d", task.ChainStep+1)).Result()
+	if err != nil {
+		return err
+	}
+
+	sagaKey := sagaWorkflowKey(values[0])
+	var next []byte
+	nextQueue := ""
+	if sagaKey != "" {
+		if next, nextQueue, err = nextChainStep(values[1], "", nil); err != nil {
+			return err
+		}
+	}
+
+	return c.runScript(ctx, failChainScript,
+		[]string{key, nextQueue, sagaKey},
+		task.ChainStep,
+		task.LastError,
+		time.Now().Format(time.RFC3339Nano),
+		next,
+		int(chainTTL.Seconds()),
+		task.ID,
+	).Err()
+}
+
+// sagaWorkflowKey returns the hash key of the workflow compensated by a chain,
+// given the chain's saga_workflow field, or "" if the chain is not a saga.
+func sagaWorkflowKey(workflowID interface{}) string {
+	if id, _ := workflowID.(string); id != "" {
+		return workflowKey(id)
+	}
+	return ""
+}
+
+// payloadObject decodes a payload that must be a JSON object.
+// An empty or null payload yields an empty object.
+func payloadObject(payload json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(payload) == 0 || string(payload) == "null" {
+		return fields, nil
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("payload must be a JSON object: %w", err)
+	}
+	return fields, nil
+}
+
+// injectPayload returns the payload with value set under key.
+func injectPayload(payload json.RawMessage, key string, value json.RawMessage) (json.RawMessage, error) {
+	fields, err := payloadObject(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		value = json.RawMessage("null")
+	}
+	fields[key] = value
+	return json.Marshal(fields)
+}
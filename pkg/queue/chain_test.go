@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+func chainSteps() []tasks.Task {
+	return []tasks.Task{
+		{ID: "resize", Type: "image_resize", Priority: tasks.PriorityHigh, Payload: json.RawMessage(`{"url":"a.jpg"}`)},
+		{ID: "upload", Type: "upload", Priority: tasks.PriorityHigh, Payload: json.RawMessage(`{"bucket":"images"}`)},
+		{ID: "notify", Type: "notify", Priority: tasks.PriorityHigh},
+	}
+}
+
+func TestChainRunsStepsInOrder(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	chainID, err := client.EnqueueChain(ctx, Chain{Steps: chainSteps(), ResultKey: "previous"})
+	if err != nil {
+		t.Fatalf("EnqueueChain failed: %v", err)
+	}
+
+	// Only the first step is enqueued up front
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 1 {
+		t.Fatalf("Expected 1 queued step, got %d", depth)
+	}
+
+	results := []interface{}{
+		map[string]string{"path": "/tmp/a_small.jpg"},
+		map[string]string{"url": "https://cdn/a_small.jpg"},
+		nil,
+	}
+	for i, want := range []string{"resize", "upload", "notify"} {
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("Dequeue of step %d failed: %v", i, err)
+		}
+		if task.ID != want || task.ChainID != chainID || task.ChainStep != i {
+			t.Fatalf("Expected step %d (%s), got %+v", i, want, task)
+		}
+
+		switch i {
+		case 1:
+			if string(task.Payload) != `{"bucket":"images","previous":{"path":"/tmp/a_small.jpg"}}` {
+				t.Errorf("Unexpected upload payload: %s", task.Payload)
+			}
+		case 2:
+			if string(task.Payload) != `{"previous":{"url":"https://cdn/a_small.jpg"}}` {
+				t.Errorf("Unexpected notify payload: %s", task.Payload)
+			}
+		}
+
+		if err := client.CompleteWithResult(ctx, *task, raw, results[i]); err != nil {
+			t.Fatalf("CompleteWithResult failed: %v", err)
+		}
+	}
+
+	state, err := client.GetChain(ctx, chainID)
+	if err != nil {
+		t.Fatalf("GetChain failed: %v", err)
+	}
+	if state.State != ChainCompleted {
+		t.Errorf("Expected chain completed, got %s", state.State)
+	}
+	if len(state.Steps) != 3 || state.Steps[2].TaskID != "notify" {
+		t.Errorf("Unexpected chain steps: %+v", state.Steps)
+	}
+}
+
+func TestChainHaltsOnFailure(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	chainID, err := client.EnqueueChain(ctx, Chain{Steps: chainSteps()})
+	if err != nil {
+		t.Fatalf("EnqueueChain failed: %v", err)
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	task.LastError = "corrupt image"
+	if err := client.Fail(ctx, *task, raw); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	state, err := client.GetChain(ctx, chainID)
+	if err != nil {
+		t.Fatalf("GetChain failed: %v", err)
+	}
+	if state.State != ChainFailed || state.CurrentStep != 0 || state.Error != "corrupt image" {
+		t.Errorf("Unexpected chain state: %+v", state)
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 0 {
+		t.Errorf("Expected no further steps to be enqueued, got %d", depth)
+	}
+}
+
+func TestChainDuplicateCompletion(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueChain(ctx, Chain{Steps: chainSteps()}); err != nil {
+		t.Fatalf("EnqueueChain failed: %v", err)
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 1 {
+		t.Errorf("Expected the next step to be enqueued once, got %d", depth)
+	}
+}
+
+func TestEnqueueChainValidation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueChain(ctx, Chain{}); err == nil {
+		t.Error("Expected error for empty chain")
+	}
+
+	steps := chainSteps()
+	steps[1].Payload = json.RawMessage(`"not an object"`)
+	if _, err := client.EnqueueChain(ctx, Chain{Steps: steps, ResultKey: "previous"}); err == nil {
+		t.Error("Expected error for non-object payload with ResultKey")
+	}
+
+	if _, err := client.GetChain(ctx, "missing"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil for missing chain, got %v", err)
+	}
+}
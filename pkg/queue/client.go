@@ -11,7 +11,7 @@ package queue
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"sync"
 	"time"
 
 	"github.com/guido-cesarano/distributedq/pkg/logger"
@@ -29,13 +29,23 @@ import (
 //   - delayed_queue: Sorted set storing tasks scheduled for future retry
 //   - dead_letter_queue: Holds tasks that have exceeded max retry attempts
 type Client struct {
-	rdb  *redis.Client
-	cron *cron.Cron
+	rdb       *redis.Client
+	cron      *cron.Cron
+	onExpired func(task tasks.Task)
+
+	// Cron entries of the persistent schedules, by schedule ID (see StartCronScheduler)
+	cronMu      sync.Mutex
+	cronEntries map[string]cronEntry
+	cronCancel  context.CancelFunc
+	cronLeader  *LeaderElection
 }
 
 // NewClient creates a new queue client connected to the specified Redis address.
 // The address should be in the format "host:port" (e.g., "localhost:6379").
 //
+// It loads the Lua scripts of the package into Redis (see scripts). If Redis is
+// not reachable yet, the scripts are loaded on their first call instead.
+//
 // Example:
 //
 //	client := queue.NewClient("localhost:6379")
@@ -43,10 +53,19 @@ func NewClient(addr string) *Client {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
-	return &Client{
-		rdb:  rdb,
-		cron: cron.New(cron.WithSeconds()),
+	c := &Client{
+		rdb:         rdb,
+		cron:        cron.New(cron.WithParser(cronParser)),
+		cronEntries: make(map[string]cronEntry),
+	}
+	c.cronLeader = NewLeaderElection(c, "cron", cronLeaderLease)
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptLoadTimeout)
+	defer cancel()
+	if err := c.loadScripts(ctx); err != nil {
+		logger.Log.Warn().Err(err).Msg("Failed to preload Lua scripts")
 	}
+	return c
 }
 
 // Enqueue adds a new task to the appropriate priority queue.
@@ -56,21 +75,74 @@ func NewClient(addr string) *Client {
 //   - High (2) -> queue:high
 //   - Default (1) -> queue:default
 //   - Low (0) -> queue:low
+//
+// A task with an OrderingKey waits in "ordering:{key}" instead while another task
+// of the same key is queued or running, and is released when that task completes
+// or is dead-lettered, so that tasks of a key run one at a time, in order.
+//
+// A task with a SingletonKey is subject to its SingletonPolicy: with SingletonSkip,
+// ErrSingletonSkipped is returned if another task of the key is pending or running;
+// with SingletonQueue, the task waits in "singleton:{key}:waiting" until the tasks
+// of the key before it have finished; with SingletonReplace, the pending task of
+// the key, if any, is dropped. It cannot also have an OrderingKey.
+//
+// A task with a DebounceKey or a ThrottleKey may be delayed in the delayed_queue
+// (and so released to queue:default by StartScheduler) and may replace an earlier
+// task of its key waiting there, which then never runs.
+//
+// A task with an AggregationKey is added to the group of its key, which is pushed
+// to the queue as a single batch task once full, or by StartAggregator once its
+// delay has elapsed.
 func (c *Client) Enqueue(ctx context.Context, task tasks.Task) error {
 	data, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
 
-	queueName := "queue:default"
-	switch task.Priority {
+	if task.AggregationKey != "" && !task.Aggregated {
+		keys, args, err := enqueueAggregatedArgs(task, data, time.Now())
+		if err != nil {
+			return err
+		}
+		return c.runScript(ctx, enqueueAggregatedScript, keys, args...).Err()
+	}
+
+	script, keys, args, err := windowedEnqueue(task, data, time.Now())
+	if err != nil {
+		return err
+	}
+	if script != nil {
+		return c.runScript(ctx, script, keys, args...).Err()
+	}
+
+	if task.SingletonKey != "" {
+		policy, err := singletonPolicy(task)
+		if err != nil {
+			return err
+		}
+		keys, args := enqueueSingletonArgs(task, policy, data)
+		enqueued, err := c.runScript(ctx, enqueueSingletonScript, keys, args...).Int()
+		if err == nil && enqueued == 0 {
+			return ErrSingletonSkipped
+		}
+		return err
+	}
+
+	if task.OrderingKey != "" {
+		return c.runScript(ctx, enqueueOrderedScript, enqueueOrderedKeys(task), data, task.ID).Err()
+	}
+	return c.rdb.RPush(ctx, queueName(task.Priority), data).Err()
+}
+
+// queueName returns the name of the list holding tasks of the given priority.
+func queueName(priority int) string {
+	switch priority {
 	case tasks.PriorityHigh:
-		queueName = "queue:high"
+		return "queue:high"
 	case tasks.PriorityLow:
-		queueName = "queue:low"
+		return "queue:low"
 	}
-
-	return c.rdb.RPush(ctx, queueName, data).Err()
+	return "queue:default"
 }
 
 // Dequeue atomically retrieves a task from the highest priority queue available.
@@ -81,26 +153,38 @@ func (c *Client) Enqueue(ctx context.Context, task tasks.Task) error {
 //
 // It uses BLMove with a 1-second timeout for each queue to ensure responsiveness.
 // If no task is found in any queue, it returns redis.Nil.
+//
+// Tasks whose ExpiresAt deadline has passed are never returned: they are archived
+// in the expired_queue and Dequeue moves on to the next task.
 func (c *Client) Dequeue(ctx context.Context) (*tasks.Task, string, error) {
-	queues := []string{"queue:high", "queue:default", "queue:low"}
+	for _, q := range dequeueQueues {
+		for {
+			// Try to move from current priority queue to processing_queue
+			// Use 1s timeout to allow falling through to lower priorities if empty
+			result, err := c.rdb.BLMove(ctx, q, "processing_queue", "LEFT", "RIGHT", 1*time.Second).Result()
+			if err == redis.Nil {
+				// Timeout/empty, continue to next priority queue
+				break
+			}
+			if err != nil {
+				// Real error (not just empty queue)
+				return nil, "", err
+			}
 
-	for _, q := range queues {
-		// Try to move from current priority queue to processing_queue
-		// Use 1s timeout to allow falling through to lower priorities if empty
-		result, err := c.rdb.BLMove(ctx, q, "processing_queue", "LEFT", "RIGHT", 1*time.Second).Result()
-		if err == nil {
 			// Task found!
 			var task tasks.Task
 			if err := json.Unmarshal([]byte(result), &task); err != nil {
 				return nil, "", err
 			}
-			return &task, result, nil
-		}
-		if err != redis.Nil {
-			// Real error (not just empty queue)
-			return nil, "", err
+			if !task.Expired(time.Now()) {
+				return &task, result, nil
+			}
+
+			// Skip expired tasks and keep looking in the same queue
+			if err := c.expire(ctx, task, result); err != nil {
+				return nil, "", err
+			}
 		}
-		// If redis.Nil (timeout/empty), continue to next priority queue
 	}
 
 	// No tasks found in any queue after checking all
@@ -121,16 +205,32 @@ func (c *Client) Ack(ctx context.Context, rawTask string) error {
 
 // Complete acknowledges successful completion of a task by moving it to the completed_queue.
 // It keeps the last 100 completed tasks for history.
+//
+// Unlike CompleteWithResult no result is stored, but the same follow-up actions
+// run for the task afterwards (see onTaskCompleted).
 func (c *Client) Complete(ctx context.Context, rawTask string) error {
+	var task tasks.Task
+	if err := json.Unmarshal([]byte(rawTask), &task); err != nil {
+		return err
+	}
+
 	pipe := c.rdb.TxPipeline()
+	queueCompletion(ctx, pipe, rawTask)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	return c.onTaskCompleted(ctx, task, nil)
+}
+
+// queueCompletion adds the commands that move a task from processing_queue to completed_queue.
+func queueCompletion(ctx context.Context, pipe redis.Pipeliner, rawTask string) {
 	// Remove from processing_queue
 	pipe.LRem(ctx, "processing_queue", 1, rawTask)
 	// Add to completed_queue
 	pipe.RPush(ctx, "completed_queue", rawTask)
 	// Trim to last 100 (keep tail)
 	pipe.LTrim(ctx, "completed_queue", -100, -1)
-	_, err := pipe.Exec(ctx)
-	return err
 }
 
 // Retry schedules a failed task for retry with exponential backoff.
@@ -147,6 +247,9 @@ func (c *Client) Complete(ctx context.Context, rawTask string) error {
 //   - task: The task to retry (will be modified with incremented RetryCount)
 //   - rawTask: The original raw JSON string from Dequeue
 //
+// If the retry would run past the task's ExpiresAt deadline, the task is archived
+// as expired instead and ErrTaskExpired is returned.
+//
 // Returns an error if serialization or Redis pipeline execution fails.
 func (c *Client) Retry(ctx context.Context, task tasks.Task, rawTask string) error {
 	// 1. Increment RetryCount
@@ -155,6 +258,12 @@ func (c *Client) Retry(ctx context.Context, task tasks.Task, rawTask string) err
 	// 2. Calculate Backoff (Exponential: 2^retry * 100ms)
 	backoff := time.Duration(1<<task.RetryCount) * 100 * time.Millisecond
 	processAt := time.Now().Add(backoff)
+	if task.Expired(processAt) {
+		if err := c.expire(ctx, task, rawTask); err != nil {
+			return err
+		}
+		return ErrTaskExpired
+	}
 
 	newTaskData, err := json.Marshal(task)
 	if err != nil {
@@ -174,6 +283,38 @@ func (c *Client) Retry(ctx context.Context, task tasks.Task, rawTask string) err
 	return err
 }
 
+// Requeue puts a task back into the delayed queue to be processed after delay,
+// without counting an attempt: unlike Retry, task.RetryCount is left unchanged.
+// It is meant for tasks that were not run at all, e.g. because a rate or
+// concurrency limit was reached.
+//
+// Unlike retried tasks, which are released to queue:default, a requeued task
+// goes back to the queue of its priority.
+//
+// If the task would become available past its ExpiresAt deadline, it is archived
+// as expired instead and ErrTaskExpired is returned.
+func (c *Client) Requeue(ctx context.Context, task tasks.Task, rawTask string, delay time.Duration) error {
+	processAt := time.Now().Add(delay)
+	if task.Expired(processAt) {
+		if err := c.expire(ctx, task, rawTask); err != nil {
+			return err
+		}
+		return ErrTaskExpired
+	}
+
+	pipe := c.rdb.TxPipeline()
+	pipe.ZAdd(ctx, "delayed_queue", redis.Z{
+		Score:  float64(processAt.UnixNano()),
+		Member: rawTask,
+	})
+	if queue := queueName(task.Priority); queue != "queue:default" {
+		pipe.HSet(ctx, requeuedQueuesKey, rawTask, queue)
+	}
+	pipe.LRem(ctx, "processing_queue", 1, rawTask)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
 // Fail moves a permanently failed task to the Dead Letter Queue (DLQ).
 // This should be called when a task has exceeded the maximum retry attempts.
 //
@@ -181,6 +322,10 @@ func (c *Client) Retry(ctx context.Context, task tasks.Task, rawTask string) err
 //  1. Serializes the task to JSON
 //  2. Adds it to the dead_letter_queue
 //  3. Removes it from processing_queue
+//  4. Stores a "failed" TaskResult carrying task.LastError, so that
+//     clients waiting on the result are notified
+//
+// Chain and group bookkeeping for the task runs afterwards (see onTaskFailed).
 //
 // Tasks in the DLQ can be inspected for debugging or manually replayed.
 //
@@ -196,84 +341,26 @@ func (c *Client) Fail(ctx context.Context, task tasks.Task, rawTask string) erro
 		return err
 	}
 
+	result, err := json.Marshal(TaskResult{
+		TaskID:     task.ID,
+		Status:     ResultFailed,
+		Error:      task.LastError,
+		FinishedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
 	pipe := c.rdb.TxPipeline()
 	pipe.RPush(ctx, "dead_letter_queue", data)
 	pipe.LRem(ctx, "processing_queue", 1, rawTask)
+	queueResult(ctx, pipe, task.ID, result, resultTTL(task))
 
-	_, err = pipe.Exec(ctx)
-	return err
-}
-
-// StartScheduler runs a background process that periodically checks the delayed queue
-// and moves tasks that are ready to be processed back to the main queue.
-//
-// This function runs in an infinite loop until the context is cancelled.
-// It checks the delayed queue every 500ms for tasks whose scheduled time has arrived.
-//
-// Thread Safety:
-// The scheduler uses a Lua script to ensure atomic operations when multiple
-// scheduler instances run concurrently. The script atomically:
-//  1. Fetches all tasks with score (timestamp) <= now from delayed_queue
-//  2. Removes them from delayed_queue
-//  3. Pushes them to main_queue
-//
-// This prevents race conditions where the same delayed task might be processed
-// multiple times by different scheduler instances.
-//
-// Usage:
-//
-//	ctx := context.Background()
-//	go client.StartScheduler(ctx)
-//
-// The scheduler will gracefully shut down when the context is cancelled.
-func (c *Client) StartScheduler(ctx context.Context) {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	// Lua script for atomic operations: get tasks, remove from ZSET, push to main queue
-	luaScript := redis.NewScript(`
-		local delayed_key = KEYS[1]
-		local main_queue_key = KEYS[2]
-		local now = tonumber(ARGV[1])
-		
-		-- Get all tasks with score <= now
-		local tasks = redis.call('ZRANGEBYSCORE', delayed_key, '-inf', now)
-		
-		if #tasks > 0 then
-			-- Remove from delayed queue
-			redis.call('ZREMRANGEBYSCORE', delayed_key, '-inf', now)
-			
-			-- Push to main queue
-			for _, task in ipairs(tasks) do
-				-- Push to default queue (scheduler doesn't know priority, defaulting to normal)
-				-- Improvement: Store priority in ZSET member or check task body
-				-- For now, we assume retried tasks go to default queue
-				redis.call('RPUSH', 'queue:default', task)
-			end
-		end
-		
-		return #tasks
-	`)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			now := float64(time.Now().UnixNano())
-
-			// Execute Lua script atomically
-			_, err := luaScript.Run(ctx, c.rdb,
-				[]string{"delayed_queue", "queue:default"}, // Defaulting retries to default queue
-				now,
-			).Result()
-
-			if err != nil && err != redis.Nil {
-				// Log error but continue
-				logger.Log.Error().Err(err).Msg("Scheduler error")
-			}
-		}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
 	}
+
+	return c.onTaskFailed(ctx, task)
 }
 
 // GetQueueDepths returns the current depth (number of items) for all queues.
@@ -282,7 +369,7 @@ func (c *Client) GetQueueDepths(ctx context.Context) map[string]int64 {
 	depths := make(map[string]int64)
 
 	// List queues
-	queues := []string{"queue:high", "queue:default", "queue:low", "processing_queue", "dead_letter_queue"}
+	queues := []string{"queue:high", "queue:default", "queue:low", "processing_queue", "dead_letter_queue", expiredQueue}
 	for _, q := range queues {
 		if len, err := c.rdb.LLen(ctx, q).Result(); err == nil {
 			depths[q] = len
@@ -297,64 +384,34 @@ func (c *Client) GetQueueDepths(ctx context.Context) map[string]int64 {
 	return depths
 }
 
-// SetResult stores the result of a task execution in Redis with a 24-hour TTL.
+// SetResult stores the result of a task execution in Redis with the default 24-hour TTL.
 // The result is stored as a JSON string under the key "result:{taskID}".
 func (c *Client) SetResult(ctx context.Context, taskID string, result interface{}) error {
+	return c.SetResultWithTTL(ctx, taskID, result, DefaultResultTTL)
+}
+
+// SetResultWithTTL stores the result of a task execution in Redis, retaining it for ttl.
+// Clients blocked in WaitResult for the task are notified via Pub/Sub.
+func (c *Client) SetResultWithTTL(ctx context.Context, taskID string, result interface{}, ttl time.Duration) error {
 	data, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
-	return c.rdb.Set(ctx, fmt.Sprintf("result:%s", taskID), data, 24*time.Hour).Err()
+
+	pipe := c.rdb.TxPipeline()
+	queueResult(ctx, pipe, taskID, data, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 // GetResult retrieves the result of a task execution from Redis.
 // Returns the result as a raw JSON string.
 func (c *Client) GetResult(ctx context.Context, taskID string) (string, error) {
-	return c.rdb.Get(ctx, fmt.Sprintf("result:%s", taskID)).Result()
-}
-
-// Schedule registers a new cron job that enqueues the specified task according to the cron spec.
-// The spec should be a standard cron expression (e.g., "* * * * *").
-func (c *Client) Schedule(ctx context.Context, spec string, task tasks.Task) (cron.EntryID, error) {
-	return c.cron.AddFunc(spec, func() {
-		// Create a new context for the background job
-		bgCtx := context.Background()
-
-		// Update CreatedAt to now
-		task.CreatedAt = time.Now()
-
-		// Generate new ID for each run to avoid duplicates if ID was static
-		// Or keep static ID if intended? Usually cron jobs spawn new unique tasks.
-		// Let's generate a new ID to be safe and trackable.
-		// But we need to import uuid here? Or just let the caller handle it?
-		// If we change ID here, we need uuid.
-		// Let's assume the caller provides a template task.
-		// We should probably clone it and give it a new ID.
-		// For simplicity, we'll just enqueue it as is, but updating CreatedAt.
-		// If ID is reused, it might be confusing in logs but Redis lists don't care about unique IDs.
-		// Ideally we should generate a new ID.
-
-		if err := c.Enqueue(bgCtx, task); err != nil {
-			logger.Log.Error().Err(err).Str("spec", spec).Msg("Failed to enqueue scheduled task")
-		} else {
-			logger.Log.Info().Str("type", task.Type).Str("spec", spec).Msg("Scheduled task enqueued")
-		}
-	})
-}
-
-// StartCronScheduler starts the cron scheduler in a background goroutine.
-// It should be called once when the application starts (e.g., in the server).
-func (c *Client) StartCronScheduler() {
-	c.cron.Start()
-}
-
-// StopCronScheduler stops the cron scheduler.
-func (c *Client) StopCronScheduler() {
-	c.cron.Stop()
+	return c.rdb.Get(ctx, resultKey(taskID)).Result()
 }
 
 // Allow checks if a task of a specific type is allowed to proceed based on the rate limit.
-// It uses a Token Bucket algorithm implemented in Lua.
+// It uses a Token Bucket algorithm implemented in Lua (see TokenBucket).
 //
 // Parameters:
 //   - ctx: Context
@@ -364,54 +421,55 @@ func (c *Client) StopCronScheduler() {
 //
 // Returns true if allowed, false otherwise.
 func (c *Client) Allow(ctx context.Context, key string, limit int, burst int) (bool, error) {
-	// Lua script for Token Bucket
-	// KEYS[1]: Rate limit key
-	// ARGV[1]: Rate (tokens/sec)
-	// ARGV[2]: Burst (capacity)
-	// ARGV[3]: Current timestamp (seconds)
-	// ARGV[4]: Tokens to consume (1)
-	luaScript := redis.NewScript(`
-		local key = KEYS[1]
-		local rate = tonumber(ARGV[1])
-		local burst = tonumber(ARGV[2])
-		local now = tonumber(ARGV[3])
-		local requested = tonumber(ARGV[4])
-		
-		local tokens = tonumber(redis.call('HGET', key, 'tokens'))
-		local last_refill = tonumber(redis.call('HGET', key, 'last_refill'))
-		
-		if not tokens then
-			tokens = burst
-			last_refill = now
-		end
-		
-		-- Refill tokens
-		local delta = math.max(0, now - last_refill)
-		local new_tokens = math.min(burst, tokens + (delta * rate))
-		
-		if new_tokens >= requested then
-			new_tokens = new_tokens - requested
-			redis.call('HSET', key, 'tokens', new_tokens, 'last_refill', now)
-			return 1 -- Allowed
-		else
-			redis.call('HSET', key, 'tokens', new_tokens, 'last_refill', now)
-			return 0 -- Denied
-		end
-	`)
-
-	result, err := luaScript.Run(ctx, c.rdb,
-		[]string{key},
-		limit,
-		burst,
-		time.Now().Unix(),
-		1,
-	).Result()
-
+	delay, err := c.RateLimitDelay(ctx, key, limit, burst)
 	if err != nil {
 		return false, err
 	}
+	return delay == 0, nil
+}
 
-	return result.(int64) == 1, nil
+// RateLimitDelay is like Allow but, when the bucket is empty, also reports how long
+// until it refills enough for the next token: it returns zero if a token was taken,
+// and the refill time otherwise. Workers use it to postpone denied tasks with
+// Requeue for just as long as needed.
+func (c *Client) RateLimitDelay(ctx context.Context, key string, limit int, burst int) (time.Duration, error) {
+	return NewTokenBucket(c, float64(limit), burst).Reserve(ctx, key)
+}
+
+// RateLimitsDelay is like RateLimitDelay for several token buckets that all apply
+// to the same task: a token is taken from every bucket if each of them has one,
+// and from none of them otherwise, in which case the longest refill time of the
+// denying buckets is returned. This way a task denied by one limit never uses up
+// the quota of the others.
+func (c *Client) RateLimitsDelay(ctx context.Context, buckets []RateLimitBucket) (time.Duration, error) {
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, len(buckets))
+	args := []interface{}{time.Now().UnixMilli()}
+	for i, bucket := range buckets {
+		keys[i] = bucket.Key
+		args = append(args, bucket.Limit, bucket.Burst)
+	}
+
+	wait, err := c.runScript(ctx, tokenBucketsScript, keys, args...).Int64()
+	return time.Duration(wait) * time.Millisecond, err
+}
+
+// RefundRateLimits gives back the tokens taken by RateLimitsDelay, for a task
+// that was admitted by its rate limits but could not run after all (e.g. because
+// the lock of its singleton key was held).
+func (c *Client) RefundRateLimits(ctx context.Context, buckets []RateLimitBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		keys[i] = bucket.Key
+		args[i] = bucket.Burst
+	}
+	return c.runScript(ctx, refundTokenBucketsScript, keys, args...).Err()
 }
 
 // InspectQueue retrieves the first n tasks from a specific queue without removing them.
@@ -28,7 +28,7 @@ func TestEnqueue(t *testing.T) {
 	task := tasks.Task{
 		ID:        "test-id",
 		Type:      "email",
-		Payload:   map[string]string{"to": "test@example.com"},
+		Payload:   json.RawMessage(`{"to":"test@example.com"}`),
 		CreatedAt: time.Now(),
 		Priority:  tasks.PriorityDefault,
 	}
@@ -40,7 +40,7 @@ func TestEnqueue(t *testing.T) {
 
 	// Verify task is in Redis using direct redis client connection to miniredis
 	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
-	len, _ := rdb.LLen(ctx, "queue:default").Result()
+	len, _ := rdb.LLen(ctx, queueName(task.Priority)).Result()
 	if len != 1 {
 		t.Errorf("Expected queue:default length 1, got %d", len)
 	}
@@ -122,6 +122,52 @@ func TestRetry(t *testing.T) {
 	}
 }
 
+func TestRequeue(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.Enqueue(ctx, tasks.Task{ID: "test-id", Type: "test", Priority: tasks.PriorityHigh, RetryCount: 1})
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+
+	if err := client.Requeue(ctx, *task, raw, 5*time.Second); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	delayed, _ := rdb.ZRangeWithScores(ctx, "delayed_queue", 0, -1).Result()
+	if len(delayed) != 1 {
+		t.Fatalf("Expected 1 task in delayed_queue, got %d", len(delayed))
+	}
+	if delayed[0].Score < float64(time.Now().Add(4*time.Second).UnixNano()) {
+		t.Error("Expected the task to be delayed by about 5s")
+	}
+
+	// The attempt is not counted
+	var requeued tasks.Task
+	json.Unmarshal([]byte(delayed[0].Member.(string)), &requeued)
+	if requeued.RetryCount != 1 {
+		t.Errorf("Expected RetryCount to stay 1, got %d", requeued.RetryCount)
+	}
+	if depth := client.GetQueueDepths(ctx)["processing_queue"]; depth != 0 {
+		t.Errorf("Expected processing_queue to be empty, got %d", depth)
+	}
+
+	// Once due, the task goes back to its own priority queue
+	if _, err := client.promoteDelayed(ctx, time.Now().Add(5*time.Second), delayedBatchSize); err != nil {
+		t.Fatalf("promoteDelayed failed: %v", err)
+	}
+	if depths := client.GetQueueDepths(ctx); depths["queue:high"] != 1 || depths["queue:default"] != 0 {
+		t.Errorf("Expected the task back in queue:high, got %v", depths)
+	}
+	if s.Exists(requeuedQueuesKey) {
+		t.Error("Expected the original queue of the task to be forgotten")
+	}
+}
+
 func TestTaskResult(t *testing.T) {
 	s, client := setupTestRedis()
 	defer s.Close()
@@ -183,7 +229,7 @@ func TestSchedule(t *testing.T) {
 
 	// Verify task is in Redis
 	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
-	len, _ := rdb.LLen(ctx, "queue:default").Result()
+	len, _ := rdb.LLen(ctx, queueName(task.Priority)).Result()
 	if len < 1 {
 		t.Errorf("Expected at least 1 scheduled task, got %d", len)
 	}
@@ -228,3 +274,70 @@ func TestRateLimit(t *testing.T) {
 		t.Error("Expected third call to be allowed after refill")
 	}
 }
+
+func TestRateLimitDelay(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	key := "ratelimit:test"
+
+	// Burst of 2 tokens at 2 tokens/sec
+	for i := 0; i < 2; i++ {
+		delay, err := client.RateLimitDelay(ctx, key, 2, 2)
+		if err != nil {
+			t.Fatalf("RateLimitDelay failed: %v", err)
+		}
+		if delay != 0 {
+			t.Fatalf("Expected call %d to be allowed, got delay %s", i, delay)
+		}
+	}
+
+	// The bucket is empty: the delay is the time until the next refill
+	delay, err := client.RateLimitDelay(ctx, key, 2, 2)
+	if err != nil {
+		t.Fatalf("RateLimitDelay failed: %v", err)
+	}
+	if delay <= 0 || delay > time.Second {
+		t.Fatalf("Expected a delay up to 1s, got %s", delay)
+	}
+
+	time.Sleep(delay)
+	if delay, _ := client.RateLimitDelay(ctx, key, 2, 2); delay != 0 {
+		t.Errorf("Expected a token after waiting, got delay %s", delay)
+	}
+}
+
+func TestRateLimitsDelay(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	buckets := []RateLimitBucket{
+		{Key: "ratelimit:email", Limit: 1, Burst: 5},
+		{Key: "ratelimit:queue:high", Limit: 1, Burst: 1},
+	}
+
+	if delay, err := client.RateLimitsDelay(ctx, buckets); err != nil || delay != 0 {
+		t.Fatalf("Expected the first task to be allowed, got %s (%v)", delay, err)
+	}
+
+	// The queue bucket is empty: no token is taken from the type bucket either
+	if delay, _ := client.RateLimitsDelay(ctx, buckets); delay <= 0 || delay > time.Second {
+		t.Fatalf("Expected a delay up to 1s, got %s", delay)
+	}
+	if tokens := s.HGet("ratelimit:email", "tokens"); tokens != "4" {
+		t.Errorf("Expected 4 tokens left in the type bucket, got %s", tokens)
+	}
+
+	// A refund gives the tokens back, up to the capacity
+	if err := client.RefundRateLimits(ctx, buckets); err != nil {
+		t.Fatalf("RefundRateLimits failed: %v", err)
+	}
+	if tokens := s.HGet("ratelimit:email", "tokens"); tokens != "5" {
+		t.Errorf("Expected the type bucket to be full again, got %s", tokens)
+	}
+	if delay, _ := client.RateLimitsDelay(ctx, buckets); delay != 0 {
+		t.Errorf("Expected the refunded token to be available, got delay %s", delay)
+	}
+}
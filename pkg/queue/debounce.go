@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// debounceGrace is how long a debounced task is remembered after its release time,
+// so that a task enqueued while the scheduler is about to release it still replaces it.
+const debounceGrace = time.Minute
+
+// debounceKey returns the Redis key holding the pending task of a debounce key.
+func debounceKey(key string) string {
+	return fmt.Sprintf("debounce:%s", key)
+}
+
+// throttleKey returns the Redis hash holding the state of a throttle key.
+func throttleKey(key string) string {
+	return fmt.Sprintf("throttle:%s", key)
+}
+
+// enqueueDebouncedScript delays a task with a DebounceKey by its window, replacing
+// the task of the same key still waiting in the delayed queue, if any.
+//
+// KEYS[1]: Pending task of the debounce key
+// KEYS[2]: Delayed queue
+// ARGV[1]: Task JSON
+// ARGV[2]: Release time (Unix ns)
+// ARGV[3]: Time to remember the pending task (ms)
+var enqueueDebouncedScript = newScript(`
+	local pending = redis.call('GET', KEYS[1])
+	if pending then
+		redis.call('ZREM', KEYS[2], pending)
+	end
+	redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+	return 1
+`)
+
+// enqueueThrottledScript enqueues a task with a ThrottleKey right away if the window
+// of its key is closed, and otherwise delays it to the start of the next window,
+// replacing the task already waiting for it, if any.
+//
+// Redis layout (hash "throttle:{key}"): next (Unix ms at which the next window
+// starts), pending (task waiting in the delayed queue).
+//
+// KEYS[1]: Throttle state
+// KEYS[2]: Priority queue of the task
+// KEYS[3]: Delayed queue
+// ARGV[1]: Task JSON
+// ARGV[2]: Current timestamp (ms)
+// ARGV[3]: Window (ms)
+// Returns 1 if the task was enqueued right away, 0 if it was delayed.
+var enqueueThrottledScript = newScript(`
+	local now = tonumber(ARGV[2])
+	local window = tonumber(ARGV[3])
+	local state = redis.call('HMGET', KEYS[1], 'next', 'pending')
+	local next = tonumber(state[1]) or 0
+
+	-- A task already waits for the next window: take its place
+	if state[2] then
+		local score = redis.call('ZSCORE', KEYS[3], state[2])
+		if score then
+			redis.call('ZREM', KEYS[3], state[2])
+			redis.call('ZADD', KEYS[3], score, ARGV[1])
+			redis.call('HSET', KEYS[1], 'pending', ARGV[1])
+			return 0
+		end
+	end
+
+	if next <= now then
+		redis.call('RPUSH', KEYS[2], ARGV[1])
+		redis.call('HSET', KEYS[1], 'next', now + window)
+		redis.call('HDEL', KEYS[1], 'pending')
+		redis.call('PEXPIRE', KEYS[1], window)
+		return 1
+	end
+
+	-- Scores of the delayed queue are in ns, beyond the integer precision of
+	-- Lua numbers once formatted, hence the explicit format
+	redis.call('ZADD', KEYS[3], string.format('%.0f', next * 1000000), ARGV[1])
+	redis.call('HSET', KEYS[1], 'next', next + window, 'pending', ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], next + window - now)
+	return 0
+`)
+
+// windowedEnqueue returns the script enqueuing a debounced or throttled task, with
+// its KEYS and ARGV, or a nil script if the task is neither.
+//
+// Both modes go through the delayed queue, which StartScheduler releases, so they
+// cannot be combined with each other, with an OrderingKey or with a SingletonKey.
+func windowedEnqueue(task tasks.Task, data []byte, now time.Time) (*redis.Script, []string, []interface{}, error) {
+	if task.DebounceKey == "" && task.ThrottleKey == "" {
+		return nil, nil, nil, nil
+	}
+	if task.DebounceKey != "" && task.ThrottleKey != "" {
+		return nil, nil, nil, errors.New("a task cannot be both debounced and throttled")
+	}
+	if task.OrderingKey != "" || task.SingletonKey != "" {
+		return nil, nil, nil, errors.New("debounced and throttled tasks cannot have an ordering or singleton key")
+	}
+
+	if task.DebounceKey != "" {
+		if task.DebounceWindow <= 0 {
+			return nil, nil, nil, errors.New("debounce window must be positive")
+		}
+		keys := []string{debounceKey(task.DebounceKey), "delayed_queue"}
+		args := []interface{}{data, now.Add(task.DebounceWindow).UnixNano(), (task.DebounceWindow + debounceGrace).Milliseconds()}
+		return enqueueDebouncedScript, keys, args, nil
+	}
+
+	if task.ThrottleWindow <= 0 {
+		return nil, nil, nil, errors.New("throttle window must be positive")
+	}
+	keys := []string{throttleKey(task.ThrottleKey), queueName(task.Priority), "delayed_queue"}
+	args := []interface{}{data, now.UnixMilli(), task.ThrottleWindow.Milliseconds()}
+	return enqueueThrottledScript, keys, args, nil
+}
@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// delayedEntries returns the IDs and release times of the tasks in the delayed queue.
+func delayedEntries(t *testing.T, client *Client) map[string]time.Time {
+	t.Helper()
+	entries, err := client.rdb.ZRangeWithScores(context.Background(), "delayed_queue", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("ZRange failed: %v", err)
+	}
+	delayed := make(map[string]time.Time)
+	for _, entry := range entries {
+		var task tasks.Task
+		if err := json.Unmarshal([]byte(entry.Member.(string)), &task); err != nil {
+			t.Fatalf("Invalid task %v: %v", entry.Member, err)
+		}
+		delayed[task.ID] = time.Unix(0, int64(entry.Score))
+	}
+	return delayed
+}
+
+func TestDebounceRunsLastTask(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, id := range []string{"d1", "d2", "d3"} {
+		task := tasks.Task{ID: id, Type: "reindex", Priority: tasks.PriorityHigh, DebounceKey: "doc-42", DebounceWindow: time.Minute}
+		if err := client.Enqueue(ctx, task); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	delayed := delayedEntries(t, client)
+	releaseAt, ok := delayed["d3"]
+	if len(delayed) != 1 || !ok {
+		t.Fatalf("Expected only d3 to be delayed, got %v", delayed)
+	}
+	if releaseAt.Before(start.Add(time.Minute)) || releaseAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected d3 to be released a window after it was enqueued, got %v", releaseAt)
+	}
+	if ids := queuedIDs(t, client); len(ids) != 0 {
+		t.Errorf("Expected no task to run before the window, got %v", ids)
+	}
+
+	// Another key is debounced independently
+	client.Enqueue(ctx, tasks.Task{ID: "other", Type: "reindex", DebounceKey: "doc-7", DebounceWindow: time.Minute})
+	if delayed := delayedEntries(t, client); len(delayed) != 2 {
+		t.Errorf("Expected 2 delayed tasks, got %v", delayed)
+	}
+}
+
+func TestThrottleAllowsOnePerWindow(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	throttled := func(id string) tasks.Task {
+		return tasks.Task{ID: id, Type: "reindex", Priority: tasks.PriorityHigh, ThrottleKey: "doc-42", ThrottleWindow: time.Minute}
+	}
+
+	start := time.Now()
+	for _, id := range []string{"t1", "t2", "t3"} {
+		if err := client.Enqueue(ctx, throttled(id)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	// t1 runs right away, t3 replaced t2 for the next window
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "t1" {
+		t.Fatalf("Expected t1 to be queued, got %v", ids)
+	}
+	delayed := delayedEntries(t, client)
+	releaseAt, ok := delayed["t3"]
+	if len(delayed) != 1 || !ok {
+		t.Fatalf("Expected only t3 to be delayed, got %v", delayed)
+	}
+	if releaseAt.Before(start.Add(time.Minute-time.Millisecond)) || releaseAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected t3 to be released a window after t1, got %v", releaseAt)
+	}
+
+	// Once the scheduler released t3, the next task waits for the window after
+	client.rdb.ZRemRangeByRank(ctx, "delayed_queue", 0, -1)
+	if err := client.Enqueue(ctx, throttled("t4")); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	releaseAt = delayedEntries(t, client)["t4"]
+	if releaseAt.Before(start.Add(2*time.Minute-time.Millisecond)) || releaseAt.After(time.Now().Add(2*time.Minute)) {
+		t.Errorf("Expected t4 to be released two windows after t1, got %v", releaseAt)
+	}
+}
+
+func TestThrottleWindowReopens(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, id := range []string{"t1", "t2"} {
+		task := tasks.Task{ID: id, Type: "reindex", Priority: tasks.PriorityHigh, ThrottleKey: "doc-42", ThrottleWindow: 50 * time.Millisecond}
+		if err := client.Enqueue(ctx, task); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+
+	if ids := queuedIDs(t, client); len(ids) != 2 {
+		t.Errorf("Expected both tasks to run right away, got %v", ids)
+	}
+}
+
+func TestWindowedEnqueueValidation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, task := range []tasks.Task{
+		{ID: "a", DebounceKey: "k"},
+		{ID: "b", ThrottleKey: "k", ThrottleWindow: -time.Second},
+		{ID: "c", DebounceKey: "k", DebounceWindow: time.Second, ThrottleKey: "k", ThrottleWindow: time.Second},
+		{ID: "d", DebounceKey: "k", DebounceWindow: time.Second, OrderingKey: "k"},
+	} {
+		if err := client.Enqueue(ctx, task); err == nil {
+			t.Errorf("Expected an error for task %s", task.ID)
+		}
+	}
+
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{
+		{ID: "b1", DebounceKey: "k", DebounceWindow: time.Minute},
+		{ID: "b2", DebounceKey: "k", DebounceWindow: time.Minute},
+		{ID: "b3", ThrottleKey: "k"},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueBatch failed: %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("Unexpected item errors: %v", errs)
+	}
+	if delayed := delayedEntries(t, client); len(delayed) != 1 {
+		t.Errorf("Expected the batch to debounce b1, got %v", delayed)
+	}
+}
@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+)
+
+const (
+	// delayedBatchSize caps the number of due tasks released per script call, so
+	// that a huge backlog (e.g. after an outage) never blocks Redis for long.
+	delayedBatchSize = 1000
+
+	// schedulerMinInterval is the pause between two batches while a backlog of due
+	// tasks is being released, letting other clients run in between.
+	schedulerMinInterval = 10 * time.Millisecond
+
+	// schedulerMaxInterval is the interval the scheduler backs off to while no task
+	// is due.
+	schedulerMaxInterval = 500 * time.Millisecond
+)
+
+// requeuedQueuesKey is the Redis hash recording the original queue of the tasks
+// postponed with Requeue (task JSON -> queue), when it is not queue:default.
+const requeuedQueuesKey = "requeued_queues"
+
+// promoteDelayedScript releases a batch of due tasks from the delayed queue to the
+// main queue.
+//
+// KEYS[1]: Delayed queue
+// KEYS[2]: Main queue
+// KEYS[3]: Original queues of requeued tasks
+// KEYS[4]: High priority queue
+// KEYS[5]: Low priority queue
+// ARGV[1]: Current timestamp (ns)
+// ARGV[2]: Batch size
+// Returns the number of tasks released.
+var promoteDelayedScript = newScript(`
+	local tasks = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+	if #tasks == 0 then
+		return 0
+	end
+	redis.call('ZREM', KEYS[1], unpack(tasks))
+
+	-- The scheduler doesn't know the priority of the tasks: they go to the default
+	-- queue, except the requeued tasks, which go back to the queue they came from
+	local main = tasks
+	if redis.call('HLEN', KEYS[3]) > 0 then
+		main = {}
+		for _, task in ipairs(tasks) do
+			local queue = redis.call('HGET', KEYS[3], task)
+			if queue then
+				redis.call('HDEL', KEYS[3], task)
+			end
+			if queue == KEYS[4] or queue == KEYS[5] then
+				redis.call('RPUSH', queue, task)
+			else
+				table.insert(main, task)
+			end
+		end
+	end
+	if #main > 0 then
+		redis.call('RPUSH', KEYS[2], unpack(main))
+	end
+	return #tasks
+`)
+
+// StartScheduler runs a background process that periodically checks the delayed queue
+// and moves tasks that are ready to be processed back to the main queue.
+//
+// This function runs in an infinite loop until the context is cancelled.
+// It releases due tasks in batches of delayedBatchSize, and adapts its interval
+// to the backlog: while full batches are released it runs again right away
+// (every schedulerMinInterval), and while nothing is due it backs off up to
+// schedulerMaxInterval.
+//
+// Thread Safety:
+// The scheduler uses a Lua script to ensure atomic operations when multiple
+// scheduler instances run concurrently. The script atomically:
+//  1. Fetches up to delayedBatchSize tasks with score (timestamp) <= now from delayed_queue
+//  2. Removes them from delayed_queue
+//  3. Pushes them to main_queue
+//
+// This prevents race conditions where the same delayed task might be processed
+// multiple times by different scheduler instances.
+//
+// Usage:
+//
+//	ctx := context.Background()
+//	go client.StartScheduler(ctx)
+//
+// The scheduler will gracefully shut down when the context is cancelled.
+func (c *Client) StartScheduler(ctx context.Context) {
+	interval := schedulerMinInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			promoted, err := c.promoteDelayed(ctx, time.Now(), delayedBatchSize)
+			if err != nil {
+				// Log error but continue
+				logger.Log.Error().Err(err).Msg("Scheduler error")
+			}
+			interval = nextSchedulerInterval(interval, promoted, delayedBatchSize)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// promoteDelayed releases up to limit tasks due at now from delayed_queue to
+// queue:default (or, for requeued tasks, their own queue), and returns the number
+// of tasks released.
+func (c *Client) promoteDelayed(ctx context.Context, now time.Time, limit int) (int, error) {
+	return c.runScript(ctx, promoteDelayedScript,
+		[]string{"delayed_queue", "queue:default", requeuedQueuesKey, "queue:high", "queue:low"}, // Defaulting retries to default queue
+		now.UnixNano(),
+		limit,
+	).Int()
+}
+
+// nextSchedulerInterval returns the interval before the next scheduler tick, given
+// the current one and the number of tasks released by the last batch.
+func nextSchedulerInterval(current time.Duration, promoted, limit int) time.Duration {
+	switch {
+	case promoted >= limit:
+		// More tasks are probably due: keep draining
+		return schedulerMinInterval
+	case promoted > 0:
+		// Due tasks arrive steadily: keep the pace
+		return current
+	default:
+		return min(current*2, schedulerMaxInterval)
+	}
+}
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// delayedBacklog is the number of due tasks released by BenchmarkPromoteDelayedBacklog.
+const delayedBacklog = 1_000_000
+
+// BenchmarkPromoteDelayedBacklog releases a backlog of 1M due tasks, as left by an
+// outage, while another client pings Redis. It reports the slowest batch and the
+// slowest PING: both stay bounded by the batch size, whatever the backlog.
+//
+// It needs a real Redis, since miniredis does not index sorted sets. It only runs
+// if BENCH_REDIS_ADDR is set, and uses a dedicated database of that server (see
+// redisBenchmarkClient):
+//
+//	BENCH_REDIS_ADDR=localhost:6379 go test ./pkg/queue -run '^$' -bench PromoteDelayedBacklog -benchtime 1x
+func BenchmarkPromoteDelayedBacklog(b *testing.B) {
+	client, cleanup := redisBenchmarkClient(b)
+	defer cleanup()
+	ctx := context.Background()
+
+	var maxBatch, maxPing time.Duration
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		client.rdb.Del(ctx, "delayed_queue", "queue:default")
+		addDelayed(b, client, delayedBacklog, time.Now().Add(-time.Hour))
+		b.StartTimer()
+
+		// Another client keeps using Redis while the backlog is released
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				start := time.Now()
+				client.rdb.Ping(ctx)
+				maxPing = max(maxPing, time.Since(start))
+			}
+		}()
+
+		now := time.Now()
+		for released := 0; released < delayedBacklog; {
+			start := time.Now()
+			promoted, err := client.promoteDelayed(ctx, now, delayedBatchSize)
+			if err != nil {
+				b.Fatalf("promoteDelayed failed: %v", err)
+			}
+			maxBatch = max(maxBatch, time.Since(start))
+			if promoted == 0 {
+				b.Fatalf("Released %d tasks only", released)
+			}
+			released += promoted
+		}
+		close(done)
+		wg.Wait()
+	}
+
+	b.ReportMetric(float64(maxBatch.Microseconds())/1000, "max-batch-ms")
+	b.ReportMetric(float64(maxPing.Microseconds())/1000, "max-ping-ms")
+}
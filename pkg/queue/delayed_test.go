@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// addDelayed adds n tasks to the delayed queue, due at the given time.
+func addDelayed(t testing.TB, client *Client, n int, due time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	for start := 0; start < n; start += 10000 {
+		pipe := client.rdb.Pipeline()
+		for i := start; i < min(start+10000, n); i++ {
+			pipe.ZAdd(ctx, "delayed_queue", redis.Z{
+				Score:  float64(due.UnixNano() + int64(i)),
+				Member: fmt.Sprintf(`{"id":"%s-%d"}`, due.Format(time.RFC3339), i),
+			})
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatalf("ZAdd failed: %v", err)
+		}
+	}
+}
+
+func TestPromoteDelayedBatches(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	addDelayed(t, client, 2500, now.Add(-time.Hour))
+	addDelayed(t, client, 10, now.Add(time.Hour))
+
+	for _, expected := range []int{1000, 1000, 500, 0} {
+		promoted, err := client.promoteDelayed(ctx, now, 1000)
+		if err != nil {
+			t.Fatalf("promoteDelayed failed: %v", err)
+		}
+		if promoted != expected {
+			t.Fatalf("Expected a batch of %d tasks, got %d", expected, promoted)
+		}
+	}
+
+	if n, _ := client.rdb.LLen(ctx, "queue:default").Result(); n != 2500 {
+		t.Errorf("Expected 2500 released tasks, got %d", n)
+	}
+	if n, _ := client.rdb.ZCard(ctx, "delayed_queue").Result(); n != 10 {
+		t.Errorf("Expected the 10 future tasks to stay delayed, got %d", n)
+	}
+	// Tasks are released oldest first
+	if first, _ := client.rdb.LIndex(ctx, "queue:default", 0).Result(); first != fmt.Sprintf(`{"id":"%s-0"}`, now.Add(-time.Hour).Format(time.RFC3339)) {
+		t.Errorf("Expected the oldest task first, got %s", first)
+	}
+}
+
+func TestNextSchedulerInterval(t *testing.T) {
+	for _, tt := range []struct {
+		current  time.Duration
+		promoted int
+		expected time.Duration
+	}{
+		{schedulerMaxInterval, delayedBatchSize, schedulerMinInterval}, // Backlog: drain it
+		{40 * time.Millisecond, 10, 40 * time.Millisecond},             // Steady flow
+		{40 * time.Millisecond, 0, 80 * time.Millisecond},              // Idle: back off
+		{schedulerMaxInterval, 0, schedulerMaxInterval},
+	} {
+		if got := nextSchedulerInterval(tt.current, tt.promoted, delayedBatchSize); got != tt.expected {
+			t.Errorf("nextSchedulerInterval(%v, %d) = %v, expected %v", tt.current, tt.promoted, got, tt.expected)
+		}
+	}
+}
+
+func TestStartSchedulerDrainsBacklog(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addDelayed(t, client, 3*delayedBatchSize+1, time.Now().Add(-time.Minute))
+	go client.StartScheduler(ctx)
+
+	// Full batches are released back to back, well within a single idle interval each
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if n, _ := client.rdb.LLen(ctx, "queue:default").Result(); n == 3*delayedBatchSize+1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n, _ := client.rdb.LLen(ctx, "queue:default").Result()
+	t.Fatalf("Expected the backlog to be released, got %d tasks", n)
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// expiredQueue is the list archiving tasks that expired before being processed.
+const expiredQueue = "expired_queue"
+
+// expiredHistory is the number of expired tasks kept in the expired_queue.
+const expiredHistory = 1000
+
+// ErrTaskExpired is returned by Retry when the next attempt would run past the task
+// deadline, and by EnqueueAndWait when the task expired before being processed.
+var ErrTaskExpired = errors.New("task expired")
+
+// OnTaskExpired registers a function called whenever a task is archived because its
+// deadline passed, e.g. to count expirations in a metric. It must be set before the
+// client is used to dequeue or retry tasks.
+func (c *Client) OnTaskExpired(fn func(task tasks.Task)) {
+	c.onExpired = fn
+}
+
+// expire archives a task whose deadline has passed instead of processing it.
+//
+// The task leaves the processing_queue for the expired_queue (which keeps the last
+// 1000 expired tasks) and an "expired" TaskResult is stored, so that clients waiting
+// on the result are notified. Chains, groups and workflows treat the task as failed.
+func (c *Client) expire(ctx context.Context, task tasks.Task, rawTask string) error {
+	task.LastError = ErrTaskExpired.Error()
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	result, err := json.Marshal(TaskResult{
+		TaskID:     task.ID,
+		Status:     ResultExpired,
+		Error:      task.LastError,
+		FinishedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe := c.rdb.TxPipeline()
+	pipe.LRem(ctx, "processing_queue", 1, rawTask)
+	pipe.RPush(ctx, expiredQueue, data)
+	pipe.LTrim(ctx, expiredQueue, -expiredHistory, -1)
+	queueResult(ctx, pipe, task.ID, result, resultTTL(task))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if c.onExpired != nil {
+		c.onExpired(task)
+	}
+	return c.onTaskFailed(ctx, task)
+}
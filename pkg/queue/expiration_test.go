@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestDequeueSkipsExpiredTasks(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	var expired []tasks.Task
+	client.OnTaskExpired(func(task tasks.Task) {
+		expired = append(expired, task)
+	})
+
+	now := time.Now()
+	client.Enqueue(ctx, tasks.Task{ID: "stale", Type: "password_reset", Priority: tasks.PriorityHigh, ExpiresAt: now.Add(-time.Minute)})
+	client.Enqueue(ctx, tasks.Task{ID: "fresh", Type: "password_reset", Priority: tasks.PriorityHigh, ExpiresAt: now.Add(time.Hour)})
+	client.Enqueue(ctx, tasks.Task{ID: "forever", Type: "password_reset", Priority: tasks.PriorityHigh})
+
+	for _, want := range []string{"fresh", "forever"} {
+		task, _, err := client.Dequeue(ctx)
+		if err != nil || task.ID != want {
+			t.Fatalf("Expected %s, got %+v (%v)", want, task, err)
+		}
+	}
+
+	if len(expired) != 1 || expired[0].ID != "stale" {
+		t.Fatalf("Expected the expiration hook to be called for stale, got %+v", expired)
+	}
+
+	depths := client.GetQueueDepths(ctx)
+	if depths[expiredQueue] != 1 || depths["processing_queue"] != 2 {
+		t.Errorf("Unexpected queue depths: %v", depths)
+	}
+
+	raw, err := client.GetResult(ctx, "stale")
+	if err != nil {
+		t.Fatalf("GetResult failed: %v", err)
+	}
+	var result TaskResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if result.Status != ResultExpired {
+		t.Errorf("Expected status %q, got %q", ResultExpired, result.Status)
+	}
+}
+
+func TestRetryPastDeadline(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// The next backoff (800ms) ends after the deadline
+	client.Enqueue(ctx, tasks.Task{ID: "1", Type: "test", Priority: tasks.PriorityHigh, RetryCount: 2, ExpiresAt: time.Now().Add(200 * time.Millisecond)})
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+
+	if err := client.Retry(ctx, *task, raw); !errors.Is(err, ErrTaskExpired) {
+		t.Fatalf("Expected ErrTaskExpired, got %v", err)
+	}
+
+	depths := client.GetQueueDepths(ctx)
+	if depths["delayed_queue"] != 0 || depths["processing_queue"] != 0 || depths[expiredQueue] != 1 {
+		t.Errorf("Unexpected queue depths: %v", depths)
+	}
+
+	// A retry that still fits before the deadline is scheduled as usual
+	client.Enqueue(ctx, tasks.Task{ID: "2", Type: "test", Priority: tasks.PriorityHigh, ExpiresAt: time.Now().Add(time.Hour)})
+	task, raw, _ = client.Dequeue(ctx)
+	if err := client.Retry(ctx, *task, raw); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if depth := client.GetQueueDepths(ctx)["delayed_queue"]; depth != 1 {
+		t.Errorf("Expected the retry to be scheduled, got delayed_queue depth %d", depth)
+	}
+}
+
+func TestExpiredTaskFailsGroupMember(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	_, err := client.EnqueueGroup(ctx, Group{
+		ID: "g",
+		Tasks: []tasks.Task{
+			{ID: "stale", Type: "test", Priority: tasks.PriorityHigh, ExpiresAt: time.Now().Add(-time.Second)},
+			{ID: "ok", Type: "test", Priority: tasks.PriorityHigh},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil || task.ID != "ok" {
+		t.Fatalf("Expected ok, got %+v (%v)", task, err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	state, err := client.GetGroup(ctx, "g")
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if state.State != GroupCompleted || state.Failed != 1 || state.Succeeded != 1 {
+		t.Errorf("Unexpected group state: %+v", state)
+	}
+}
@@ -0,0 +1,320 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// Group states.
+const (
+	GroupRunning   = "running"
+	GroupCompleted = "completed"
+)
+
+// groupTTL is how long group state is kept in Redis after its last update.
+const groupTTL = 7 * 24 * time.Hour
+
+// defaultGroupResultKey is the payload key under which the GroupSummary is
+// injected into the callback when Group.ResultKey is empty.
+const defaultGroupResultKey = "group"
+
+// Group is a set of tasks enqueued together and processed in parallel.
+// When every member has either completed or been moved to the Dead Letter Queue,
+// the optional Callback task is enqueued with a GroupSummary of the outcome
+// (a "chord" in Celery terms).
+type Group struct {
+	// ID identifies the group. A UUID is generated if empty.
+	ID string
+
+	// Tasks are the members of the group. Members without an ID get a generated one.
+	Tasks []tasks.Task
+
+	// Callback is enqueued once all members have finished. Its payload must be
+	// a JSON object (or empty); the GroupSummary is injected under ResultKey.
+	Callback *tasks.Task
+
+	// ResultKey is the callback payload key holding the GroupSummary ("group" if empty).
+	ResultKey string
+}
+
+// GroupSummary aggregates the outcome of all members of a group.
+type GroupSummary struct {
+	GroupID   string                     `json:"group_id"`
+	Total     int                        `json:"total"`
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Results   map[string]json.RawMessage `json:"results"`            // Task ID -> handler result
+	Failures  map[string]string          `json:"failures,omitempty"` // Task ID -> last error
+}
+
+// GroupState is the current state of a group as returned by GetGroup.
+type GroupState struct {
+	ID             string `json:"id"`
+	State          string `json:"state"`
+	Total          int    `json:"total"`
+	Succeeded      int    `json:"succeeded"`
+	Failed         int    `json:"failed"`
+	CallbackTaskID string `json:"callback_task_id,omitempty"`
+}
+
+// groupKey returns the Redis hash holding the counters of a group.
+func groupKey(groupID string) string {
+	return fmt.Sprintf("group:%s", groupID)
+}
+
+// groupResultsKey returns the Redis hash mapping member task IDs to their results.
+func groupResultsKey(groupID string) string {
+	return fmt.Sprintf("group:%s:results", groupID)
+}
+
+// groupFailuresKey returns the Redis hash mapping member task IDs to their errors.
+func groupFailuresKey(groupID string) string {
+	return fmt.Sprintf("group:%s:failures", groupID)
+}
+
+// EnqueueGroup stores the group state and enqueues all of its members.
+// It returns the group ID, which can be passed to GetGroup to follow progress.
+//
+// Redis layout:
+//   - group:{id} (hash): state, total, succeeded, failed, callback, result_key
+//   - group:{id}:results (hash): member task ID -> result JSON
+//   - group:{id}:failures (hash): member task ID -> error message
+func (c *Client) EnqueueGroup(ctx context.Context, group Group) (string, error) {
+	if len(group.Tasks) == 0 {
+		return "", errors.New("group has no tasks")
+	}
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	if group.ResultKey == "" {
+		group.ResultKey = defaultGroupResultKey
+	}
+
+	now := time.Now()
+	fields := map[string]interface{}{
+		"state":      GroupRunning,
+		"total":      len(group.Tasks),
+		"succeeded":  0,
+		"failed":     0,
+		"result_key": group.ResultKey,
+	}
+
+	if group.Callback != nil {
+		callback := *group.Callback
+		if callback.ID == "" {
+			callback.ID = uuid.New().String()
+		}
+		if _, err := payloadObject(callback.Payload); err != nil {
+			return "", fmt.Errorf("group callback: %w", err)
+		}
+		data, err := json.Marshal(callback)
+		if err != nil {
+			return "", err
+		}
+		fields["callback"] = data
+		fields["callback_id"] = callback.ID
+	}
+
+	key := groupKey(group.ID)
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, groupTTL)
+	for _, task := range group.Tasks {
+		if task.ID == "" {
+			task.ID = uuid.New().String()
+		}
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = now
+		}
+		task.GroupID = group.ID
+
+		data, err := json.Marshal(task)
+		if err != nil {
+			return "", err
+		}
+		pipe.RPush(ctx, queueName(task.Priority), data)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return group.ID, nil
+}
+
+// GetGroup returns the current state of a group.
+// It returns redis.Nil if the group does not exist or has expired.
+func (c *Client) GetGroup(ctx context.Context, groupID string) (*GroupState, error) {
+	fields, err := c.rdb.HGetAll(ctx, groupKey(groupID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+
+	state := &GroupState{
+		ID:             groupID,
+		State:          fields["state"],
+		CallbackTaskID: fields["callback_id"],
+	}
+	state.Total, _ = strconv.Atoi(fields["total"])
+	state.Succeeded, _ = strconv.Atoi(fields["succeeded"])
+	state.Failed, _ = strconv.Atoi(fields["failed"])
+	return state, nil
+}
+
+// recordGroupMemberScript records the outcome of a group member, atomically
+// counting the finished members.
+//
+// KEYS[1]: Group hash
+// KEYS[2]: Results or failures hash of the member
+// ARGV[1]: Member task ID
+// ARGV[2]: Member result or error
+// ARGV[3]: Counter to increment ("succeeded" or "failed")
+// ARGV[4]: Group TTL (seconds)
+// Returns 1 if every member has finished but the group is not finalized yet, -1 if
+// the group does not exist (e.g. it expired), 0 otherwise.
+// A member recorded twice is only counted once, but still reports an unfinalized
+// group so that a failed finalization is retried.
+var recordGroupMemberScript = newScript(`
+	local key = KEYS[1]
+	if redis.call('EXISTS', key) == 0 then
+		return -1
+	end
+	if redis.call('HSETNX', KEYS[2], ARGV[1], ARGV[2]) == 1 then
+		redis.call('EXPIRE', KEYS[2], ARGV[4])
+		redis.call('HINCRBY', key, ARGV[3], 1)
+	end
+	-- Keep the group as long as its results
+	redis.call('EXPIRE', key, ARGV[4])
+
+	local total = tonumber(redis.call('HGET', key, 'total'))
+	local done = tonumber(redis.call('HGET', key, 'succeeded')) + tonumber(redis.call('HGET', key, 'failed'))
+	if done >= total and redis.call('HGET', key, 'state') == 'running' then
+		return 1
+	end
+	return 0
+`)
+
+// finishGroupScript marks a group as completed and enqueues its callback in the
+// same step, so the callback can never be lost once the group is completed.
+//
+// KEYS[1]: Group hash
+// KEYS[2]: Queue of the callback (empty if the group has no callback)
+// ARGV[1]: Callback JSON (empty if the group has no callback)
+// ARGV[2]: Group TTL (seconds)
+// Returns 1 if the group was finalized, 0 if it already was.
+var finishGroupScript = newScript(`
+	local key = KEYS[1]
+	if redis.call('HGET', key, 'state') ~= 'running' then
+		return 0
+	end
+	-- Enqueue first: a failed RPUSH aborts the script before the group is completed
+	if ARGV[1] ~= '' then
+		redis.call('RPUSH', KEYS[2], ARGV[1])
+	end
+	redis.call('HSET', key, 'state', 'completed')
+	redis.call('EXPIRE', key, ARGV[2])
+	return 1
+`)
+
+// recordGroupMember records the final outcome of a group member.
+// Once every member has finished, the group is marked as completed and its
+// callback enqueued; recording the same member twice is a no-op.
+func (c *Client) recordGroupMember(ctx context.Context, task tasks.Task, result json.RawMessage, succeeded bool) error {
+	outcomeKey, counter, value := groupResultsKey(task.GroupID), "succeeded", string(result)
+	if !succeeded {
+		outcomeKey, counter, value = groupFailuresKey(task.GroupID), "failed", task.LastError
+	}
+	if value == "" && succeeded {
+		value = "null"
+	}
+
+	last, err := c.runScript(ctx, recordGroupMemberScript,
+		[]string{groupKey(task.GroupID), outcomeKey},
+		task.ID,
+		value,
+		counter,
+		int(groupTTL.Seconds()),
+	).Int()
+	if err != nil {
+		return err
+	}
+	switch last {
+	case -1:
+		logger.Log.Warn().Str("task_id", task.ID).Str("group_id", task.GroupID).Msg("Group not found, member outcome not recorded")
+		return nil
+	case 1:
+		return c.finishGroup(ctx, task.GroupID)
+	}
+	return nil
+}
+
+// finishGroup builds the GroupSummary of a group whose members have all finished,
+// then atomically marks the group as completed and enqueues its callback.
+// If it fails, the group stays running and the next recording of one of its
+// members tries again.
+func (c *Client) finishGroup(ctx context.Context, groupID string) error {
+	fields, err := c.rdb.HGetAll(ctx, groupKey(groupID)).Result()
+	if err != nil {
+		return err
+	}
+
+	var callbackData []byte
+	callbackQueue := ""
+	if fields["callback"] != "" {
+		results, err := c.rdb.HGetAll(ctx, groupResultsKey(groupID)).Result()
+		if err != nil {
+			return err
+		}
+		failures, err := c.rdb.HGetAll(ctx, groupFailuresKey(groupID)).Result()
+		if err != nil {
+			return err
+		}
+
+		summary := GroupSummary{
+			GroupID:   groupID,
+			Succeeded: len(results),
+			Failed:    len(failures),
+			Results:   make(map[string]json.RawMessage, len(results)),
+			Failures:  failures,
+		}
+		summary.Total, _ = strconv.Atoi(fields["total"])
+		for id, data := range results {
+			summary.Results[id] = json.RawMessage(data)
+		}
+
+		summaryData, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+
+		var callback tasks.Task
+		if err := json.Unmarshal([]byte(fields["callback"]), &callback); err != nil {
+			return err
+		}
+		if callback.Payload, err = injectPayload(callback.Payload, fields["result_key"], summaryData); err != nil {
+			return err
+		}
+		callback.CreatedAt = time.Now()
+		if callbackData, err = json.Marshal(callback); err != nil {
+			return err
+		}
+		callbackQueue = queueName(callback.Priority)
+	}
+
+	return c.runScript(ctx, finishGroupScript,
+		[]string{groupKey(groupID), callbackQueue},
+		callbackData,
+		int(groupTTL.Seconds()),
+	).Err()
+}
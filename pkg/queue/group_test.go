@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestGroupCallback(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	groupID, err := client.EnqueueGroup(ctx, Group{
+		Tasks: []tasks.Task{
+			{ID: "export-1", Type: "export", Priority: tasks.PriorityHigh},
+			{ID: "export-2", Type: "export", Priority: tasks.PriorityHigh},
+			{ID: "export-3", Type: "export", Priority: tasks.PriorityHigh},
+		},
+		Callback: &tasks.Task{ID: "notify", Type: "notify", Priority: tasks.PriorityDefault, Payload: json.RawMessage(`{"email":"ops@example.com"}`)},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("Dequeue failed: %v", err)
+		}
+		if task.GroupID != groupID {
+			t.Fatalf("Expected member of group %s, got %+v", groupID, task)
+		}
+
+		switch task.ID {
+		case "export-2":
+			task.LastError = "disk full"
+			err = client.Fail(ctx, *task, raw)
+		case "export-3":
+			// Members completed without a result count too
+			err = client.Complete(ctx, raw)
+		default:
+			err = client.CompleteWithResult(ctx, *task, raw, map[string]string{"file": task.ID + ".csv"})
+		}
+		if err != nil {
+			t.Fatalf("Finishing member failed: %v", err)
+		}
+
+		// The callback must not fire before the last member finishes
+		if i < 2 && client.GetQueueDepths(ctx)["queue:default"] != 0 {
+			t.Fatalf("Callback enqueued after %d members", i+1)
+		}
+	}
+
+	callbacks, err := client.InspectQueue(ctx, "queue:default", 10)
+	if err != nil {
+		t.Fatalf("InspectQueue failed: %v", err)
+	}
+	if len(callbacks) != 1 || callbacks[0].ID != "notify" {
+		t.Fatalf("Expected the callback to be enqueued once, got %+v", callbacks)
+	}
+
+	var payload struct {
+		Email string       `json:"email"`
+		Group GroupSummary `json:"group"`
+	}
+	if err := json.Unmarshal(callbacks[0].Payload, &payload); err != nil {
+		t.Fatalf("Failed to decode callback payload: %v", err)
+	}
+	if payload.Email != "ops@example.com" {
+		t.Errorf("Expected original payload to be preserved, got %s", callbacks[0].Payload)
+	}
+	summary := payload.Group
+	if summary.Total != 3 || summary.Succeeded != 2 || summary.Failed != 1 {
+		t.Errorf("Unexpected summary counts: %+v", summary)
+	}
+	if string(summary.Results["export-1"]) != `{"file":"export-1.csv"}` {
+		t.Errorf("Unexpected result for export-1: %s", summary.Results["export-1"])
+	}
+	if summary.Failures["export-2"] != "disk full" {
+		t.Errorf("Unexpected failure for export-2: %q", summary.Failures["export-2"])
+	}
+
+	state, err := client.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if state.State != GroupCompleted || state.Succeeded != 2 || state.Failed != 1 || state.CallbackTaskID != "notify" {
+		t.Errorf("Unexpected group state: %+v", state)
+	}
+}
+
+func TestGroupDuplicateMember(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	groupID, err := client.EnqueueGroup(ctx, Group{
+		Tasks: []tasks.Task{
+			{ID: "a", Type: "export", Priority: tasks.PriorityHigh},
+			{ID: "b", Type: "export", Priority: tasks.PriorityHigh},
+		},
+		Callback: &tasks.Task{Type: "notify", Priority: tasks.PriorityDefault},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	state, err := client.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if state.State != GroupRunning || state.Succeeded != 1 {
+		t.Errorf("Expected duplicate completion to be ignored, got %+v", state)
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:default"]; depth != 0 {
+		t.Errorf("Expected no callback yet, got %d queued", depth)
+	}
+}
+
+func TestGroupExpiryRefreshed(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	groupID, err := client.EnqueueGroup(ctx, Group{
+		Tasks: []tasks.Task{
+			{ID: "slow-1", Type: "export", Priority: tasks.PriorityHigh},
+			{ID: "slow-2", Type: "export", Priority: tasks.PriorityHigh},
+		},
+		Callback: &tasks.Task{Type: "notify", Priority: tasks.PriorityDefault},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	// Each member outcome keeps the group alive for another groupTTL
+	s.FastForward(groupTTL - time.Hour)
+	task, raw, _ := client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	s.FastForward(2 * time.Hour)
+
+	task, raw, _ = client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	state, err := client.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if state.State != GroupCompleted || state.Succeeded != 2 {
+		t.Errorf("Expected the group to complete, got %+v", state)
+	}
+}
+
+func TestGroupCallbackRetriedAfterEnqueueFailure(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	groupID, err := client.EnqueueGroup(ctx, Group{
+		Tasks:    []tasks.Task{{ID: "a", Type: "export", Priority: tasks.PriorityHigh}},
+		Callback: &tasks.Task{ID: "notify", Type: "notify", Priority: tasks.PriorityDefault},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueGroup failed: %v", err)
+	}
+
+	// Make the callback enqueue fail
+	s.Set("queue:default", "not a list")
+	task, raw, _ := client.Dequeue(ctx)
+	if err := client.CompleteWithResult(ctx, *task, raw, nil); err == nil {
+		t.Fatal("Expected the callback enqueue to fail")
+	}
+	if state, _ := client.GetGroup(ctx, groupID); state.State != GroupRunning {
+		t.Fatalf("Expected the group to stay running, got %+v", state)
+	}
+
+	// Recording the member again finalizes the group
+	s.Del("queue:default")
+	if err := client.CompleteWithResult(ctx, *task, raw, nil); err != nil {
+		t.Fatalf("CompleteWithResult failed: %v", err)
+	}
+	if state, _ := client.GetGroup(ctx, groupID); state.State != GroupCompleted || state.Succeeded != 1 {
+		t.Errorf("Expected the group to be completed, got %+v", state)
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:default"]; depth != 1 {
+		t.Errorf("Expected the callback to be enqueued once, got %d queued", depth)
+	}
+}
+
+func TestEnqueueGroupValidation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueGroup(ctx, Group{}); err == nil {
+		t.Error("Expected error for empty group")
+	}
+
+	_, err := client.EnqueueGroup(ctx, Group{
+		Tasks:    []tasks.Task{{Type: "export"}},
+		Callback: &tasks.Task{Type: "notify", Payload: json.RawMessage(`[1,2]`)},
+	})
+	if err == nil {
+		t.Error("Expected error for non-object callback payload")
+	}
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// onTaskCompleted runs the follow-up actions for a task that completed successfully:
+//   - Tasks with an OrderingKey release the next task of their key
+//   - Tasks with a SingletonKey release the lock of their key
+//   - Chain steps enqueue the next step of their chain
+//   - Group members record their result and may trigger the group callback
+//   - Workflow nodes release the dependents whose parents have all completed
+//   - Scheduled tasks record the outcome of their schedule run
+//
+// result is the JSON-encoded value returned by the handler (nil if none).
+func (c *Client) onTaskCompleted(ctx context.Context, task tasks.Task, result json.RawMessage) error {
+	if task.OrderingKey != "" {
+		if err := c.releaseOrderingKey(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.SingletonKey != "" {
+		if err := c.releaseSingleton(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.ChainID != "" {
+		if err := c.advanceChain(ctx, task, result); err != nil {
+			return err
+		}
+	}
+	if task.GroupID != "" {
+		if err := c.recordGroupMember(ctx, task, result, true); err != nil {
+			return err
+		}
+	}
+	if task.WorkflowID != "" {
+		if err := c.completeWorkflowNode(ctx, task, result); err != nil {
+			return err
+		}
+	}
+	if task.ScheduleID != "" {
+		if err := c.finishScheduleRun(ctx, task, RunCompleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onTaskFailed runs the follow-up actions for a task moved to the Dead Letter Queue:
+//   - Tasks with an OrderingKey release the next task of their key
+//   - Tasks with a SingletonKey release the lock of their key
+//   - Chain steps halt their chain
+//   - Group members record their failure and may trigger the group callback
+//   - Workflow nodes fail the workflow, cancel all of their blocked descendants
+//     and start compensating the completed steps
+//   - Scheduled tasks record the outcome of their schedule run
+func (c *Client) onTaskFailed(ctx context.Context, task tasks.Task) error {
+	if task.OrderingKey != "" {
+		if err := c.releaseOrderingKey(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.SingletonKey != "" {
+		if err := c.releaseSingleton(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.ChainID != "" {
+		if err := c.failChain(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.GroupID != "" {
+		if err := c.recordGroupMember(ctx, task, nil, false); err != nil {
+			return err
+		}
+	}
+	if task.WorkflowID != "" {
+		if err := c.failWorkflowNode(ctx, task); err != nil {
+			return err
+		}
+	}
+	if task.ScheduleID != "" {
+		if err := c.finishScheduleRun(ctx, task, RunFailed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
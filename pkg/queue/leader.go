@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+)
+
+// LeaderElection elects a single leader among the processes campaigning under the
+// same name, through a lease stored in Redis.
+//
+// The leader renews its lease every lease/3. If it dies, the lease expires and
+// another candidate takes over within lease + lease/3; if it resigns (e.g. on
+// shutdown), another candidate takes over at its next campaign, within lease/3.
+//
+// Every new leadership gets a fencing token greater than all previous ones. A
+// leader that stalled past its lease (e.g. during a long GC pause) may still
+// believe it leads for a moment; CheckFence lets it verify that its token is still
+// the current one before acting.
+//
+// Redis layout: hash "leader:{name}" (holder, token) with the lease as TTL, and
+// counter "leader:{name}:token".
+type LeaderElection struct {
+	client    *Client
+	key       string
+	candidate string
+	lease     time.Duration
+
+	mu        sync.Mutex
+	leading   bool
+	token     int64
+	expiresAt time.Time
+	onChange  func(leading bool, token int64)
+}
+
+// NewLeaderElection returns a candidate for the leadership of name, holding the
+// lease for the given duration once elected. Call Run to campaign.
+func NewLeaderElection(client *Client, name string, lease time.Duration) *LeaderElection {
+	return &LeaderElection{
+		client:    client,
+		key:       fmt.Sprintf("leader:%s", name),
+		candidate: uuid.New().String(),
+		lease:     lease,
+	}
+}
+
+// OnChange registers a function called whenever this candidate gains or loses the
+// leadership, e.g. to report it in a metric. It must be set before Run is called.
+func (e *LeaderElection) OnChange(fn func(leading bool, token int64)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = fn
+}
+
+// Run campaigns for the leadership, and renews the lease while leading, until ctx
+// is cancelled. It then resigns so that another candidate can take over quickly.
+func (e *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			if err := e.Resign(context.Background()); err != nil {
+				logger.Log.Error().Err(err).Str("key", e.key).Msg("Failed to resign leadership")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsLeader reports whether this candidate holds the leadership, according to its
+// last successful campaign. Use CheckFence before acting on it.
+func (e *LeaderElection) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && time.Now().Before(e.expiresAt)
+}
+
+// Token returns the fencing token of the current leadership of this candidate,
+// or 0 if it does not lead.
+func (e *LeaderElection) Token() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leading {
+		return 0
+	}
+	return e.token
+}
+
+// CheckFence reports whether this candidate still holds the lease in Redis, with
+// the fencing token of its current leadership.
+//
+// The lease may still be lost right after the check. Writes that must never be
+// made by a stale leader should instead compare the token in the same script
+// as the write, against the "token" field of the lease (see claimRunScript).
+func (e *LeaderElection) CheckFence(ctx context.Context) (bool, error) {
+	token := e.Token()
+	if token == 0 || !e.IsLeader() {
+		return false, nil
+	}
+
+	fields, err := e.client.rdb.HMGet(ctx, e.key, "holder", "token").Result()
+	if err != nil {
+		return false, err
+	}
+	holder, _ := fields[0].(string)
+	current, _ := fields[1].(string)
+	return holder == e.candidate && current == fmt.Sprint(token), nil
+}
+
+// resignScript releases a lease if the candidate holds it.
+//
+// KEYS[1]: Lease
+// ARGV[1]: Candidate
+var resignScript = newScript(`
+	if redis.call('HGET', KEYS[1], 'holder') == ARGV[1] then
+		redis.call('DEL', KEYS[1])
+	end
+	return 1
+`)
+
+// Resign gives up the leadership, if this candidate holds it.
+func (e *LeaderElection) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	err := e.client.runScript(ctx, resignScript, []string{e.key}, e.candidate).Err()
+	changed := e.leading
+	e.leading = false
+	e.token = 0
+	onChange := e.onChange
+	e.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(false, 0)
+	}
+	return err
+}
+
+// campaignScript takes a lease if it is free, or renews it if the candidate holds it.
+//
+// KEYS[1]: Lease
+// KEYS[2]: Fencing token counter
+// ARGV[1]: Candidate
+// ARGV[2]: Lease (ms)
+// Returns the fencing token of the leadership, or 0 if another candidate leads.
+var campaignScript = newScript(`
+	local holder = redis.call('HGET', KEYS[1], 'holder')
+	if holder == ARGV[1] then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		return tonumber(redis.call('HGET', KEYS[1], 'token'))
+	end
+	if holder then
+		return 0
+	end
+
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1], 'holder', ARGV[1], 'token', token)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return token
+`)
+
+// campaign takes the lease if it is free, or renews it if this candidate holds it,
+// and updates the leadership accordingly. Errors lose the leadership, since the
+// lease can no longer be renewed reliably.
+func (e *LeaderElection) campaign(ctx context.Context) {
+	e.mu.Lock()
+	if ctx.Err() != nil {
+		// Resigning: do not take the lease again
+		e.mu.Unlock()
+		return
+	}
+	start := time.Now()
+	token, err := e.client.runScript(ctx, campaignScript,
+		[]string{e.key, e.key + ":token"},
+		e.candidate,
+		e.lease.Milliseconds(),
+	).Int64()
+	if err != nil {
+		logger.Log.Error().Err(err).Str("key", e.key).Msg("Leader election failed")
+		token = 0
+	}
+
+	leading := token > 0
+	changed := leading != e.leading || token != e.token
+	e.leading = leading
+	e.token = token
+	// The lease may have been taken when the request was sent
+	e.expiresAt = start.Add(e.lease)
+	onChange := e.onChange
+	e.mu.Unlock()
+
+	if changed {
+		if leading {
+			logger.Log.Info().Str("key", e.key).Int64("token", token).Msg("Elected leader")
+		} else {
+			logger.Log.Info().Str("key", e.key).Msg("Lost leadership")
+		}
+		if onChange != nil {
+			onChange(leading, token)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestLeaderElection(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	a := NewLeaderElection(client, "test", time.Second)
+	b := NewLeaderElection(client, "test", time.Second)
+	var changes []bool
+	a.OnChange(func(leading bool, token int64) { changes = append(changes, leading) })
+
+	a.campaign(ctx)
+	b.campaign(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("Expected a single leader, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+	if ok, err := a.CheckFence(ctx); err != nil || !ok {
+		t.Errorf("Expected the leader to pass the fence check (%v)", err)
+	}
+
+	// Renewing keeps the same token
+	token := a.Token()
+	a.campaign(ctx)
+	if a.Token() != token {
+		t.Errorf("Expected token %d to be kept, got %d", token, a.Token())
+	}
+
+	// The leader dies: its lease expires and b takes over with a greater token
+	s.FastForward(2 * time.Second)
+	b.campaign(ctx)
+	if !b.IsLeader() || b.Token() <= token {
+		t.Fatalf("Expected b to lead with a token greater than %d, got %d", token, b.Token())
+	}
+
+	// a still believes it leads until its next campaign, but fails the fence check
+	if ok, _ := a.CheckFence(ctx); ok {
+		t.Error("Expected the stale leader to fail the fence check")
+	}
+	a.campaign(ctx)
+	if a.IsLeader() {
+		t.Error("Expected a to lose the leadership")
+	}
+
+	// b resigns: a takes over at its next campaign
+	if err := b.Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	a.campaign(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Errorf("Expected a to lead after b resigned, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	if len(changes) != 3 || !changes[0] || changes[1] || !changes[2] {
+		t.Errorf("Expected a to be notified of gain, loss and gain, got %v", changes)
+	}
+}
+
+func TestCronFiresOnceAcrossReplicas(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	replica := NewClient(s.Addr())
+	client.StartCronScheduler()
+	defer client.StopCronScheduler()
+	replica.StartCronScheduler()
+	defer replica.StopCronScheduler()
+
+	if client.CronLeader().IsLeader() == replica.CronLeader().IsLeader() {
+		t.Fatal("Expected exactly one cron leader")
+	}
+
+	if _, err := client.Schedule(ctx, "@every 1s", tasks.Task{ID: "tick", Type: "cron", Priority: tasks.PriorityDefault}); err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	replica.syncSchedules(ctx)
+
+	// A single replica fires 2 or 3 times (on whole seconds), both would fire twice as much
+	time.Sleep(2500 * time.Millisecond)
+	if n, _ := client.rdb.LLen(ctx, "queue:default").Result(); n < 1 || n > 3 {
+		t.Errorf("Expected the schedule to fire once per second, got %d tasks", n)
+	}
+}
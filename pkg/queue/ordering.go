@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// orderingKey returns the Redis list of tasks waiting behind the active task of an ordering key.
+func orderingKey(key string) string {
+	return fmt.Sprintf("ordering:%s", key)
+}
+
+// orderingActiveKey returns the Redis key holding the ID of the active task of an ordering key.
+func orderingActiveKey(key string) string {
+	return fmt.Sprintf("ordering:%s:active", key)
+}
+
+// enqueueOrderedScript pushes a task with an OrderingKey to its priority queue if no
+// other task of the key is active, and to the waiting list of the key otherwise.
+//
+// KEYS[1]: Waiting list of the ordering key
+// KEYS[2]: Active task of the ordering key
+// KEYS[3]: Priority queue of the task
+// ARGV[1]: Task JSON
+// ARGV[2]: Task ID
+// Returns 1 if the task was released to its queue, 0 if it is waiting.
+var enqueueOrderedScript = newScript(`
+	if redis.call('EXISTS', KEYS[2]) == 1 then
+		redis.call('RPUSH', KEYS[1], ARGV[1])
+		return 0
+	end
+	redis.call('SET', KEYS[2], ARGV[2])
+	redis.call('RPUSH', KEYS[3], ARGV[1])
+	return 1
+`)
+
+// enqueueOrderedKeys returns the KEYS of enqueueOrderedScript for the task.
+func enqueueOrderedKeys(task tasks.Task) []string {
+	return []string{orderingKey(task.OrderingKey), orderingActiveKey(task.OrderingKey), queueName(task.Priority)}
+}
+
+// releaseOrderingKeyScript releases the next waiting task of an ordering key, or
+// frees the key.
+//
+// KEYS[1]: Waiting list of the ordering key
+// KEYS[2]: Active task of the ordering key
+// ARGV[1]: Finished task ID
+var releaseOrderingKeyScript = newScript(`
+	if redis.call('GET', KEYS[2]) ~= ARGV[1] then
+		return 0
+	end
+
+	local next = redis.call('LPOP', KEYS[1])
+	if not next then
+		redis.call('DEL', KEYS[2])
+		return 1
+	end
+
+	local task = cjson.decode(next)
+	local queue = 'queue:default'
+	if task.priority == 2 then
+		queue = 'queue:high'
+	elseif task.priority == 0 then
+		queue = 'queue:low'
+	end
+	redis.call('SET', KEYS[2], task.id)
+	redis.call('RPUSH', queue, next)
+	return 1
+`)
+
+// releaseOrderingKey is called when the active task of an ordering key completes or
+// is dead-lettered. It releases the next waiting task of the key to its priority
+// queue, or frees the key if no task is waiting.
+//
+// Only the active task can release the key, so a duplicate completion never
+// releases two tasks.
+func (c *Client) releaseOrderingKey(ctx context.Context, task tasks.Task) error {
+	return c.runScript(ctx, releaseOrderingKeyScript,
+		[]string{orderingKey(task.OrderingKey), orderingActiveKey(task.OrderingKey)},
+		task.ID,
+	).Err()
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// queuedIDs returns the IDs of the tasks in queue:high, in order.
+func queuedIDs(t *testing.T, client *Client) []string {
+	t.Helper()
+	queued, err := client.InspectQueue(context.Background(), "queue:high", 100)
+	if err != nil {
+		t.Fatalf("InspectQueue failed: %v", err)
+	}
+	var ids []string
+	for _, task := range queued {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestOrderingKeyRunsTasksInOrder(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, task := range []tasks.Task{
+		{ID: "a1", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"},
+		{ID: "a2", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"},
+		{ID: "b1", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-b"},
+		{ID: "a3", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"},
+	} {
+		if err := client.Enqueue(ctx, task); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	// Only the first task of each key is queued
+	if ids := queuedIDs(t, client); len(ids) != 2 || ids[0] != "a1" || ids[1] != "b1" {
+		t.Fatalf("Expected [a1 b1] to be queued, got %v", ids)
+	}
+
+	// A retry keeps the key active
+	task, raw, _ := client.Dequeue(ctx)
+	if err := client.Retry(ctx, *task, raw); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "b1" {
+		t.Fatalf("Expected only b1 to be queued during the retry, got %v", ids)
+	}
+
+	// Completion releases the next task of the key
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	if ids := queuedIDs(t, client); len(ids) != 2 || ids[1] != "a2" {
+		t.Fatalf("Expected a2 to be released, got %v", ids)
+	}
+
+	// So does dead-lettering
+	client.Dequeue(ctx) // b1
+	task, raw, _ = client.Dequeue(ctx)
+	if task.ID != "a2" {
+		t.Fatalf("Expected a2, got %s", task.ID)
+	}
+	client.Fail(ctx, *task, raw)
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "a3" {
+		t.Fatalf("Expected a3 to be released, got %v", ids)
+	}
+
+	// Completing a task twice does not release two tasks
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	if ids := queuedIDs(t, client); len(ids) != 1 {
+		t.Fatalf("Expected a duplicate completion to be ignored, got %v", ids)
+	}
+
+	// The last task frees the key
+	task, raw, _ = client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	if s.Exists(orderingActiveKey("customer-a")) {
+		t.Error("Expected the ordering key to be freed")
+	}
+	client.Enqueue(ctx, tasks.Task{ID: "a4", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"})
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "a4" {
+		t.Errorf("Expected a4 to be queued right away, got %v", ids)
+	}
+}
+
+func TestOrderingKeyReleasedByComplete(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.Enqueue(ctx, tasks.Task{ID: "a1", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"})
+	client.Enqueue(ctx, tasks.Task{ID: "a2", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"})
+
+	// Completing the head without a result releases the next task too
+	_, raw, _ := client.Dequeue(ctx)
+	if err := client.Complete(ctx, raw); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "a2" {
+		t.Fatalf("Expected a2 to be released, got %v", ids)
+	}
+}
+
+func TestEnqueueBatchOrderingKey(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{
+		{ID: "a1", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"},
+		{ID: "x", Type: "sync", Priority: tasks.PriorityHigh},
+		{ID: "a2", Type: "sync", Priority: tasks.PriorityHigh, OrderingKey: "customer-a"},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueBatch failed: %v", err)
+	}
+	for i, itemErr := range errs {
+		if itemErr != nil {
+			t.Errorf("Task %d failed: %v", i, itemErr)
+		}
+	}
+
+	if ids := queuedIDs(t, client); len(ids) != 2 {
+		t.Fatalf("Expected a1 and x to be queued, got %v", ids)
+	}
+	waiting, _ := s.List(orderingKey("customer-a"))
+	if len(waiting) != 1 {
+		t.Errorf("Expected a2 to wait, got %v", waiting)
+	}
+}
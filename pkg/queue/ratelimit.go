@@ -0,0 +1,360 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Limiter is a rate limiter shared by all clients through Redis.
+//
+// Reserve takes a permit for key if one is available and returns zero. Otherwise
+// it takes nothing and returns the time until the next permit becomes available,
+// so that callers can postpone the work precisely (e.g. with Client.Requeue).
+//
+// All implementations keep millisecond precision and run a single Lua script per call.
+type Limiter interface {
+	Reserve(ctx context.Context, key string) (time.Duration, error)
+}
+
+var (
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*GCRA)(nil)
+	_ Limiter = (*SlidingWindowLog)(nil)
+	_ Limiter = (*SlidingWindowCounter)(nil)
+)
+
+// TokenBucket is a Limiter holding up to Burst tokens, refilled continuously at Rate
+// tokens per second. It allows bursts of Burst permits, then a steady Rate.
+//
+// Redis layout (hash "{key}"): tokens, last_refill (Unix ms).
+type TokenBucket struct {
+	client *Client
+	rate   float64
+	burst  int
+	now    func() time.Time
+}
+
+// NewTokenBucket returns a token bucket limiter with the given refill rate (tokens per
+// second, which may be fractional, e.g. 0.5 for one permit every two seconds) and capacity.
+// Both must be positive.
+func NewTokenBucket(client *Client, rate float64, burst int) *TokenBucket {
+	return &TokenBucket{client: client, rate: rate, burst: burst, now: time.Now}
+}
+
+// tokenBucketScript takes a permit from a token bucket.
+//
+// KEYS[1]: Rate limit key
+// ARGV[1]: Rate (tokens/sec)
+// ARGV[2]: Burst (capacity)
+// ARGV[3]: Current timestamp (ms)
+// Returns 0 if allowed, or the time until the next token (ms).
+var tokenBucketScript = newScript(`
+	local key = KEYS[1]
+	local rate = tonumber(ARGV[1]) / 1000 -- tokens per ms
+	local burst = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+
+	local tokens = tonumber(redis.call('HGET', key, 'tokens'))
+	local last_refill = tonumber(redis.call('HGET', key, 'last_refill'))
+	if not tokens or not last_refill then
+		tokens = burst
+		last_refill = now
+	end
+
+	-- Refill tokens
+	local delta = math.max(0, now - last_refill)
+	tokens = math.min(burst, tokens + delta * rate)
+
+	-- Compare with a small tolerance, so that floating point rounding never
+	-- denies a token that is due or adds a millisecond to the wait
+	local epsilon = 1e-9
+	local wait = 0
+	if tokens >= 1 - epsilon then
+		tokens = math.max(0, tokens - 1)
+	else
+		wait = math.max(1, math.ceil((1 - tokens) / rate - epsilon))
+	end
+
+	redis.call('HSET', key, 'tokens', tostring(tokens), 'last_refill', now)
+	-- The bucket is full again after this long, so its state can be dropped
+	redis.call('PEXPIRE', key, math.ceil((burst - tokens) / rate) + 1000)
+	return wait
+`)
+
+// Reserve implements Limiter.
+func (l *TokenBucket) Reserve(ctx context.Context, key string) (time.Duration, error) {
+	wait, err := l.client.runScript(ctx, tokenBucketScript,
+		[]string{key},
+		l.rate,
+		l.burst,
+		l.now().UnixMilli(),
+	).Int64()
+	return time.Duration(wait) * time.Millisecond, err
+}
+
+// RateLimitBucket is one of the token buckets checked together by
+// Client.RateLimitsDelay.
+type RateLimitBucket struct {
+	Key   string
+	Limit int // Tokens added per second
+	Burst int // Bucket capacity
+}
+
+// tokenBucketsScript takes a permit from several token buckets at once: from all
+// of them if each has a token, from none of them otherwise.
+//
+// KEYS[n]: Rate limit key of the n-th bucket
+// ARGV[1]: Current timestamp (ms)
+// ARGV[2n], ARGV[2n+1]: Rate (tokens/sec) and burst (capacity) of the n-th bucket
+// Returns 0 if allowed, or the longest time until the next token of a denying bucket (ms).
+var tokenBucketsScript = newScript(`
+	local now = tonumber(ARGV[1])
+	local epsilon = 1e-9
+	local refilled = {}
+	local wait = 0
+
+	for i, key in ipairs(KEYS) do
+		local rate = tonumber(ARGV[2 * i]) / 1000 -- tokens per ms
+		local burst = tonumber(ARGV[2 * i + 1])
+		local tokens = tonumber(redis.call('HGET', key, 'tokens'))
+		local last_refill = tonumber(redis.call('HGET', key, 'last_refill'))
+		if not tokens or not last_refill then
+			tokens = burst
+			last_refill = now
+		end
+		tokens = math.min(burst, tokens + math.max(0, now - last_refill) * rate)
+		if tokens < 1 - epsilon then
+			wait = math.max(wait, math.ceil((1 - tokens) / rate - epsilon), 1)
+		end
+		refilled[i] = {rate, burst, tokens}
+	end
+
+	-- A single denial takes nothing from the other buckets
+	if wait > 0 then
+		return wait
+	end
+
+	for i, key in ipairs(KEYS) do
+		local rate, burst = refilled[i][1], refilled[i][2]
+		local tokens = math.max(0, refilled[i][3] - 1)
+		redis.call('HSET', key, 'tokens', tostring(tokens), 'last_refill', now)
+		redis.call('PEXPIRE', key, math.ceil((burst - tokens) / rate) + 1000)
+	end
+	return 0
+`)
+
+// refundTokenBucketsScript gives a token back to several token buckets.
+//
+// KEYS[n]: Rate limit key of the n-th bucket
+// ARGV[n]: Burst (capacity) of the n-th bucket
+var refundTokenBucketsScript = newScript(`
+	for i, key in ipairs(KEYS) do
+		-- A bucket whose state expired is full already
+		local tokens = tonumber(redis.call('HGET', key, 'tokens'))
+		if tokens then
+			redis.call('HSET', key, 'tokens', tostring(math.min(tonumber(ARGV[i]), tokens + 1)))
+		end
+	end
+	return 1
+`)
+
+// GCRA is a Limiter implementing the Generic Cell Rate Algorithm: it behaves like
+// a token bucket of Rate permits per second and capacity Burst, but stores a single
+// timestamp (the theoretical arrival time of the next permit) instead of a counter.
+//
+// Redis layout (string "{key}"): theoretical arrival time (Unix ms).
+type GCRA struct {
+	client *Client
+	rate   float64
+	burst  int
+	now    func() time.Time
+}
+
+// NewGCRA returns a GCRA limiter allowing rate permits per second with bursts of up to
+// burst permits. Both must be positive.
+func NewGCRA(client *Client, rate float64, burst int) *GCRA {
+	return &GCRA{client: client, rate: rate, burst: burst, now: time.Now}
+}
+
+// gcraScript takes a permit from a GCRA limiter.
+//
+// KEYS[1]: Rate limit key
+// ARGV[1]: Emission interval (ms between permits)
+// ARGV[2]: Burst offset (emission interval * burst, ms)
+// ARGV[3]: Current timestamp (ms)
+// Returns 0 if allowed, or the time until the next permit (ms).
+var gcraScript = newScript(`
+	local key = KEYS[1]
+	local interval = tonumber(ARGV[1])
+	local burst_offset = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+
+	local tat = tonumber(redis.call('GET', key)) or now
+	local new_tat = math.max(tat, now) + interval
+	local allow_at = new_tat - burst_offset
+	if now < allow_at then
+		return math.max(1, math.ceil(allow_at - now))
+	end
+
+	redis.call('SET', key, tostring(new_tat), 'PX', math.ceil(new_tat - now) + 1)
+	return 0
+`)
+
+// Reserve implements Limiter.
+func (l *GCRA) Reserve(ctx context.Context, key string) (time.Duration, error) {
+	interval := 1000 / l.rate
+	wait, err := l.client.runScript(ctx, gcraScript,
+		[]string{key},
+		interval,
+		interval*float64(l.burst),
+		l.now().UnixMilli(),
+	).Int64()
+	return time.Duration(wait) * time.Millisecond, err
+}
+
+// minWindow is the shortest window of the sliding window limiters, whose scripts
+// work with millisecond timestamps.
+const minWindow = time.Millisecond
+
+// clampWindow returns window, or minWindow if window is shorter.
+func clampWindow(window time.Duration) time.Duration {
+	if window < minWindow {
+		return minWindow
+	}
+	return window
+}
+
+// SlidingWindowLog is a Limiter allowing at most Limit permits in any Window-long
+// period. It logs the time of every permit, so it is exact but stores up to Limit
+// entries per key.
+//
+// Redis layout (sorted set "{key}"): member = permit ID, score = permit time (Unix ms).
+type SlidingWindowLog struct {
+	client *Client
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindowLog returns a sliding window log limiter allowing limit permits per window.
+// The limit must be positive. Windows are tracked in milliseconds: shorter windows
+// are rounded up to minWindow.
+func NewSlidingWindowLog(client *Client, limit int, window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{client: client, limit: limit, window: clampWindow(window), now: time.Now}
+}
+
+// slidingWindowLogScript takes a permit from a sliding window log.
+//
+// KEYS[1]: Rate limit key
+// ARGV[1]: Limit
+// ARGV[2]: Window (ms)
+// ARGV[3]: Current timestamp (ms)
+// ARGV[4]: Unique permit ID
+// Returns 0 if allowed, or the time until the oldest permit leaves the window (ms).
+var slidingWindowLogScript = newScript(`
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local now = tonumber(ARGV[3])
+
+	-- Forget permits that left the window
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+	if redis.call('ZCARD', key) < limit then
+		redis.call('ZADD', key, now, ARGV[4])
+		redis.call('PEXPIRE', key, window)
+		return 0
+	end
+
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	return math.max(1, tonumber(oldest[2]) + window - now)
+`)
+
+// Reserve implements Limiter.
+func (l *SlidingWindowLog) Reserve(ctx context.Context, key string) (time.Duration, error) {
+	wait, err := l.client.runScript(ctx, slidingWindowLogScript,
+		[]string{key},
+		l.limit,
+		l.window.Milliseconds(),
+		l.now().UnixMilli(),
+		uuid.New().String(),
+	).Int64()
+	return time.Duration(wait) * time.Millisecond, err
+}
+
+// SlidingWindowCounter is a Limiter approximating a sliding window of Limit permits
+// per Window with two fixed-window counters: the count of the previous window is
+// weighted by how much of it still overlaps the sliding window. It uses constant
+// memory per key, at the cost of assuming permits were evenly spread in the
+// previous window.
+//
+// Redis layout (strings "{key}:{n}"): number of permits in the n-th fixed window.
+type SlidingWindowCounter struct {
+	client *Client
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindowCounter returns a sliding window counter limiter allowing about limit
+// permits per window. The limit must be positive. Windows are tracked in milliseconds:
+// shorter windows are rounded up to minWindow.
+func NewSlidingWindowCounter(client *Client, limit int, window time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{client: client, limit: limit, window: clampWindow(window), now: time.Now}
+}
+
+// slidingWindowCounterScript takes a permit from a sliding window counter.
+//
+// KEYS[1]: Counter of the current fixed window
+// KEYS[2]: Counter of the previous fixed window
+// ARGV[1]: Limit
+// ARGV[2]: Window (ms)
+// ARGV[3]: Time elapsed in the current fixed window (ms)
+// Returns 0 if allowed, or the time until the weighted count drops below the limit (ms).
+var slidingWindowCounterScript = newScript(`
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local elapsed = tonumber(ARGV[3])
+	local current = tonumber(redis.call('GET', KEYS[1])) or 0
+	local previous = tonumber(redis.call('GET', KEYS[2])) or 0
+
+	-- Permits of the previous window still inside the sliding window
+	local function weighted(prev, at)
+		return prev * (window - at) / window
+	end
+
+	if weighted(previous, elapsed) + current + 1 <= limit then
+		redis.call('INCR', KEYS[1])
+		redis.call('PEXPIRE', KEYS[1], window * 2)
+		return 0
+	end
+
+	-- Time at which the previous window has decayed enough
+	if current + 1 <= limit then
+		local at = window * (1 - (limit - 1 - current) / previous)
+		return math.max(1, math.ceil(at - elapsed))
+	end
+
+	-- The current window alone is full: wait for it to become the previous one
+	-- and decay enough
+	local at = window * (1 - (limit - 1) / current)
+	return math.max(1, math.ceil(window - elapsed + at))
+`)
+
+// Reserve implements Limiter.
+func (l *SlidingWindowCounter) Reserve(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now().UnixMilli()
+	window := l.window.Milliseconds()
+	index := now / window
+
+	wait, err := l.client.runScript(ctx, slidingWindowCounterScript,
+		[]string{fmt.Sprintf("%s:%d", key, index), fmt.Sprintf("%s:%d", key, index-1)},
+		l.limit,
+		window,
+		now%window,
+	).Int64()
+	return time.Duration(wait) * time.Millisecond, err
+}
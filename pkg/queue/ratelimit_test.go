@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a controllable time source for limiters.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newFakeClock returns a clock at a whole second, so that fixed windows start at zero.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+// expectReserve calls Reserve and checks the returned wait.
+func expectReserve(t *testing.T, limiter Limiter, want time.Duration) {
+	t.Helper()
+	got, err := limiter.Reserve(context.Background(), "ratelimit:test")
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Expected wait %s, got %s", want, got)
+	}
+}
+
+func TestBurstLimiters(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter func(client *Client, clock *fakeClock) Limiter
+	}{
+		{"TokenBucket", func(client *Client, clock *fakeClock) Limiter {
+			limiter := NewTokenBucket(client, 10, 3)
+			limiter.now = clock.Now
+			return limiter
+		}},
+		{"GCRA", func(client *Client, clock *fakeClock) Limiter {
+			limiter := NewGCRA(client, 10, 3)
+			limiter.now = clock.Now
+			return limiter
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, client := setupTestRedis()
+			defer s.Close()
+			clock := newFakeClock()
+			limiter := tt.limiter(client, clock)
+
+			// A burst of 3, then one permit every 100ms
+			for i := 0; i < 3; i++ {
+				expectReserve(t, limiter, 0)
+			}
+			expectReserve(t, limiter, 100*time.Millisecond)
+
+			clock.Advance(99 * time.Millisecond)
+			expectReserve(t, limiter, time.Millisecond)
+
+			clock.Advance(time.Millisecond)
+			expectReserve(t, limiter, 0)
+			expectReserve(t, limiter, 100*time.Millisecond)
+
+			// After a long pause the burst is available again, but not more
+			clock.Advance(time.Minute)
+			for i := 0; i < 3; i++ {
+				expectReserve(t, limiter, 0)
+			}
+			expectReserve(t, limiter, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestTokenBucketFractionalRate(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	clock := newFakeClock()
+
+	// One permit every two seconds
+	limiter := NewTokenBucket(client, 0.5, 1)
+	limiter.now = clock.Now
+
+	expectReserve(t, limiter, 0)
+	expectReserve(t, limiter, 2*time.Second)
+
+	clock.Advance(1500 * time.Millisecond)
+	expectReserve(t, limiter, 500*time.Millisecond)
+
+	clock.Advance(500 * time.Millisecond)
+	expectReserve(t, limiter, 0)
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	clock := newFakeClock()
+
+	limiter := NewSlidingWindowLog(client, 3, time.Second)
+	limiter.now = clock.Now
+
+	// Permits at 0ms, 100ms and 200ms fill the window
+	for i := 0; i < 3; i++ {
+		expectReserve(t, limiter, 0)
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	// At 300ms the next permit is when the first one leaves the window
+	expectReserve(t, limiter, 700*time.Millisecond)
+
+	clock.Advance(700 * time.Millisecond)
+	expectReserve(t, limiter, 0)
+	expectReserve(t, limiter, 100*time.Millisecond)
+}
+
+func TestSlidingWindowCounter(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	clock := newFakeClock()
+
+	limiter := NewSlidingWindowCounter(client, 10, time.Second)
+	limiter.now = clock.Now
+
+	for i := 0; i < 10; i++ {
+		expectReserve(t, limiter, 0)
+	}
+
+	// The current window is full: wait for the next window, then for the
+	// weighted count of this one (10 * 90%) to leave room for one permit
+	expectReserve(t, limiter, 1100*time.Millisecond)
+
+	clock.Advance(1100 * time.Millisecond)
+	expectReserve(t, limiter, 0)
+
+	// 10 * 90% + 1 permits are now counted: the next permit needs the previous
+	// window to decay to 80%
+	expectReserve(t, limiter, 100*time.Millisecond)
+
+	clock.Advance(100 * time.Millisecond)
+	expectReserve(t, limiter, 0)
+}
+
+func TestSlidingWindowSubMillisecond(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	clock := newFakeClock()
+
+	// Windows shorter than a millisecond are rounded up instead of dividing by zero
+	log := NewSlidingWindowLog(client, 1, time.Microsecond)
+	log.now = clock.Now
+	expectReserve(t, log, 0)
+	expectReserve(t, log, time.Millisecond)
+
+	counter := NewSlidingWindowCounter(client, 1, 0)
+	counter.now = clock.Now
+	expectReserve(t, counter, 0)
+}
@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultResultTTL is how long task results are retained when the task does not set ResultTTL.
+const DefaultResultTTL = 24 * time.Hour
+
+// Result statuses stored by the worker.
+const (
+	ResultCompleted = "completed"
+	ResultFailed    = "failed"
+	ResultExpired   = "expired"
+)
+
+// ErrResultTimeout is returned by WaitResult when no result is stored before the timeout expires.
+var ErrResultTimeout = errors.New("timed out waiting for task result")
+
+// ErrTaskFailed is returned by EnqueueAndWait when the task was moved to the Dead Letter Queue.
+var ErrTaskFailed = errors.New("task failed")
+
+// TaskResult is the envelope stored under "result:{taskID}" when a task finishes.
+//
+// Data holds the value returned by the handler for completed tasks, and Error
+// holds the last error message for tasks moved to the Dead Letter Queue.
+type TaskResult struct {
+	TaskID     string          `json:"task_id"`
+	Status     string          `json:"status"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// resultKey returns the Redis key holding the result of a task.
+func resultKey(taskID string) string {
+	return fmt.Sprintf("result:%s", taskID)
+}
+
+// resultChannel returns the Pub/Sub channel on which the result of a task is announced.
+func resultChannel(taskID string) string {
+	return fmt.Sprintf("result_ready:%s", taskID)
+}
+
+// resultTTL returns the retention period for the result of the given task.
+func resultTTL(task tasks.Task) time.Duration {
+	if task.ResultTTL > 0 {
+		return task.ResultTTL
+	}
+	return DefaultResultTTL
+}
+
+// queueResult adds the commands that store a result and notify waiters to the pipeline.
+func queueResult(ctx context.Context, pipe redis.Pipeliner, taskID string, data []byte, ttl time.Duration) {
+	pipe.Set(ctx, resultKey(taskID), data, ttl)
+	pipe.Publish(ctx, resultChannel(taskID), data)
+}
+
+// CompleteWithResult marks a task as successfully completed and stores the value
+// returned by its handler as a "completed" TaskResult, retained for task.ResultTTL.
+//
+// Completion and result storage happen in a single transaction, so a client
+// observing the result can rely on the task having left the processing_queue.
+// Chain and group bookkeeping for the task runs afterwards (see onTaskCompleted).
+func (c *Client) CompleteWithResult(ctx context.Context, task tasks.Task, rawTask string, result interface{}) error {
+	var data json.RawMessage
+	if result != nil {
+		var err error
+		if data, err = json.Marshal(result); err != nil {
+			return err
+		}
+	}
+
+	envelope, err := json.Marshal(TaskResult{
+		TaskID:     task.ID,
+		Status:     ResultCompleted,
+		Data:       data,
+		FinishedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe := c.rdb.TxPipeline()
+	queueCompletion(ctx, pipe, rawTask)
+	queueResult(ctx, pipe, task.ID, envelope, resultTTL(task))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	return c.onTaskCompleted(ctx, task, data)
+}
+
+// WaitResult blocks until the result of the task is available and returns it as raw JSON.
+// Unlike GetResult it does not poll: it subscribes to the task's notification channel
+// before checking for an existing result, so a result stored concurrently is never missed.
+//
+// It returns ErrResultTimeout if no result arrives within timeout. A timeout of zero
+// or less waits until the context is cancelled.
+func (c *Client) WaitResult(ctx context.Context, taskID string, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	pubsub := c.rdb.Subscribe(ctx, resultChannel(taskID))
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed before checking the result key.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return "", waitError(ctx, err)
+	}
+
+	result, err := c.GetResult(ctx, taskID)
+	if err == nil {
+		return result, nil
+	}
+	if err != redis.Nil {
+		return "", waitError(ctx, err)
+	}
+
+	select {
+	case msg, ok := <-pubsub.Channel():
+		if !ok {
+			return "", waitError(ctx, redis.ErrClosed)
+		}
+		return msg.Payload, nil
+	case <-ctx.Done():
+		return "", waitError(ctx, ctx.Err())
+	}
+}
+
+// waitError maps a deadline expiry to ErrResultTimeout and returns other errors unchanged.
+func waitError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrResultTimeout
+	}
+	return err
+}
+
+// EnqueueAndWait enqueues the task and blocks until it completes or permanently fails,
+// providing request/reply semantics on top of the queue.
+//
+// The wait is bounded by the context deadline; if it expires first, ErrResultTimeout is
+// returned while the task stays queued (or keeps running) and its result can still be
+// fetched later with GetResult or WaitResult. If the task ends up in the Dead Letter
+// Queue, the failed TaskResult is returned together with an error wrapping ErrTaskFailed;
+// if it expires before being processed, the expired TaskResult and ErrTaskExpired are returned.
+//
+// The task must have an ID, since its result is looked up under it.
+func (c *Client) EnqueueAndWait(ctx context.Context, task tasks.Task) (*TaskResult, error) {
+	if task.ID == "" {
+		return nil, errors.New("task ID is required to wait for its result")
+	}
+	if err := c.Enqueue(ctx, task); err != nil {
+		return nil, err
+	}
+
+	raw, err := c.WaitResult(ctx, task.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TaskResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return nil, err
+	}
+	switch result.Status {
+	case ResultFailed:
+		return &result, fmt.Errorf("%w: %s", ErrTaskFailed, result.Error)
+	case ResultExpired:
+		return &result, ErrTaskExpired
+	}
+	return &result, nil
+}
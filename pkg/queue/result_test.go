@@ -0,0 +1,214 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestCompleteWithResult(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	task := tasks.Task{ID: "result-task", Type: "test", ResultTTL: time.Hour}
+	data, _ := json.Marshal(task)
+	s.RPush("processing_queue", string(data))
+
+	if err := client.CompleteWithResult(ctx, task, string(data), map[string]int{"count": 3}); err != nil {
+		t.Fatalf("CompleteWithResult failed: %v", err)
+	}
+
+	if depth := client.GetQueueDepths(ctx)["processing_queue"]; depth != 0 {
+		t.Errorf("Expected processing_queue to be empty, got %d", depth)
+	}
+
+	raw, err := client.GetResult(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("GetResult failed: %v", err)
+	}
+
+	var result TaskResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	if result.Status != ResultCompleted {
+		t.Errorf("Expected status completed, got %s", result.Status)
+	}
+	if string(result.Data) != `{"count":3}` {
+		t.Errorf("Unexpected result data: %s", result.Data)
+	}
+
+	if ttl := s.TTL(resultKey(task.ID)); ttl != time.Hour {
+		t.Errorf("Expected result TTL of 1h, got %s", ttl)
+	}
+}
+
+func TestFailStoresResult(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	task := tasks.Task{ID: "failed-task", Type: "test", LastError: "boom"}
+	if err := client.Fail(ctx, task, "{}"); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	raw, err := client.GetResult(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("GetResult failed: %v", err)
+	}
+
+	var result TaskResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	if result.Status != ResultFailed || result.Error != "boom" {
+		t.Errorf("Expected failed result with error, got %+v", result)
+	}
+
+	if ttl := s.TTL(resultKey(task.ID)); ttl != DefaultResultTTL {
+		t.Errorf("Expected default result TTL, got %s", ttl)
+	}
+}
+
+func TestWaitResult(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.SetResult(ctx, "wait-task", map[string]string{"status": "done"})
+	}()
+
+	raw, err := client.WaitResult(ctx, "wait-task", 2*time.Second)
+	if err != nil {
+		t.Fatalf("WaitResult failed: %v", err)
+	}
+	if raw != `{"status":"done"}` {
+		t.Errorf("Unexpected result: %s", raw)
+	}
+}
+
+func TestWaitResultAlreadyStored(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.SetResult(ctx, "stored-task", "ok")
+
+	raw, err := client.WaitResult(ctx, "stored-task", time.Second)
+	if err != nil {
+		t.Fatalf("WaitResult failed: %v", err)
+	}
+	if raw != `"ok"` {
+		t.Errorf("Unexpected result: %s", raw)
+	}
+}
+
+func TestWaitResultTimeout(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+
+	start := time.Now()
+	_, err := client.WaitResult(context.Background(), "missing-task", 200*time.Millisecond)
+	if err != ErrResultTimeout {
+		t.Fatalf("Expected ErrResultTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitResult returned too late: %s", elapsed)
+	}
+}
+
+// processNext simulates a worker: it dequeues one task and completes or fails it.
+func processNext(t *testing.T, client *Client, fail bool) {
+	ctx := context.Background()
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Errorf("Dequeue failed: %v", err)
+		return
+	}
+	if fail {
+		task.LastError = "handler exploded"
+		err = client.Fail(ctx, *task, raw)
+	} else {
+		err = client.CompleteWithResult(ctx, *task, raw, map[string]string{"echo": task.ID})
+	}
+	if err != nil {
+		t.Errorf("Finishing task failed: %v", err)
+	}
+}
+
+func TestEnqueueAndWait(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go processNext(t, client, false)
+
+	task := tasks.Task{ID: "rpc-task", Type: "rpc", Priority: tasks.PriorityHigh}
+	result, err := client.EnqueueAndWait(ctx, task)
+	if err != nil {
+		t.Fatalf("EnqueueAndWait failed: %v", err)
+	}
+	if result.Status != ResultCompleted || string(result.Data) != `{"echo":"rpc-task"}` {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestEnqueueAndWaitFailed(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go processNext(t, client, true)
+
+	task := tasks.Task{ID: "rpc-task", Type: "rpc", Priority: tasks.PriorityHigh}
+	result, err := client.EnqueueAndWait(ctx, task)
+	if !errors.Is(err, ErrTaskFailed) {
+		t.Fatalf("Expected ErrTaskFailed, got %v", err)
+	}
+	if result == nil || result.Error != "handler exploded" {
+		t.Errorf("Expected failed result with error message, got %+v", result)
+	}
+}
+
+func TestEnqueueAndWaitTimeout(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	task := tasks.Task{ID: "rpc-task", Type: "rpc", Priority: tasks.PriorityHigh}
+	if _, err := client.EnqueueAndWait(ctx, task); err != ErrResultTimeout {
+		t.Fatalf("Expected ErrResultTimeout, got %v", err)
+	}
+
+	// The task stays queued for a worker to pick up later
+	if depth := client.GetQueueDepths(context.Background())["queue:high"]; depth != 1 {
+		t.Errorf("Expected task to remain in queue:high, got depth %d", depth)
+	}
+}
+
+func TestEnqueueAndWaitRequiresID(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueAndWait(ctx, tasks.Task{Type: "rpc", Priority: tasks.PriorityHigh}); err == nil {
+		t.Fatal("Expected an error for a task without ID")
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 0 {
+		t.Errorf("Expected no task to be enqueued, got depth %d", depth)
+	}
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// Saga states reported in WorkflowState.SagaState.
+const (
+	SagaCompensating       = "compensating"
+	SagaCompensated        = "compensated"
+	SagaCompensationFailed = "compensation_failed"
+)
+
+// compensationResultKey is the payload key under which a compensation task
+// receives the result of the step it compensates.
+const compensationResultKey = "step_result"
+
+// sagaChainID returns the ID of the chain running the compensations of a workflow.
+func sagaChainID(workflowID string) string {
+	return workflowID + ":saga"
+}
+
+// sagaPlan holds the compensation chain of a failed workflow, planned from a
+// snapshot of its completed steps.
+type sagaPlan struct {
+	// completed is the number of completed steps in the snapshot.
+	completed int
+
+	// chain, fields and first describe the compensation chain; chain has no
+	// steps if nothing needs to be undone.
+	chain  Chain
+	fields map[string]interface{}
+	first  []byte
+}
+
+// planSaga builds the compensations of all completed steps of a workflow.
+//
+// Compensations run as a chain, in reverse order of step completion, so each one
+// starts only after the previous has completed. Each compensation receives the
+// result of the step it undoes under the "step_result" payload key. A failed
+// compensation is recorded and the chain moves on to the next one; the saga
+// outcome is recorded in the workflow when the chain ends (see failChain).
+//
+// The plan is only valid while no other step completes: failWorkflowNodeScript
+// checks the number of completed steps before starting it.
+func (c *Client) planSaga(ctx context.Context, workflowID string) (*sagaPlan, error) {
+	completed, err := c.rdb.LRange(ctx, workflowCompletedKey(workflowID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := c.rdb.HGetAll(ctx, workflowKey(workflowID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &sagaPlan{completed: len(completed), chain: Chain{ID: sagaChainID(workflowID)}}
+	if fields["compensable"] != "1" || fields["state"] == WorkflowFailed {
+		return plan, nil // No saga, or it has already started
+	}
+	for i := len(completed) - 1; i >= 0; i-- {
+		var step tasks.Task
+		if err := json.Unmarshal([]byte(fields["task:"+completed[i]]), &step); err != nil {
+			return nil, err
+		}
+		if step.Compensation == nil {
+			continue
+		}
+
+		compensation, err := compensationFor(step, json.RawMessage(fields["result:"+step.ID]))
+		if err != nil {
+			return nil, err
+		}
+		plan.chain.Steps = append(plan.chain.Steps, compensation)
+	}
+
+	if len(plan.chain.Steps) == 0 {
+		return plan, nil // Nothing had completed yet, so there is nothing to undo
+	}
+	plan.fields, plan.first, err = buildChain(&plan.chain, map[string]interface{}{"saga_workflow": workflowID})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// compensateLateStep enqueues the compensation of a step that completed after
+// its workflow had already started compensating.
+func (c *Client) compensateLateStep(ctx context.Context, step tasks.Task, result json.RawMessage) error {
+	compensation, err := compensationFor(step, result)
+	if err != nil {
+		return err
+	}
+	return c.Enqueue(ctx, compensation)
+}
+
+// compensationFor builds the compensation task of a completed workflow step.
+func compensationFor(step tasks.Task, result json.RawMessage) (tasks.Task, error) {
+	compensation := *step.Compensation
+	if compensation.ID == "" {
+		compensation.ID = uuid.New().String()
+	}
+	compensation.CreatedAt = time.Now()
+
+	var err error
+	compensation.Payload, err = injectPayload(compensation.Payload, compensationResultKey, result)
+	return compensation, err
+}
@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// orderWorkflow returns reserve -> charge -> ship, where the first two steps declare compensations.
+func orderWorkflow() Workflow {
+	return Workflow{
+		ID: "order-42",
+		Tasks: []tasks.Task{
+			{ID: "reserve", Type: "reserve_inventory", Priority: tasks.PriorityHigh,
+				Compensation: &tasks.Task{Type: "release_inventory", Priority: tasks.PriorityHigh}},
+			{ID: "charge", Type: "charge_card", Priority: tasks.PriorityHigh, DependsOn: []string{"reserve"},
+				Compensation: &tasks.Task{Type: "refund", Priority: tasks.PriorityHigh, Payload: json.RawMessage(`{"reason":"order failed"}`)}},
+			{ID: "ship", Type: "ship", Priority: tasks.PriorityHigh, DependsOn: []string{"charge"}},
+		},
+	}
+}
+
+func TestSagaCompensatesInReverseOrder(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueWorkflow(ctx, orderWorkflow()); err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	results := map[string]interface{}{
+		"reserve": map[string]string{"reservation": "r-1"},
+		"charge":  map[string]string{"payment": "p-1"},
+	}
+	for _, id := range []string{"reserve", "charge"} {
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil || task.ID != id {
+			t.Fatalf("Expected %s, got %+v (%v)", id, task, err)
+		}
+		client.CompleteWithResult(ctx, *task, raw, results[id])
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil || task.ID != "ship" {
+		t.Fatalf("Expected ship, got %+v (%v)", task, err)
+	}
+	task.LastError = "carrier unavailable"
+	if err := client.Fail(ctx, *task, raw); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	state, err := client.GetWorkflow(ctx, "order-42")
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	if state.State != WorkflowFailed || state.SagaState != SagaCompensating || state.SagaChainID == "" {
+		t.Fatalf("Unexpected workflow state: %+v", state)
+	}
+
+	// Compensations run one at a time: refund first, then release_inventory
+	wantPayloads := map[string]string{
+		"refund":            `{"reason":"order failed","step_result":{"payment":"p-1"}}`,
+		"release_inventory": `{"step_result":{"reservation":"r-1"}}`,
+	}
+	for _, want := range []string{"refund", "release_inventory"} {
+		if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 1 {
+			t.Fatalf("Expected exactly one compensation queued, got %d", depth)
+		}
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil || task.Type != want {
+			t.Fatalf("Expected %s compensation, got %+v (%v)", want, task, err)
+		}
+		if string(task.Payload) != wantPayloads[want] {
+			t.Errorf("Unexpected %s payload: %s", want, task.Payload)
+		}
+		client.CompleteWithResult(ctx, *task, raw, nil)
+	}
+
+	state, err = client.GetWorkflow(ctx, "order-42")
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	if state.SagaState != SagaCompensated {
+		t.Errorf("Expected saga compensated, got %q", state.SagaState)
+	}
+}
+
+func TestSagaCompensationFailure(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueWorkflow(ctx, orderWorkflow()); err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	// reserve completes, charge fails permanently
+	task, raw, _ := client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	task, raw, _ = client.Dequeue(ctx)
+	client.Fail(ctx, *task, raw)
+
+	states := nodeStates(t, client, "order-42")
+	if states["ship"] != NodeCancelled {
+		t.Errorf("Expected ship to be cancelled, got %s", states["ship"])
+	}
+
+	// Only reserve completed, so only its compensation runs; make it fail too
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil || task.Type != "release_inventory" {
+		t.Fatalf("Expected release_inventory compensation, got %+v (%v)", task, err)
+	}
+	client.Fail(ctx, *task, raw)
+
+	state, err := client.GetWorkflow(ctx, "order-42")
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	if state.SagaState != SagaCompensationFailed {
+		t.Errorf("Expected saga compensation_failed, got %q", state.SagaState)
+	}
+}
+
+func TestSagaContinuesAfterFailedCompensation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueWorkflow(ctx, orderWorkflow()); err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		task, raw, _ := client.Dequeue(ctx)
+		client.CompleteWithResult(ctx, *task, raw, nil)
+	}
+	task, raw, _ := client.Dequeue(ctx)
+	client.Fail(ctx, *task, raw)
+
+	// The refund fails, but the inventory is still released
+	refund, raw, err := client.Dequeue(ctx)
+	if err != nil || refund.Type != "refund" {
+		t.Fatalf("Expected refund compensation, got %+v (%v)", refund, err)
+	}
+	refund.LastError = "card expired"
+	client.Fail(ctx, *refund, raw)
+
+	if state, _ := client.GetWorkflow(ctx, "order-42"); state.SagaState != SagaCompensating {
+		t.Fatalf("Expected saga to keep compensating, got %q", state.SagaState)
+	}
+	task, raw, err = client.Dequeue(ctx)
+	if err != nil || task.Type != "release_inventory" {
+		t.Fatalf("Expected release_inventory compensation, got %+v (%v)", task, err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	state, err := client.GetWorkflow(ctx, "order-42")
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	if state.SagaState != SagaCompensationFailed {
+		t.Errorf("Expected saga compensation_failed, got %q", state.SagaState)
+	}
+	if len(state.SagaErrors) != 1 || state.SagaErrors[refund.ID] != "card expired" {
+		t.Errorf("Unexpected saga errors: %v", state.SagaErrors)
+	}
+}
+
+func TestSagaStartsWithLateCompletion(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := client.EnqueueWorkflow(ctx, orderWorkflow()); err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+	task, raw, _ := client.Dequeue(ctx)
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	// A plan made before reserve completed is rejected without side effects
+	task, _, _ = client.Dequeue(ctx)
+	status, err := client.runScript(ctx, failWorkflowNodeScript,
+		[]string{workflowKey("order-42"), workflowCompletedKey("order-42"), chainKey(sagaChainID("order-42")), ""},
+		task.ID, "boom", "", 60, 0, "", 60, sagaChainID("order-42"),
+	).Int()
+	if err != nil || status != -1 {
+		t.Fatalf("Expected a stale plan to be rejected, got %d (%v)", status, err)
+	}
+	if states := nodeStates(t, client, "order-42"); states["charge"] != NodePending {
+		t.Errorf("Expected charge to stay pending, got %s", states["charge"])
+	}
+}
+
+func TestSagaLateStepIsCompensated(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// Two parallel steps; one fails while the other is still running
+	_, err := client.EnqueueWorkflow(ctx, Workflow{
+		ID: "parallel",
+		Tasks: []tasks.Task{
+			{ID: "a", Type: "a", Priority: tasks.PriorityHigh, Compensation: &tasks.Task{Type: "undo_a", Priority: tasks.PriorityHigh}},
+			{ID: "b", Type: "b", Priority: tasks.PriorityHigh},
+			{ID: "c", Type: "c", Priority: tasks.PriorityHigh, DependsOn: []string{"a", "b"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	taskA, rawA, _ := client.Dequeue(ctx)
+	taskB, rawB, _ := client.Dequeue(ctx)
+	client.Fail(ctx, *taskB, rawB)
+
+	// Nothing had completed, so the saga has nothing to undo yet
+	if state, _ := client.GetWorkflow(ctx, "parallel"); state.SagaState != SagaCompensated {
+		t.Fatalf("Expected empty saga to be compensated, got %q", state.SagaState)
+	}
+
+	client.CompleteWithResult(ctx, *taskA, rawA, nil)
+
+	queued, err := client.InspectQueue(ctx, "queue:high", 10)
+	if err != nil {
+		t.Fatalf("InspectQueue failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0].Type != "undo_a" {
+		t.Errorf("Expected only the late compensation to be queued, got %+v", queued)
+	}
+	if states := nodeStates(t, client, "parallel"); states["c"] != NodeCancelled {
+		t.Errorf("Expected c to be cancelled, got %s", states["c"])
+	}
+}
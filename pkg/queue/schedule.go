@@ -0,0 +1,387 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+)
+
+// schedulesKey is the Redis hash holding the persistent schedules: field = schedule ID,
+// value = Schedule JSON.
+const schedulesKey = "schedules"
+
+// cronLeaderLease is the lease of the cron leadership: when the leader dies, another
+// replica starts firing the schedules within cronLeaderLease + cronLeaderLease/3.
+const cronLeaderLease = 6 * time.Second
+
+// scheduleSyncInterval is how often a running cron scheduler reloads the schedules
+// from Redis, picking up the changes made through other clients.
+const scheduleSyncInterval = 5 * time.Second
+
+// ErrInvalidSchedule is returned by PutSchedule when the schedule has no ID, or an
+// invalid spec, time zone, jitter or misfire policy.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// cronParser parses schedule specs: cron expressions with a leading seconds field
+// (e.g. "0 */5 * * * *") or descriptors (e.g. "@every 1m", "@daily").
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Schedule is a recurring task persisted in Redis, so that it survives restarts
+// and is shared by all the clients running a cron scheduler.
+type Schedule struct {
+	ID        string     `json:"id"`
+	Spec      string     `json:"spec"`   // Cron expression (e.g. "@every 1m")
+	Task      tasks.Task `json:"task"`   // Template of the task enqueued at every firing
+	Paused    bool       `json:"paused"` // Paused schedules keep their definition but never fire
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+
+	// MisfirePolicy decides what happens to the firings missed while no cron
+	// scheduler was running (MisfireSkip if empty).
+	MisfirePolicy string `json:"misfire_policy,omitempty"`
+
+	// TimeZone is the IANA time zone the spec is evaluated in (e.g. "Europe/Rome"),
+	// server-local time if empty.
+	TimeZone string `json:"time_zone,omitempty"`
+
+	// Jitter delays every firing by a random duration up to Jitter, so that the
+	// schedules due at the same time (e.g. at the top of the hour) spread out.
+	Jitter time.Duration `json:"jitter,omitempty"`
+}
+
+// cronEntry is the cron job registered for a schedule, and the version of the
+// schedule it was registered for.
+type cronEntry struct {
+	id        cron.EntryID
+	updatedAt time.Time
+}
+
+// Schedule persists a new schedule that enqueues the task according to the cron
+// spec (see cronParser), and returns the ID of its entry in the cron scheduler of
+// this client. Use CreateSchedule to get the ID of the persistent schedule, which
+// is needed to update, pause or delete it.
+func (c *Client) Schedule(ctx context.Context, spec string, task tasks.Task) (cron.EntryID, error) {
+	schedule := Schedule{ID: uuid.New().String(), Spec: spec, Task: task}
+	if err := c.PutSchedule(ctx, &schedule); err != nil {
+		return 0, err
+	}
+
+	c.cronMu.Lock()
+	defer c.cronMu.Unlock()
+	return c.registerSchedule(schedule), nil
+}
+
+// CreateSchedule persists a new schedule that enqueues the task according to the
+// cron spec (see cronParser), and returns its ID.
+func (c *Client) CreateSchedule(ctx context.Context, spec string, task tasks.Task) (string, error) {
+	schedule := Schedule{ID: uuid.New().String(), Spec: spec, Task: task}
+	if err := c.PutSchedule(ctx, &schedule); err != nil {
+		return "", err
+	}
+	return schedule.ID, nil
+}
+
+// PutSchedule creates the schedule, or replaces the schedule with the same ID
+// (keeping its creation time). It returns an error wrapping ErrInvalidSchedule if
+// the schedule has no ID or an invalid spec.
+// CreatedAt and UpdatedAt are set on the given schedule.
+func (c *Client) PutSchedule(ctx context.Context, schedule *Schedule) error {
+	if schedule.ID == "" {
+		return fmt.Errorf("%w: missing ID", ErrInvalidSchedule)
+	}
+	if _, err := parseSchedule(*schedule); err != nil {
+		return err
+	}
+	switch schedule.MisfirePolicy {
+	case "", MisfireSkip, MisfireFireOnce, MisfireFireAll:
+	default:
+		return fmt.Errorf("%w: unknown misfire policy %q", ErrInvalidSchedule, schedule.MisfirePolicy)
+	}
+
+	now := time.Now()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	if existing, err := c.GetSchedule(ctx, schedule.ID); err == nil {
+		schedule.CreatedAt = existing.CreatedAt
+	} else if err != redis.Nil {
+		return err
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	// Firings before a change of the schedule are never caught up
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, schedulesKey, schedule.ID, data)
+	pipe.HSet(ctx, scheduleLastFireKey, schedule.ID, now.UnixMilli())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return c.refreshSchedules(ctx)
+}
+
+// GetSchedule returns the schedule with the given ID.
+// It returns redis.Nil if the schedule does not exist.
+func (c *Client) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	data, err := c.rdb.HGet(ctx, schedulesKey, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	var schedule Schedule
+	if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// ListSchedules returns all the schedules, oldest first.
+func (c *Client) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	all, err := c.rdb.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]Schedule, 0, len(all))
+	for id, data := range all {
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+			logger.Log.Error().Err(err).Str("schedule_id", id).Msg("Invalid schedule")
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+// DeleteSchedule removes the schedule with the given ID.
+// It returns redis.Nil if the schedule does not exist.
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	pipe := c.rdb.TxPipeline()
+	del := pipe.HDel(ctx, schedulesKey, id)
+	pipe.HDel(ctx, scheduleLastFireKey, id)
+	pipe.Del(ctx, scheduleHistoryKey(id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return redis.Nil
+	}
+	return c.refreshSchedules(ctx)
+}
+
+// PauseSchedule stops the schedule from firing until it is resumed.
+// It returns redis.Nil if the schedule does not exist.
+func (c *Client) PauseSchedule(ctx context.Context, id string) (*Schedule, error) {
+	return c.setSchedulePaused(ctx, id, true)
+}
+
+// ResumeSchedule lets a paused schedule fire again.
+// It returns redis.Nil if the schedule does not exist.
+func (c *Client) ResumeSchedule(ctx context.Context, id string) (*Schedule, error) {
+	return c.setSchedulePaused(ctx, id, false)
+}
+
+// setSchedulePausedScript updates a schedule, unless it was deleted in the meantime.
+//
+// KEYS[1]: Schedules hash
+// KEYS[2]: Last fire times hash
+// ARGV[1]: Schedule ID
+// ARGV[2]: Schedule JSON
+// ARGV[3]: New last fire time (ms), if resumed
+var setSchedulePausedScript = newScript(`
+	if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	if ARGV[3] ~= '' then
+		redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+	end
+	return 1
+`)
+
+// setSchedulePaused updates the Paused flag of a schedule, unless the schedule was
+// deleted in the meantime.
+func (c *Client) setSchedulePaused(ctx context.Context, id string, paused bool) (*Schedule, error) {
+	schedule, err := c.GetSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Paused = paused
+	schedule.UpdatedAt = time.Now()
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// Firings missed while the schedule was paused are never caught up
+	baseline := ""
+	if !paused {
+		baseline = fmt.Sprint(schedule.UpdatedAt.UnixMilli())
+	}
+
+	updated, err := c.runScript(ctx, setSchedulePausedScript, []string{schedulesKey, scheduleLastFireKey}, id, data, baseline).Int()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		return nil, redis.Nil
+	}
+	return schedule, c.refreshSchedules(ctx)
+}
+
+// StartCronScheduler loads the schedules from Redis and starts firing them in a
+// background goroutine. The schedules are reloaded every scheduleSyncInterval, so
+// that schedules created, updated or deleted through other clients are picked up.
+// It should be called once when the application starts (e.g., in the server).
+//
+// Every client running a cron scheduler campaigns for the "cron" leadership (see
+// CronLeader), and only the leader fires schedules, so that each firing enqueues
+// its task once however many replicas run.
+func (c *Client) StartCronScheduler() {
+	c.cronMu.Lock()
+	if c.cronCancel != nil {
+		c.cronMu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cronCancel = cancel
+	c.cronMu.Unlock()
+
+	// Campaign once before starting, so that a single replica fires right away
+	c.cronLeader.campaign(ctx)
+	go c.cronLeader.Run(ctx)
+
+	if err := c.syncSchedules(ctx); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to load schedules")
+	}
+	c.cron.Start()
+
+	go func() {
+		ticker := time.NewTicker(scheduleSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.syncSchedules(ctx); err != nil {
+					logger.Log.Error().Err(err).Msg("Failed to reload schedules")
+				}
+			}
+		}
+	}()
+}
+
+// StopCronScheduler stops the cron scheduler and resigns the cron leadership, so
+// that another replica takes over right away. Schedules stay persisted in Redis.
+func (c *Client) StopCronScheduler() {
+	c.cronMu.Lock()
+	cancel := c.cronCancel
+	c.cronCancel = nil
+	c.cronMu.Unlock()
+
+	c.cron.Stop()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	if err := c.cronLeader.Resign(context.Background()); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to resign cron leadership")
+	}
+}
+
+// CronLeader returns the leader election of the cron scheduler, e.g. to report the
+// leadership in a metric with OnChange.
+func (c *Client) CronLeader() *LeaderElection {
+	return c.cronLeader
+}
+
+// refreshSchedules applies a change made through this client right away if its
+// cron scheduler is running, rather than at the next reload.
+func (c *Client) refreshSchedules(ctx context.Context) error {
+	c.cronMu.Lock()
+	running := c.cronCancel != nil
+	c.cronMu.Unlock()
+	if !running {
+		return nil
+	}
+	return c.syncSchedules(ctx)
+}
+
+// syncSchedules registers a cron job for every active schedule in Redis, replaces
+// the jobs of the schedules that changed, and removes the jobs of the schedules
+// that were paused or deleted. On the cron leader, it then catches up on missed
+// firings (see catchUpSchedules).
+func (c *Client) syncSchedules(ctx context.Context) error {
+	schedules, err := c.ListSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	c.registerSchedules(schedules)
+
+	if !c.cronLeader.IsLeader() {
+		return nil
+	}
+	return c.catchUpSchedules(ctx, schedules, time.Now())
+}
+
+// registerSchedules updates the cron jobs to match the given schedules.
+func (c *Client) registerSchedules(schedules []Schedule) {
+	c.cronMu.Lock()
+	defer c.cronMu.Unlock()
+
+	active := make(map[string]bool)
+	for _, schedule := range schedules {
+		if schedule.Paused {
+			continue
+		}
+		active[schedule.ID] = true
+		c.registerSchedule(schedule)
+	}
+
+	for scheduleID, entry := range c.cronEntries {
+		if !active[scheduleID] {
+			c.cron.Remove(entry.id)
+			delete(c.cronEntries, scheduleID)
+		}
+	}
+}
+
+// registerSchedule adds the cron job of an active schedule, replacing the job of
+// an older version of the schedule. It returns the ID of the cron entry, or 0 if
+// the spec is invalid. c.cronMu must be held.
+func (c *Client) registerSchedule(schedule Schedule) cron.EntryID {
+	entry, ok := c.cronEntries[schedule.ID]
+	if ok && entry.updatedAt.Equal(schedule.UpdatedAt) {
+		return entry.id
+	}
+	if ok {
+		c.cron.Remove(entry.id)
+		delete(c.cronEntries, schedule.ID)
+	}
+
+	sched, err := parseSchedule(schedule)
+	if err != nil {
+		logger.Log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Invalid schedule spec")
+		return 0
+	}
+	id := c.cron.Schedule(sched, cron.FuncJob(c.fireSchedule(schedule)))
+	c.cronEntries[schedule.ID] = cronEntry{id: id, updatedAt: schedule.UpdatedAt}
+	return id
+}
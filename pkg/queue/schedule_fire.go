@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+)
+
+// Misfire policies, applied to the firings of a schedule missed while no cron
+// scheduler was running (e.g. during a deploy or an outage).
+const (
+	MisfireSkip     = "skip"      // Drop the missed firings
+	MisfireFireOnce = "fire_once" // Fire once for all the missed firings
+	MisfireFireAll  = "fire_all"  // Fire every missed firing, up to maxMisfireRuns
+)
+
+// scheduleLastFireKey is the Redis hash holding the time of the latest firing of
+// every schedule: field = schedule ID, value = fire time (Unix ms).
+const scheduleLastFireKey = "schedule_last_fire"
+
+// scheduleRunTTL is how long the run key of a firing is remembered, and so how
+// long the firing is guaranteed to be enqueued at most once.
+const scheduleRunTTL = 24 * time.Hour
+
+// misfireThreshold is how late a firing must be to count as missed. Firings that
+// are late by less are left to the cron job, which may still be running.
+const misfireThreshold = 10 * time.Second
+
+// maxMisfireRuns caps the number of missed firings MisfireFireAll enqueues for a
+// schedule: only the latest ones are fired.
+const maxMisfireRuns = 100
+
+// scheduleRunKey returns the Redis key claimed by the firing of a schedule.
+func scheduleRunKey(runKey string) string {
+	return fmt.Sprintf("schedule_run:%s", runKey)
+}
+
+// lastFireLua defines advance(hash, id, fire), shared by the firing scripts. It
+// moves the latest firing of a schedule forward to fire (Unix ms), never backward.
+const lastFireLua = `
+	local function advance(hash, id, fire)
+		local last = tonumber(redis.call('HGET', hash, id) or 0)
+		if tonumber(fire) > last then
+			redis.call('HSET', hash, id, fire)
+		end
+	end
+`
+
+// claimRunScript claims the firing of a schedule, so that it is enqueued at most
+// once even if several schedulers try to fire it, and records the run in the
+// history of the schedule before its task is enqueued.
+//
+// KEYS[1]: Run key of the firing
+// KEYS[2]: Last fire times hash
+// KEYS[3]: History of the schedule
+// KEYS[4]: Lease of the cron leader
+// ARGV[1]: ID of the task enqueued for the firing
+// ARGV[2]: Run key TTL (ms)
+// ARGV[3]: Schedule ID
+// ARGV[4]: Fire time (ms)
+// ARGV[5]: Run JSON
+// ARGV[6]: History limit
+// ARGV[7]: Fencing token of the cron leader
+// Returns 1 if claimed, 0 if the firing was already claimed, -1 if the fencing
+// token is no longer current.
+var claimRunScript = newScript(lastFireLua + `
+	if redis.call('HGET', KEYS[4], 'token') ~= ARGV[7] then
+		return -1
+	end
+	if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+		return 0
+	end
+	advance(KEYS[2], ARGV[3], ARGV[4])
+	redis.call('LPUSH', KEYS[3], ARGV[5])
+	redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[6]) - 1)
+	return 1
+`)
+
+// advanceLastFireScript records a firing of a schedule without enqueuing it.
+//
+// KEYS[1]: Last fire times hash
+// ARGV[1]: Schedule ID
+// ARGV[2]: Fire time (ms)
+var advanceLastFireScript = newScript(lastFireLua + `
+	advance(KEYS[1], ARGV[1], ARGV[2])
+	return 1
+`)
+
+// fireSchedule returns the cron job of a schedule, which enqueues its task if this
+// client is the cron leader.
+func (c *Client) fireSchedule(schedule Schedule) func() {
+	return func() {
+		// Cron jobs run on whole seconds, shortly after their fire time
+		fireTime := time.Now().Truncate(time.Second)
+
+		// The leadership is checked after the jitter: a stopped scheduler resigns
+		time.Sleep(scheduleJitter(schedule.Jitter))
+		if _, err := c.fireRun(context.Background(), schedule, fireTime); err != nil {
+			logger.Log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Failed to enqueue scheduled task")
+		}
+	}
+}
+
+// fireRun enqueues the task of a schedule for the firing at fireTime, with a new
+// task ID and the run key of the firing. It reports whether the task was enqueued:
+// nothing happens if this client is not the cron leader, or if the firing was
+// already enqueued.
+func (c *Client) fireRun(ctx context.Context, schedule Schedule, fireTime time.Time) (bool, error) {
+	log := logger.Log.With().Str("schedule_id", schedule.ID).Str("type", schedule.Task.Type).Time("fire_time", fireTime).Logger()
+
+	// Only the leader fires, and only while its fencing token is current: the
+	// token is checked by the claim script, atomically with the claim
+	token := c.cronLeader.Token()
+	if token == 0 || !c.cronLeader.IsLeader() {
+		return false, nil
+	}
+
+	task := schedule.Task
+	task.ID = uuid.New().String()
+	task.CreatedAt = time.Now()
+	task.ScheduleID = schedule.ID
+	task.RunKey = fmt.Sprintf("%s:%s", schedule.ID, fireTime.UTC().Format(time.RFC3339))
+
+	// The run is recorded as enqueued when claimed, so that a task finishing
+	// right after Enqueue always finds its run in the history
+	run := ScheduleRun{FireTime: fireTime, RunKey: task.RunKey, TaskID: task.ID, Outcome: RunEnqueued}
+	record, err := json.Marshal(run)
+	if err != nil {
+		return false, err
+	}
+
+	runKey := scheduleRunKey(task.RunKey)
+	historyKey := scheduleHistoryKey(schedule.ID)
+	claimed, err := c.runScript(ctx, claimRunScript,
+		[]string{runKey, scheduleLastFireKey, historyKey, c.cronLeader.key},
+		task.ID,
+		scheduleRunTTL.Milliseconds(),
+		schedule.ID,
+		fireTime.UnixMilli(),
+		record,
+		scheduleHistoryLimit,
+		token,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	if claimed == -1 {
+		log.Warn().Msg("Lost cron leadership, scheduled task not enqueued")
+		return false, nil
+	}
+	if claimed == 0 {
+		log.Debug().Str("run_key", task.RunKey).Msg("Scheduled task already enqueued")
+		return false, nil
+	}
+
+	if err := c.Enqueue(ctx, task); errors.Is(err, ErrSingletonSkipped) {
+		log.Info().Msg("Scheduled task skipped, previous run still pending or running")
+		if err := c.finishScheduleRun(ctx, task, RunSkipped); err != nil {
+			log.Error().Err(err).Msg("Failed to record schedule run")
+		}
+		return false, nil
+	} else if err != nil {
+		// Let a later attempt enqueue the firing
+		pipe := c.rdb.TxPipeline()
+		pipe.Del(ctx, runKey)
+		pipe.LRem(ctx, historyKey, 1, record)
+		pipe.Exec(ctx)
+		return false, err
+	}
+
+	log.Info().Str("task_id", task.ID).Str("run_key", task.RunKey).Msg("Scheduled task enqueued")
+	return true, nil
+}
+
+// catchUpSchedules applies the misfire policy of every active schedule to the
+// firings it missed before now, i.e. those between its latest firing and
+// now - misfireThreshold.
+func (c *Client) catchUpSchedules(ctx context.Context, schedules []Schedule, now time.Time) error {
+	for _, schedule := range schedules {
+		if schedule.Paused {
+			continue
+		}
+		if err := c.catchUpSchedule(ctx, schedule, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUpSchedule applies the misfire policy of a schedule to its missed firings.
+func (c *Client) catchUpSchedule(ctx context.Context, schedule Schedule, now time.Time) error {
+	sched, err := parseSchedule(schedule)
+	if err != nil {
+		return nil // Reported by syncSchedules
+	}
+
+	lastMs, err := c.rdb.HGet(ctx, scheduleLastFireKey, schedule.ID).Result()
+	if errors.Is(err, redis.Nil) {
+		// First seen: nothing was missed yet
+		return c.rdb.HSetNX(ctx, scheduleLastFireKey, schedule.ID, now.UnixMilli()).Err()
+	}
+	if err != nil {
+		return err
+	}
+	ms, err := strconv.ParseInt(lastMs, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	// Jittered firings are late on purpose
+	missed := missedFirings(sched, time.UnixMilli(ms), now.Add(-misfireThreshold-schedule.Jitter))
+	if len(missed) == 0 {
+		return nil
+	}
+	log := logger.Log.With().Str("schedule_id", schedule.ID).Int("missed", len(missed)).Logger()
+
+	switch schedule.MisfirePolicy {
+	case MisfireFireAll:
+		log.Info().Msg("Firing missed schedule runs")
+	case MisfireFireOnce:
+		log.Info().Msg("Firing the latest missed schedule run")
+		missed = missed[len(missed)-1:]
+	default:
+		log.Info().Msg("Skipping missed schedule runs")
+		latest := missed[len(missed)-1]
+		if err := c.runScript(ctx, advanceLastFireScript, []string{scheduleLastFireKey}, schedule.ID, latest.UnixMilli()).Err(); err != nil {
+			return err
+		}
+		return c.recordMissedRuns(ctx, schedule.ID, missed)
+	}
+
+	for _, fireTime := range missed {
+		if _, err := c.fireRun(ctx, schedule, fireTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordMissedRuns adds the latest skipped firings of a schedule to its history.
+func (c *Client) recordMissedRuns(ctx context.Context, scheduleID string, missed []time.Time) error {
+	if len(missed) > scheduleHistoryLimit {
+		missed = missed[len(missed)-scheduleHistoryLimit:]
+	}
+	runs := make([]ScheduleRun, 0, len(missed))
+	for _, fireTime := range missed {
+		runs = append(runs, ScheduleRun{
+			FireTime: fireTime,
+			RunKey:   fmt.Sprintf("%s:%s", scheduleID, fireTime.UTC().Format(time.RFC3339)),
+			Outcome:  RunMissed,
+		})
+	}
+	return c.recordScheduleRuns(ctx, scheduleID, runs...)
+}
+
+// missedFirings returns the latest maxMisfireRuns fire times of sched after last
+// and up to cutoff, oldest first.
+func missedFirings(sched cron.Schedule, last, cutoff time.Time) []time.Time {
+	var missed []time.Time
+	for t := sched.Next(last); !t.IsZero() && !t.After(cutoff); t = sched.Next(t) {
+		missed = append(missed, t)
+		if len(missed) > maxMisfireRuns {
+			missed = missed[1:]
+		}
+	}
+	return missed
+}
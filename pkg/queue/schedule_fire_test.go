@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// scheduledTasks returns the tasks enqueued in queue:high.
+func scheduledTasks(t *testing.T, client *Client) []tasks.Task {
+	t.Helper()
+	items, err := client.rdb.LRange(context.Background(), "queue:high", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	var enqueued []tasks.Task
+	for _, item := range items {
+		var task tasks.Task
+		if err := json.Unmarshal([]byte(item), &task); err != nil {
+			t.Fatalf("Invalid task %s: %v", item, err)
+		}
+		enqueued = append(enqueued, task)
+	}
+	return enqueued
+}
+
+func TestFireRunDeduplicated(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	schedule := Schedule{ID: "report", Spec: "@every 1m", Task: tasks.Task{ID: "template", Type: "report", Priority: tasks.PriorityHigh}}
+	fireTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.fireRun(ctx, schedule, fireTime); err != nil {
+			t.Fatalf("fireRun failed: %v", err)
+		}
+	}
+	if _, err := client.fireRun(ctx, schedule, fireTime.Add(time.Minute)); err != nil {
+		t.Fatalf("fireRun failed: %v", err)
+	}
+
+	enqueued := scheduledTasks(t, client)
+	if len(enqueued) != 2 {
+		t.Fatalf("Expected 1 task per firing, got %d", len(enqueued))
+	}
+	if enqueued[0].ID == "template" || enqueued[0].ID == enqueued[1].ID {
+		t.Errorf("Expected a fresh task ID per firing, got %q and %q", enqueued[0].ID, enqueued[1].ID)
+	}
+	if enqueued[0].ScheduleID != "report" || enqueued[0].RunKey != "report:2026-03-01T12:00:00Z" {
+		t.Errorf("Unexpected schedule ID %q and run key %q", enqueued[0].ScheduleID, enqueued[0].RunKey)
+	}
+
+	// Followers never fire
+	follower := &Client{rdb: client.rdb}
+	follower.cronLeader = NewLeaderElection(follower, "cron", cronLeaderLease)
+	follower.cronLeader.campaign(ctx)
+	if fired, err := follower.fireRun(ctx, schedule, fireTime.Add(2*time.Minute)); err != nil || fired {
+		t.Errorf("Expected a follower not to fire, got %v, %v", fired, err)
+	}
+
+	// A stalled leader whose lease was taken over does not fire either, even
+	// though it still believes it leads
+	s.Del(client.cronLeader.key)
+	follower.cronLeader.campaign(ctx)
+	if !client.cronLeader.IsLeader() {
+		t.Fatal("Expected the stalled leader to still believe it leads")
+	}
+	if fired, err := client.fireRun(ctx, schedule, fireTime.Add(3*time.Minute)); err != nil || fired {
+		t.Errorf("Expected a stale leader not to fire, got %v, %v", fired, err)
+	}
+	if s.Exists(scheduleRunKey("report:2026-03-01T12:03:00Z")) {
+		t.Error("Expected the firing not to be claimed by the stale leader")
+	}
+}
+
+func TestMisfirePolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy   string
+		expected int
+	}{
+		{"", 0},
+		{MisfireSkip, 0},
+		{MisfireFireOnce, 1},
+		{MisfireFireAll, 5},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			s, client := setupTestRedis()
+			defer s.Close()
+			ctx := context.Background()
+			client.cronLeader.campaign(ctx)
+
+			schedule := Schedule{
+				ID:            "minutely",
+				Spec:          "0 * * * * *",
+				Task:          tasks.Task{Type: "report", Priority: tasks.PriorityHigh},
+				MisfirePolicy: tt.policy,
+			}
+
+			// The scheduler was down for 5 firings
+			down := time.Now().Truncate(time.Minute).Add(-5 * time.Minute)
+			now := down.Add(5*time.Minute + 30*time.Second)
+			client.rdb.HSet(ctx, scheduleLastFireKey, schedule.ID, down.UnixMilli())
+
+			if err := client.catchUpSchedules(ctx, []Schedule{schedule}, now); err != nil {
+				t.Fatalf("catchUpSchedules failed: %v", err)
+			}
+			enqueued := scheduledTasks(t, client)
+			if len(enqueued) != tt.expected {
+				t.Fatalf("Expected %d tasks, got %d", tt.expected, len(enqueued))
+			}
+			if tt.policy == MisfireFireOnce {
+				expected := "minutely:" + down.Add(5*time.Minute).UTC().Format(time.RFC3339)
+				if enqueued[0].RunKey != expected {
+					t.Errorf("Expected the latest firing %q, got %q", expected, enqueued[0].RunKey)
+				}
+			}
+
+			// Missed firings are handled once
+			if err := client.catchUpSchedules(ctx, []Schedule{schedule}, now); err != nil {
+				t.Fatalf("catchUpSchedules failed: %v", err)
+			}
+			if n := len(scheduledTasks(t, client)); n != tt.expected {
+				t.Errorf("Expected missed firings to be handled once, got %d tasks", n)
+			}
+		})
+	}
+}
+
+func TestMisfireAfterResume(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	schedule := &Schedule{ID: "minutely", Spec: "0 * * * * *", Task: tasks.Task{Type: "report", Priority: tasks.PriorityHigh}, MisfirePolicy: MisfireFireAll}
+	if err := client.PutSchedule(ctx, schedule); err != nil {
+		t.Fatalf("PutSchedule failed: %v", err)
+	}
+	if err := client.PutSchedule(ctx, &Schedule{ID: "invalid", Spec: "@daily", MisfirePolicy: "later"}); err == nil {
+		t.Error("Expected an unknown misfire policy to be rejected")
+	}
+
+	// Firings missed while paused are never caught up
+	client.rdb.HSet(ctx, scheduleLastFireKey, schedule.ID, time.Now().Add(-time.Hour).UnixMilli())
+	if _, err := client.ResumeSchedule(ctx, schedule.ID); err != nil {
+		t.Fatalf("ResumeSchedule failed: %v", err)
+	}
+	if err := client.catchUpSchedules(ctx, []Schedule{*schedule}, time.Now()); err != nil {
+		t.Fatalf("catchUpSchedules failed: %v", err)
+	}
+	if n := len(scheduledTasks(t, client)); n != 0 {
+		t.Errorf("Expected no catch-up after resume, got %d tasks", n)
+	}
+}
@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// Outcomes of a schedule run.
+const (
+	RunEnqueued  = "enqueued"  // The task was enqueued and has not finished yet
+	RunCompleted = "completed" // The task completed successfully
+	RunFailed    = "failed"    // The task was moved to the Dead Letter Queue or expired
+	RunSkipped   = "skipped"   // The singleton task of the previous run was still pending or running
+	RunMissed    = "missed"    // No scheduler was running, and the misfire policy skipped the run
+)
+
+// scheduleHistoryLimit is the number of runs kept in the history of a schedule.
+const scheduleHistoryLimit = 20
+
+// scheduleNextFires is the number of upcoming fire times returned by ScheduleInfo.
+const scheduleNextFires = 5
+
+// scheduleHistoryKey returns the Redis list holding the latest runs of a schedule,
+// newest first.
+func scheduleHistoryKey(scheduleID string) string {
+	return fmt.Sprintf("schedule_history:%s", scheduleID)
+}
+
+// ScheduleRun is a firing of a schedule.
+type ScheduleRun struct {
+	FireTime   time.Time `json:"fire_time"`
+	RunKey     string    `json:"run_key"`
+	TaskID     string    `json:"task_id,omitempty"` // Empty for missed runs
+	Outcome    string    `json:"outcome"`
+	FinishedAt time.Time `json:"finished_at,omitzero"` // When the task completed or failed
+}
+
+// ScheduleInfo describes a schedule, its latest runs and its upcoming fire times.
+type ScheduleInfo struct {
+	Schedule *Schedule     `json:"schedule"`
+	Runs     []ScheduleRun `json:"runs"` // Latest runs, newest first
+	// NextFireTimes are the upcoming fire times, before jitter. Paused schedules
+	// have none.
+	NextFireTimes []time.Time `json:"next_fire_times"`
+}
+
+// finishScheduleRunScript records the outcome of the task of a schedule run, or
+// that the run was skipped. Runs that already left the history are ignored.
+//
+// KEYS[1]: History of the schedule
+// ARGV[1]: Task ID
+// ARGV[2]: Outcome
+// ARGV[3]: Finish time (RFC 3339)
+// Returns 1 if the run was found, 0 otherwise.
+var finishScheduleRunScript = newScript(`
+	local runs = redis.call('LRANGE', KEYS[1], 0, -1)
+	for i, data in ipairs(runs) do
+		local run = cjson.decode(data)
+		if run.task_id == ARGV[1] then
+			run.outcome = ARGV[2]
+			if ARGV[2] == 'skipped' then
+				run.task_id = nil -- The task of a skipped run was never enqueued
+			else
+				run.finished_at = ARGV[3]
+			end
+			redis.call('LSET', KEYS[1], i - 1, cjson.encode(run))
+			return 1
+		end
+	end
+	return 0
+`)
+
+// ScheduleInfo returns the schedule with the given ID, its latest runs and its
+// next fire times. It returns redis.Nil if the schedule does not exist.
+func (c *Client) ScheduleInfo(ctx context.Context, id string) (*ScheduleInfo, error) {
+	schedule, err := c.GetSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := c.rdb.LRange(ctx, scheduleHistoryKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	info := &ScheduleInfo{
+		Schedule:      schedule,
+		Runs:          make([]ScheduleRun, 0, len(items)),
+		NextFireTimes: []time.Time{},
+	}
+	for _, item := range items {
+		var run ScheduleRun
+		if err := json.Unmarshal([]byte(item), &run); err != nil {
+			return nil, err
+		}
+		info.Runs = append(info.Runs, run)
+	}
+
+	if !schedule.Paused {
+		sched, err := parseSchedule(*schedule)
+		if err != nil {
+			return nil, err
+		}
+		next := time.Now()
+		for i := 0; i < scheduleNextFires; i++ {
+			if next = sched.Next(next); next.IsZero() {
+				break
+			}
+			info.NextFireTimes = append(info.NextFireTimes, next)
+		}
+	}
+	return info, nil
+}
+
+// recordScheduleRuns adds runs to the history of a schedule, keeping the latest
+// scheduleHistoryLimit ones.
+func (c *Client) recordScheduleRuns(ctx context.Context, scheduleID string, runs ...ScheduleRun) error {
+	if len(runs) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(runs))
+	for _, run := range runs {
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		values = append(values, data)
+	}
+
+	key := scheduleHistoryKey(scheduleID)
+	pipe := c.rdb.TxPipeline()
+	pipe.LPush(ctx, key, values...)
+	pipe.LTrim(ctx, key, 0, scheduleHistoryLimit-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// finishScheduleRun records the outcome of a task enqueued by a schedule, or
+// that the schedule run was skipped.
+func (c *Client) finishScheduleRun(ctx context.Context, task tasks.Task, outcome string) error {
+	return c.runScript(ctx, finishScheduleRunScript,
+		[]string{scheduleHistoryKey(task.ScheduleID)},
+		task.ID,
+		outcome,
+		time.Now().Format(time.RFC3339Nano),
+	).Err()
+}
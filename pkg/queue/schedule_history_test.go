@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestScheduleInfo(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	schedule := &Schedule{ID: "report", Spec: "0 0 * * * *", Task: tasks.Task{Type: "report", Priority: tasks.PriorityHigh}}
+	if err := client.PutSchedule(ctx, schedule); err != nil {
+		t.Fatalf("PutSchedule failed: %v", err)
+	}
+
+	// Two runs: the first completes, the second fails
+	fireTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, err := client.fireRun(ctx, *schedule, fireTime.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("fireRun failed: %v", err)
+		}
+	}
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if err := client.CompleteWithResult(ctx, *task, raw, nil); err != nil {
+		t.Fatalf("CompleteWithResult failed: %v", err)
+	}
+	task, raw, err = client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if err := client.Fail(ctx, *task, raw); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	info, err := client.ScheduleInfo(ctx, "report")
+	if err != nil {
+		t.Fatalf("ScheduleInfo failed: %v", err)
+	}
+	if len(info.Runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %+v", info.Runs)
+	}
+	failed, completed := info.Runs[0], info.Runs[1]
+	if completed.Outcome != RunCompleted || !completed.FireTime.Equal(fireTime) || completed.TaskID == "" || completed.FinishedAt.IsZero() {
+		t.Errorf("Unexpected completed run %+v", completed)
+	}
+	if failed.Outcome != RunFailed || failed.TaskID != task.ID || failed.RunKey != "report:2026-03-01T13:00:00Z" {
+		t.Errorf("Unexpected failed run %+v", failed)
+	}
+
+	if len(info.NextFireTimes) != scheduleNextFires {
+		t.Fatalf("Expected %d next fire times, got %v", scheduleNextFires, info.NextFireTimes)
+	}
+	for i, next := range info.NextFireTimes {
+		if next.Minute() != 0 || next.Second() != 0 || (i > 0 && next.Sub(info.NextFireTimes[i-1]) != time.Hour) {
+			t.Errorf("Unexpected next fire times %v", info.NextFireTimes)
+			break
+		}
+	}
+
+	// Paused schedules do not fire
+	if _, err := client.PauseSchedule(ctx, "report"); err != nil {
+		t.Fatalf("PauseSchedule failed: %v", err)
+	}
+	if info, _ := client.ScheduleInfo(ctx, "report"); len(info.NextFireTimes) != 0 {
+		t.Errorf("Expected no next fire times while paused, got %v", info.NextFireTimes)
+	}
+
+	if err := client.DeleteSchedule(ctx, "report"); err != nil {
+		t.Fatalf("DeleteSchedule failed: %v", err)
+	}
+	if _, err := client.ScheduleInfo(ctx, "report"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil, got %v", err)
+	}
+	if s.Exists(scheduleHistoryKey("report")) {
+		t.Error("Expected the history to be deleted with the schedule")
+	}
+}
+
+func TestScheduleHistoryMissedAndTrimmed(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	schedule := Schedule{ID: "minutely", Spec: "0 * * * * *", Task: tasks.Task{Type: "report"}}
+	if err := client.PutSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("PutSchedule failed: %v", err)
+	}
+
+	// The scheduler was down for an hour
+	down := time.Now().Truncate(time.Minute).Add(-time.Hour)
+	client.rdb.HSet(ctx, scheduleLastFireKey, schedule.ID, down.UnixMilli())
+	if err := client.catchUpSchedules(ctx, []Schedule{schedule}, down.Add(time.Hour+30*time.Second)); err != nil {
+		t.Fatalf("catchUpSchedules failed: %v", err)
+	}
+
+	info, err := client.ScheduleInfo(ctx, "minutely")
+	if err != nil {
+		t.Fatalf("ScheduleInfo failed: %v", err)
+	}
+	if len(info.Runs) != scheduleHistoryLimit {
+		t.Fatalf("Expected the latest %d runs, got %d", scheduleHistoryLimit, len(info.Runs))
+	}
+	if latest := info.Runs[0]; latest.Outcome != RunMissed || !latest.FireTime.Equal(down.Add(time.Hour)) || latest.TaskID != "" {
+		t.Errorf("Unexpected latest run %+v", latest)
+	}
+}
+
+func TestScheduleHistorySkippedAndFailedRuns(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	// The second run is skipped while the singleton task of the first is pending
+	schedule := Schedule{ID: "report", Spec: "0 0 * * * *", Task: tasks.Task{Type: "report", Priority: tasks.PriorityHigh, SingletonKey: "report"}}
+	fireTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, err := client.fireRun(ctx, schedule, fireTime.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("fireRun failed: %v", err)
+		}
+	}
+
+	// A run whose task cannot be enqueued leaves no trace, so it can be fired again
+	invalid := schedule
+	invalid.Task.SingletonPolicy = "sometimes"
+	if _, err := client.fireRun(ctx, invalid, fireTime.Add(2*time.Hour)); err == nil {
+		t.Fatal("Expected fireRun to fail")
+	}
+	if s.Exists(scheduleRunKey("report:2026-03-01T14:00:00Z")) {
+		t.Error("Expected the run key of the failed run to be released")
+	}
+
+	runs, err := client.rdb.LRange(ctx, scheduleHistoryKey("report"), 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %v", runs)
+	}
+	var skipped, enqueued ScheduleRun
+	json.Unmarshal([]byte(runs[0]), &skipped)
+	json.Unmarshal([]byte(runs[1]), &enqueued)
+	if skipped.Outcome != RunSkipped || skipped.TaskID != "" || !skipped.FinishedAt.IsZero() {
+		t.Errorf("Unexpected skipped run %+v", skipped)
+	}
+	if enqueued.Outcome != RunEnqueued || enqueued.TaskID == "" {
+		t.Errorf("Unexpected enqueued run %+v", enqueued)
+	}
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// allHours is the bit set of a cron hour field matching every hour.
+const allHours = 1<<24 - 1
+
+// parseSchedule returns the cron schedule of a schedule, evaluated in its time zone
+// (server-local time if it has none).
+func parseSchedule(schedule Schedule) (cron.Schedule, error) {
+	if schedule.TimeZone != "" && (strings.HasPrefix(schedule.Spec, "TZ=") || strings.HasPrefix(schedule.Spec, "CRON_TZ=")) {
+		return nil, fmt.Errorf("%w: time zone set both in the spec and the schedule", ErrInvalidSchedule)
+	}
+	if schedule.Jitter < 0 {
+		return nil, fmt.Errorf("%w: negative jitter", ErrInvalidSchedule)
+	}
+
+	sched, err := cronParser.Parse(schedule.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	spec, ok := sched.(*cron.SpecSchedule)
+	if !ok {
+		// Fixed intervals (e.g. "@every 1h") do not depend on the time zone
+		return sched, nil
+	}
+	if schedule.TimeZone != "" {
+		if spec.Location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	}
+	if spec.Hour&allHours == allHours {
+		// Schedules firing every hour keep their pace across DST changes: they
+		// do not fire in the skipped hour and fire twice in the repeated one.
+		return spec, nil
+	}
+
+	wall := *spec
+	wall.Location = time.UTC
+	return zonedSchedule{wall: &wall, loc: spec.Location}, nil
+}
+
+// zonedSchedule fires at given wall-clock times of a time zone, across DST changes:
+// a time skipped when the clocks go forward fires when they jump, and a time
+// repeated when they go back fires only at its first occurrence. For instance,
+// "0 30 2 * * *" in Europe/Rome fires at 03:00 CEST on the last Sunday of March,
+// and once at 02:30 CEST on the last Sunday of October.
+type zonedSchedule struct {
+	wall *cron.SpecSchedule // Spec evaluated on wall-clock times, expressed in UTC
+	loc  *time.Location
+}
+
+// Next returns the next fire time after t.
+func (s zonedSchedule) Next(t time.Time) time.Time {
+	local := t.In(s.loc)
+	w := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+	for {
+		if w = s.wall.Next(w); w.IsZero() {
+			return w
+		}
+		// Wall-clock times repeated by DST map back to times already past
+		if fire := wallClockInstant(w, s.loc); fire.After(t) {
+			return fire
+		}
+	}
+}
+
+// wallClockInstant returns the first instant at which the clocks of loc show the
+// wall-clock time w (expressed in UTC), or the instant the clocks jump past w if
+// loc skips it.
+func wallClockInstant(w time.Time, loc *time.Location) time.Time {
+	t := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, loc)
+	start, end := t.ZoneBounds()
+
+	if !sameWallClock(t, w) {
+		// Skipped: t is within the gap of the transition, on either side of it
+		if end.IsZero() || (!start.IsZero() && t.Sub(start) < end.Sub(t)) {
+			return start
+		}
+		return end
+	}
+
+	if !start.IsZero() {
+		_, before := start.Add(-time.Second).Zone()
+		_, after := t.Zone()
+		if before > after {
+			// Possibly repeated: prefer the occurrence before the transition
+			if earlier := t.Add(-time.Duration(before-after) * time.Second); sameWallClock(earlier, w) {
+				return earlier
+			}
+		}
+	}
+	return t
+}
+
+// sameWallClock reports whether t shows the wall-clock time w (expressed in UTC).
+func sameWallClock(t, w time.Time) bool {
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := w.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2 &&
+		t.Hour() == w.Hour() && t.Minute() == w.Minute() && t.Second() == w.Second()
+}
+
+// scheduleJitter returns a random delay in [0, jitter), added to a firing so that
+// schedules due at the same time do not enqueue all at once.
+func scheduleJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter)
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestScheduleTimeZoneDST(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skipf("Time zone database unavailable: %v", err)
+	}
+	sched, err := parseSchedule(Schedule{Spec: "0 30 2 * * *", TimeZone: "Europe/Rome"})
+	if err != nil {
+		t.Fatalf("parseSchedule failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		from     time.Time
+		expected []time.Time
+	}{
+		{
+			// 02:30 does not exist on March 29: it fires when the clocks jump to 03:00
+			name: "spring forward",
+			from: time.Date(2026, 3, 28, 12, 0, 0, 0, rome),
+			expected: []time.Time{
+				time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 30, 0, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			// 02:30 happens twice on October 25: it fires at the first one only
+			name: "fall back",
+			from: time.Date(2026, 10, 24, 12, 0, 0, 0, rome),
+			expected: []time.Time{
+				time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC),
+				time.Date(2026, 10, 26, 1, 30, 0, 0, time.UTC),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			next := tt.from
+			for _, expected := range tt.expected {
+				next = sched.Next(next)
+				if !next.Equal(expected) {
+					t.Fatalf("Expected %v, got %v", expected, next.UTC())
+				}
+			}
+		})
+	}
+
+	// Schedules firing every hour keep their pace
+	hourly, _ := parseSchedule(Schedule{Spec: "0 0 * * * *", TimeZone: "Europe/Rome"})
+	from := time.Date(2026, 10, 25, 1, 0, 0, 0, rome)
+	for i := 1; i <= 3; i++ {
+		if next := hourly.Next(from); !next.Equal(from.Add(time.Hour)) {
+			t.Fatalf("Expected hourly firing %d at %v, got %v", i, from.Add(time.Hour).UTC(), next.UTC())
+		}
+		from = from.Add(time.Hour)
+	}
+}
+
+func TestScheduleTimeZoneCatchUp(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skipf("Time zone database unavailable: %v", err)
+	}
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+	client.cronLeader.campaign(ctx)
+
+	schedule := Schedule{
+		ID:            "nightly-report",
+		Spec:          "0 0 2 * * *",
+		TimeZone:      "Europe/Rome",
+		Task:          tasks.Task{Type: "report", Priority: tasks.PriorityHigh},
+		MisfirePolicy: MisfireFireAll,
+	}
+	client.rdb.HSet(ctx, scheduleLastFireKey, schedule.ID, time.Date(2026, 6, 1, 2, 0, 0, 0, rome).UnixMilli())
+
+	// Fake clock: two days later, in the morning
+	now := time.Date(2026, 6, 3, 9, 0, 0, 0, rome)
+	if err := client.catchUpSchedules(ctx, []Schedule{schedule}, now); err != nil {
+		t.Fatalf("catchUpSchedules failed: %v", err)
+	}
+	enqueued := scheduledTasks(t, client)
+	if len(enqueued) != 2 || enqueued[0].RunKey != "nightly-report:2026-06-02T00:00:00Z" || enqueued[1].RunKey != "nightly-report:2026-06-03T00:00:00Z" {
+		t.Errorf("Expected the firings at 02:00 CEST, got %+v", enqueued)
+	}
+}
+
+func TestScheduleInvalidTimeZoneAndJitter(t *testing.T) {
+	for _, schedule := range []Schedule{
+		{ID: "a", Spec: "@daily", TimeZone: "Mars/Olympus_Mons"},
+		{ID: "b", Spec: "CRON_TZ=Europe/Rome 0 0 2 * * *", TimeZone: "Europe/Rome"},
+		{ID: "c", Spec: "@daily", Jitter: -time.Second},
+	} {
+		if _, err := parseSchedule(schedule); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("Expected schedule %s to be invalid, got %v", schedule.ID, err)
+		}
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	if d := scheduleJitter(0); d != 0 {
+		t.Errorf("Expected no jitter, got %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := scheduleJitter(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("Expected a jitter in [0, 1s), got %v", d)
+		}
+	}
+}
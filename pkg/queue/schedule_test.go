@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// cronJobs returns the number of cron jobs registered by the client.
+func cronJobs(client *Client) int {
+	return len(client.cron.Entries())
+}
+
+func TestScheduleCRUD(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	schedule := &Schedule{ID: "nightly-report", Spec: "@daily", Task: tasks.Task{Type: "report"}}
+	if err := client.PutSchedule(ctx, schedule); err != nil {
+		t.Fatalf("PutSchedule failed: %v", err)
+	}
+	created := schedule.CreatedAt
+
+	got, err := client.GetSchedule(ctx, "nightly-report")
+	if err != nil {
+		t.Fatalf("GetSchedule failed: %v", err)
+	}
+	if got.Spec != "@daily" || got.Task.Type != "report" || got.Paused {
+		t.Errorf("Unexpected schedule %+v", got)
+	}
+
+	// Replacing a schedule keeps its ID and creation time
+	schedule = &Schedule{ID: "nightly-report", Spec: "@hourly", Task: tasks.Task{Type: "report"}}
+	if err := client.PutSchedule(ctx, schedule); err != nil {
+		t.Fatalf("PutSchedule failed: %v", err)
+	}
+	if !schedule.CreatedAt.Equal(created) || !schedule.UpdatedAt.After(created) {
+		t.Errorf("Expected the creation time to be kept, got %v (updated %v)", schedule.CreatedAt, schedule.UpdatedAt)
+	}
+
+	id, err := client.CreateSchedule(ctx, "@every 1m", tasks.Task{Type: "cleanup"})
+	if err != nil {
+		t.Fatalf("CreateSchedule failed: %v", err)
+	}
+	schedules, err := client.ListSchedules(ctx)
+	if err != nil {
+		t.Fatalf("ListSchedules failed: %v", err)
+	}
+	if len(schedules) != 2 || schedules[0].ID != "nightly-report" || schedules[1].ID != id || schedules[0].Spec != "@hourly" {
+		t.Errorf("Unexpected schedules %+v", schedules)
+	}
+
+	if err := client.DeleteSchedule(ctx, "nightly-report"); err != nil {
+		t.Fatalf("DeleteSchedule failed: %v", err)
+	}
+	if _, err := client.GetSchedule(ctx, "nightly-report"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil for a deleted schedule, got %v", err)
+	}
+	if err := client.DeleteSchedule(ctx, "nightly-report"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil when deleting twice, got %v", err)
+	}
+	if _, err := client.PauseSchedule(ctx, "nightly-report"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil when pausing a deleted schedule, got %v", err)
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, schedule := range []*Schedule{
+		{ID: "", Spec: "@daily"},
+		{ID: "bad", Spec: "not a cron spec"},
+	} {
+		if err := client.PutSchedule(ctx, schedule); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("Expected ErrInvalidSchedule for %+v, got %v", schedule, err)
+		}
+	}
+}
+
+func TestSchedulePauseResume(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.StartCronScheduler()
+	defer client.StopCronScheduler()
+
+	client.PutSchedule(ctx, &Schedule{ID: "a", Spec: "@daily", Task: tasks.Task{Type: "report"}})
+	client.PutSchedule(ctx, &Schedule{ID: "b", Spec: "@daily", Task: tasks.Task{Type: "report"}})
+	if n := cronJobs(client); n != 2 {
+		t.Fatalf("Expected 2 cron jobs, got %d", n)
+	}
+
+	paused, err := client.PauseSchedule(ctx, "a")
+	if err != nil || !paused.Paused {
+		t.Fatalf("PauseSchedule failed: %v", err)
+	}
+	if n := cronJobs(client); n != 1 {
+		t.Errorf("Expected the paused schedule to be unregistered, got %d jobs", n)
+	}
+	if got, _ := client.GetSchedule(ctx, "a"); !got.Paused {
+		t.Error("Expected the schedule to be persisted as paused")
+	}
+
+	if _, err := client.ResumeSchedule(ctx, "a"); err != nil {
+		t.Fatalf("ResumeSchedule failed: %v", err)
+	}
+	client.DeleteSchedule(ctx, "b")
+	if n := cronJobs(client); n != 1 {
+		t.Errorf("Expected 1 cron job, got %d", n)
+	}
+}
+
+func TestSchedulesReloaded(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// Schedules created before the scheduler starts (e.g. before a restart)...
+	client.PutSchedule(ctx, &Schedule{ID: "a", Spec: "@daily", Task: tasks.Task{Type: "report"}})
+	client.PutSchedule(ctx, &Schedule{ID: "b", Spec: "@daily", Task: tasks.Task{Type: "report"}, Paused: true})
+
+	// ...are loaded by a new client
+	replica := NewClient(s.Addr())
+	replica.StartCronScheduler()
+	defer replica.StopCronScheduler()
+	if n := cronJobs(replica); n != 1 {
+		t.Fatalf("Expected the active schedule to be loaded, got %d jobs", n)
+	}
+
+	// Changes made through another client are picked up at the next reload
+	client.PutSchedule(ctx, &Schedule{ID: "a", Spec: "@hourly", Task: tasks.Task{Type: "report"}})
+	client.ResumeSchedule(ctx, "b")
+	client.PutSchedule(ctx, &Schedule{ID: "c", Spec: "@daily", Task: tasks.Task{Type: "report"}})
+	if err := replica.syncSchedules(ctx); err != nil {
+		t.Fatalf("syncSchedules failed: %v", err)
+	}
+	if n := cronJobs(replica); n != 3 {
+		t.Errorf("Expected 3 cron jobs, got %d", n)
+	}
+
+	client.DeleteSchedule(ctx, "c")
+	replica.syncSchedules(ctx)
+	if n := cronJobs(replica); n != 2 {
+		t.Errorf("Expected 2 cron jobs, got %d", n)
+	}
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scripts is the registry of the Lua scripts of the package. Every script is
+// declared as a package-level variable with newScript, and loaded into the script
+// cache of Redis when a client is created, so that calls only send its SHA1.
+var scripts []*redis.Script
+
+// scriptLoadTimeout bounds the loading of the scripts when a client is created.
+const scriptLoadTimeout = 2 * time.Second
+
+// nowMsLua defines now_ms(), the Unix time in ms by the Redis clock. Scripts that
+// compare against leases or deadlines use it rather than the time of the caller,
+// so that clock skew between processes does not matter.
+const nowMsLua = `
+	local function now_ms()
+		local time = redis.call('TIME')
+		return tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+	end
+`
+
+// newScript returns a script and adds it to the registry.
+func newScript(src string) *redis.Script {
+	script := redis.NewScript(src)
+	scripts = append(scripts, script)
+	return script
+}
+
+// loadScripts loads all the registered scripts into the script cache of Redis,
+// in a single round trip.
+func (c *Client) loadScripts(ctx context.Context) error {
+	pipe := c.rdb.Pipeline()
+	for _, script := range scripts {
+		script.Load(ctx, pipe)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// runScript runs a registered script with EVALSHA. If Redis lost its script cache
+// (e.g. after a restart, a failover or SCRIPT FLUSH), it reloads the whole registry,
+// so that the other scripts do not miss too, and runs the script again.
+func (c *Client) runScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := script.EvalSha(ctx, c.rdb, keys, args...)
+	if !redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+		return cmd
+	}
+	if err := c.loadScripts(ctx); err != nil {
+		// The script itself can still be sent in full
+		return script.Eval(ctx, c.rdb, keys, args...)
+	}
+	return script.EvalSha(ctx, c.rdb, keys, args...)
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// benchmarkRedisDB is the database index used by benchmarks against a real Redis,
+// so that they never touch the queues of a stack running on the same server.
+const benchmarkRedisDB = 15
+
+// redisBenchmarkClient returns a client of database benchmarkRedisDB of the Redis
+// at BENCH_REDIS_ADDR, and a function flushing that database after the benchmark.
+// Benchmarks against a real Redis are opt-in: it skips the benchmark if
+// BENCH_REDIS_ADDR is unset.
+func redisBenchmarkClient(b *testing.B) (*Client, func()) {
+	addr := os.Getenv("BENCH_REDIS_ADDR")
+	if addr == "" {
+		b.Skip("Skipping benchmark: BENCH_REDIS_ADDR is not set")
+	}
+	client := NewClient(addr)
+	client.rdb.Close()
+	client.rdb = redis.NewClient(&redis.Options{Addr: addr, DB: benchmarkRedisDB})
+	ctx := context.Background()
+	if err := client.rdb.Ping(ctx).Err(); err != nil {
+		b.Skipf("Skipping benchmark: Redis not reachable at %s (%v)", addr, err)
+	}
+	client.rdb.FlushDB(ctx)
+	return client, func() { client.rdb.FlushDB(ctx) }
+}
+
+// benchmarkClient returns a client of the Redis at BENCH_REDIS_ADDR (see
+// redisBenchmarkClient), or of a miniredis if unset, and a function cleaning up
+// after the benchmark.
+func benchmarkClient(b *testing.B) (*Client, func()) {
+	if os.Getenv("BENCH_REDIS_ADDR") != "" {
+		return redisBenchmarkClient(b)
+	}
+	s, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("Failed to start miniredis: %v", err)
+	}
+	return NewClient(s.Addr()), s.Close
+}
+
+// BenchmarkDequeueRateLimited measures the hot path of a worker: dequeue a task,
+// check its rate limit, complete it. The rate limit check runs its script in two
+// ways:
+//
+//   - registry: EVALSHA of the script preloaded by NewClient
+//
+//   - eval: EVAL, sending and compiling the whole script on every call
+//
+//     go test ./pkg/queue -run '^$' -bench DequeueRateLimited -benchmem
+func BenchmarkDequeueRateLimited(b *testing.B) {
+	reserve := map[string]func(ctx context.Context, client *Client, keys []string, args ...interface{}) *redis.Cmd{
+		"registry": func(ctx context.Context, client *Client, keys []string, args ...interface{}) *redis.Cmd {
+			return client.runScript(ctx, tokenBucketScript, keys, args...)
+		},
+		"eval": func(ctx context.Context, client *Client, keys []string, args ...interface{}) *redis.Cmd {
+			return tokenBucketScript.Eval(ctx, client.rdb, keys, args...)
+		},
+	}
+
+	for _, mode := range []string{"registry", "eval"} {
+		b.Run(mode, func(b *testing.B) {
+			client, cleanup := benchmarkClient(b)
+			defer cleanup()
+			ctx := context.Background()
+
+			task := tasks.Task{Type: "email", Priority: tasks.PriorityHigh}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				task.ID = fmt.Sprint(i)
+				if err := client.Enqueue(ctx, task); err != nil {
+					b.Fatalf("Enqueue failed: %v", err)
+				}
+				b.StartTimer()
+
+				dequeued, raw, err := client.Dequeue(ctx)
+				if err != nil {
+					b.Fatalf("Dequeue failed: %v", err)
+				}
+				err = reserve[mode](ctx, client, []string{"ratelimit:" + dequeued.Type}, 1e6, 1000, time.Now().UnixMilli()).Err()
+				if err != nil {
+					b.Fatalf("Rate limit failed: %v", err)
+				}
+				if err := client.Complete(ctx, raw); err != nil {
+					b.Fatalf("Complete failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRateLimit measures a rate limit check alone, for each limiter.
+func BenchmarkRateLimit(b *testing.B) {
+	client, cleanup := benchmarkClient(b)
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, limiter := range []struct {
+		name    string
+		limiter Limiter
+	}{
+		{"token_bucket", NewTokenBucket(client, 1e6, 1000)},
+		{"gcra", NewGCRA(client, 1e6, 1000)},
+		{"sliding_window_log", NewSlidingWindowLog(client, 1000, time.Millisecond)},
+		{"sliding_window_counter", NewSlidingWindowCounter(client, 1000, time.Millisecond)},
+	} {
+		b.Run(limiter.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := limiter.limiter.Reserve(ctx, limiter.name); err != nil {
+					b.Fatalf("Reserve failed: %v", err)
+				}
+			}
+		})
+	}
+}
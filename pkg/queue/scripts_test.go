@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// scriptsCached reports whether all the registered scripts are in the script cache.
+func scriptsCached(t *testing.T, client *Client) bool {
+	t.Helper()
+	hashes := make([]string, 0, len(scripts))
+	for _, script := range scripts {
+		hashes = append(hashes, script.Hash())
+	}
+	exists, err := client.rdb.ScriptExists(context.Background(), hashes...).Result()
+	if err != nil {
+		t.Fatalf("ScriptExists failed: %v", err)
+	}
+	for _, ok := range exists {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScriptsPreloaded(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+
+	if len(scripts) < 20 {
+		t.Fatalf("Expected all the scripts of the package to be registered, got %d", len(scripts))
+	}
+	if !scriptsCached(t, client) {
+		t.Error("Expected NewClient to load all the scripts")
+	}
+}
+
+func TestScriptsReloadedOnNoScript(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// Redis restarted: the script cache is empty
+	client.rdb.ScriptFlush(ctx)
+	if allowed, err := client.Allow(ctx, "api", 10, 1); err != nil || !allowed {
+		t.Fatalf("Expected Allow to succeed, got %v, %v", allowed, err)
+	}
+	if !scriptsCached(t, client) {
+		t.Error("Expected all the scripts to be reloaded")
+	}
+
+	// Scripts in a pipeline run again once reloaded
+	client.rdb.ScriptFlush(ctx)
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{
+		{ID: "a", Type: "sync", OrderingKey: "account-1", Priority: tasks.PriorityHigh},
+		{ID: "b", Type: "sync", Priority: tasks.PriorityHigh},
+	})
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("EnqueueBatch failed: %v, %v", err, errs)
+	}
+	if n, _ := client.rdb.LLen(ctx, "queue:high").Result(); n != 2 {
+		t.Errorf("Expected 2 tasks enqueued, got %d", n)
+	}
+}
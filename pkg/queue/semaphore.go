@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// semaphoreKey returns the Redis sorted set holding the holders of a semaphore.
+func semaphoreKey(key string) string {
+	return fmt.Sprintf("semaphore:%s", key)
+}
+
+// acquireSemaphoreScript acquires or renews a slot of a semaphore, atomically
+// checking the limit. Leases are measured by the Redis clock.
+//
+// KEYS[1]: Semaphore sorted set
+// ARGV[1]: Holder
+// ARGV[2]: Limit
+// ARGV[3]: Lease duration (ms)
+var acquireSemaphoreScript = newScript(nowMsLua + `
+	local key = KEYS[1]
+	local now = now_ms()
+	local lease = tonumber(ARGV[3])
+
+	-- Drop holders whose lease has expired
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+
+	if redis.call('ZSCORE', key, ARGV[1]) or redis.call('ZCARD', key) < tonumber(ARGV[2]) then
+		redis.call('ZADD', key, now + lease, ARGV[1])
+		if redis.call('PTTL', key) < lease then
+			redis.call('PEXPIRE', key, lease)
+		end
+		return 1
+	end
+	return 0
+`)
+
+// releaseSemaphoreScript gives back the slot of a holder, and drops the holders
+// whose lease has expired by the Redis clock.
+//
+// KEYS[1]: Semaphore sorted set
+// ARGV[1]: Holder
+var releaseSemaphoreScript = newScript(nowMsLua + `
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now_ms())
+	return 1
+`)
+
+// AcquireSemaphore tries to take one of the limit slots of the distributed semaphore
+// identified by key, on behalf of holder (typically a task ID). It returns true if the
+// slot was acquired, and false if all slots are taken.
+//
+// Slots are leases: a holder that neither releases nor re-acquires its slot within
+// lease (e.g. because its worker crashed) loses it, so a slot is never held forever.
+// Calling AcquireSemaphore again for the same holder renews its lease, which long
+// running holders should do periodically. Leases are timed by the Redis server, so
+// the clocks of the workers do not need to agree.
+//
+// Redis layout (sorted set "semaphore:{key}"): member = holder, score = lease expiry (Unix ms).
+func (c *Client) AcquireSemaphore(ctx context.Context, key string, limit int, holder string, lease time.Duration) (bool, error) {
+	acquired, err := c.runScript(ctx, acquireSemaphoreScript,
+		[]string{semaphoreKey(key)},
+		holder,
+		limit,
+		lease.Milliseconds(),
+	).Int()
+	return acquired == 1, err
+}
+
+// ReleaseSemaphore gives back the slot held by holder. Releasing a slot that is
+// not held (or whose lease already expired) is a no-op.
+func (c *Client) ReleaseSemaphore(ctx context.Context, key string, holder string) error {
+	return c.runScript(ctx, releaseSemaphoreScript, []string{semaphoreKey(key)}, holder).Err()
+}
@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreLimit(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		ok, err := client.AcquireSemaphore(ctx, "image_resize", 4, fmt.Sprintf("task-%d", i), time.Minute)
+		if err != nil || !ok {
+			t.Fatalf("Expected slot %d to be acquired (%v)", i, err)
+		}
+	}
+
+	if ok, _ := client.AcquireSemaphore(ctx, "image_resize", 4, "task-4", time.Minute); ok {
+		t.Fatal("Expected the fifth holder to be rejected")
+	}
+
+	// Renewing a held slot succeeds even when the semaphore is full
+	if ok, _ := client.AcquireSemaphore(ctx, "image_resize", 4, "task-0", time.Minute); !ok {
+		t.Error("Expected an existing holder to renew its slot")
+	}
+
+	// Other keys are independent
+	if ok, _ := client.AcquireSemaphore(ctx, "email", 1, "task-4", time.Minute); !ok {
+		t.Error("Expected a slot on another key")
+	}
+
+	if err := client.ReleaseSemaphore(ctx, "image_resize", "task-1"); err != nil {
+		t.Fatalf("ReleaseSemaphore failed: %v", err)
+	}
+	if ok, _ := client.AcquireSemaphore(ctx, "image_resize", 4, "task-4", time.Minute); !ok {
+		t.Error("Expected a released slot to be acquired")
+	}
+}
+
+func TestSemaphoreLeaseExpiry(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if ok, _ := client.AcquireSemaphore(ctx, "render", 1, "crashed", 50*time.Millisecond); !ok {
+		t.Fatal("Expected the slot to be acquired")
+	}
+	if ok, _ := client.AcquireSemaphore(ctx, "render", 1, "waiting", 50*time.Millisecond); ok {
+		t.Fatal("Expected the semaphore to be full")
+	}
+
+	// The holder never renews its lease, so the slot frees up
+	time.Sleep(100 * time.Millisecond)
+	if ok, _ := client.AcquireSemaphore(ctx, "render", 1, "waiting", time.Minute); !ok {
+		t.Error("Expected the expired slot to be reclaimed")
+	}
+
+	// Leases follow the Redis clock, whatever the clock of the worker says
+	s.SetTime(time.Now().Add(2 * time.Minute))
+	if ok, _ := client.AcquireSemaphore(ctx, "render", 1, "late", time.Minute); !ok {
+		t.Error("Expected the slot to expire by the Redis clock")
+	}
+}
@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// ErrSingletonSkipped is returned by Enqueue when a task with the SingletonSkip policy
+// is not enqueued because another task of its SingletonKey is pending or running.
+var ErrSingletonSkipped = errors.New("singleton task already pending or running")
+
+// singletonLockKey returns the Redis key holding the ID of the running task of a singleton key.
+func singletonLockKey(key string) string {
+	return fmt.Sprintf("singleton:%s", key)
+}
+
+// singletonPendingKey returns the Redis hash describing the pending task of a singleton key.
+func singletonPendingKey(key string) string {
+	return fmt.Sprintf("singleton:%s:pending", key)
+}
+
+// singletonPolicy returns the policy of a singleton task, validating it.
+// A singleton task cannot have an OrderingKey, whose per-key FIFO guarantee the
+// singleton policies would break.
+func singletonPolicy(task tasks.Task) (string, error) {
+	if task.OrderingKey != "" {
+		return "", errors.New("singleton tasks cannot have an ordering key")
+	}
+	switch task.SingletonPolicy {
+	case "", tasks.SingletonSkip:
+		return tasks.SingletonSkip, nil
+	case tasks.SingletonQueue, tasks.SingletonReplace:
+		return task.SingletonPolicy, nil
+	}
+	return "", fmt.Errorf("unknown singleton policy %q", task.SingletonPolicy)
+}
+
+// singletonPendingTTL is how long a pending singleton task without an ExpiresAt
+// deadline blocks its key if it never runs (e.g. because it was lost with its worker).
+const singletonPendingTTL = 24 * time.Hour
+
+// singletonWaitingKey returns the Redis list of tasks waiting for the running task
+// of a singleton key to finish.
+func singletonWaitingKey(key string) string {
+	return fmt.Sprintf("singleton:%s:waiting", key)
+}
+
+// singletonAdmitLua defines the Lua functions shared by the singleton scripts.
+//
+// Entries of the waiting list are JSON objects holding the task JSON ("task"),
+// its ID ("id"), its priority queue ("queue") and its deadline in Unix ms
+// ("deadline", 0 if none).
+const singletonAdmitLua = nowMsLua + `
+	-- Makes the task the pending task of the key and pushes it to its queue. The
+	-- pending marker expires at the task deadline, or after default_ttl (ms).
+	local function admit(pending_key, entry, default_ttl)
+		local ttl = default_ttl
+		if entry.deadline > 0 then
+			ttl = math.max(1, entry.deadline - now_ms())
+		end
+		redis.call('HSET', pending_key, 'id', entry.id, 'task', entry.task, 'queue', entry.queue)
+		redis.call('PEXPIRE', pending_key, ttl)
+		redis.call('RPUSH', entry.queue, entry.task)
+	end
+
+	-- Admits the first waiting task once no task of the key is running or pending
+	local function admit_next(lock_key, pending_key, waiting_key, default_ttl)
+		if redis.call('EXISTS', lock_key) == 1 or redis.call('EXISTS', pending_key) == 1 then
+			return
+		end
+		local next = redis.call('LPOP', waiting_key)
+		if next then
+			admit(pending_key, cjson.decode(next), default_ttl)
+		end
+	end
+`
+
+// enqueueSingletonScript enqueues a task with a SingletonKey according to its policy.
+//
+// At most one task of the key is pending (in its priority queue) at a time; with
+// SingletonQueue, further tasks wait in the waiting list of the key, and with
+// SingletonReplace a single task waits there while another one runs.
+//
+// KEYS[1]: Lock of the singleton key
+// KEYS[2]: Pending task of the singleton key
+// KEYS[3]: Waiting list of the singleton key
+// KEYS[4]: Priority queue of the task
+// ARGV[1]: Policy ("skip", "queue" or "replace")
+// ARGV[2]: Task JSON
+// ARGV[3]: Task ID
+// ARGV[4]: Task deadline (Unix ms, 0 if none)
+// ARGV[5]: Pending TTL of tasks without a deadline (ms)
+// Returns 1 if the task was enqueued or is waiting, 0 if it was skipped.
+var enqueueSingletonScript = newScript(singletonAdmitLua + `
+	local busy = function()
+		return redis.call('EXISTS', KEYS[1]) == 1 or redis.call('EXISTS', KEYS[2]) == 1
+			or redis.call('LLEN', KEYS[3]) > 0
+	end
+	local ttl = tonumber(ARGV[5])
+
+	if ARGV[1] == 'skip' then
+		if busy() then
+			return 0
+		end
+	elseif ARGV[1] == 'replace' then
+		-- Drop the pending task, wherever it waits (its queue or, if it was
+		-- postponed, the delayed queue), and the waiting tasks
+		local pending = redis.call('HMGET', KEYS[2], 'task', 'queue')
+		if pending[1] then
+			redis.call('LREM', pending[2], 1, pending[1])
+			redis.call('ZREM', 'delayed_queue', pending[1])
+			redis.call('HDEL', 'requeued_queues', pending[1])
+			redis.call('DEL', KEYS[2])
+		end
+		redis.call('DEL', KEYS[3])
+	end
+
+	-- The next waiting task goes first if the pending one was lost
+	admit_next(KEYS[1], KEYS[2], KEYS[3], ttl)
+
+	local entry = {id = ARGV[3], task = ARGV[2], queue = KEYS[4], deadline = tonumber(ARGV[4])}
+	if busy() then
+		redis.call('RPUSH', KEYS[3], cjson.encode(entry))
+	else
+		admit(KEYS[2], entry, ttl)
+	end
+	return 1
+`)
+
+// enqueueSingletonArgs returns the KEYS and ARGV of enqueueSingletonScript for the task.
+func enqueueSingletonArgs(task tasks.Task, policy string, data []byte) ([]string, []interface{}) {
+	keys := []string{
+		singletonLockKey(task.SingletonKey),
+		singletonPendingKey(task.SingletonKey),
+		singletonWaitingKey(task.SingletonKey),
+		queueName(task.Priority),
+	}
+	var deadline int64
+	if !task.ExpiresAt.IsZero() {
+		deadline = task.ExpiresAt.UnixMilli()
+	}
+	return keys, []interface{}{policy, data, task.ID, deadline, singletonPendingTTL.Milliseconds()}
+}
+
+// acquireSingletonScript takes or renews the lock of a singleton key.
+//
+// KEYS[1]: Lock of the singleton key
+// KEYS[2]: Pending task of the singleton key
+// ARGV[1]: Task ID
+// ARGV[2]: Lease (ms)
+var acquireSingletonScript = newScript(`
+	local holder = redis.call('GET', KEYS[1])
+	if holder and holder ~= ARGV[1] then
+		return 0
+	end
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+
+	-- The task is no longer pending once it runs
+	if redis.call('HGET', KEYS[2], 'id') == ARGV[1] then
+		redis.call('DEL', KEYS[2])
+	end
+	return 1
+`)
+
+// AcquireSingleton takes the lock of the task's SingletonKey before the task runs,
+// and reports whether the task may run. Since the tasks of a key are released to
+// their queue one at a time, it is only denied in edge cases (e.g. while the lease
+// of a crashed holder runs out); a task that cannot run now should be postponed
+// with Requeue, without consuming a retry.
+//
+// The lock expires after lease unless renewed by calling AcquireSingleton again,
+// so a crashed worker never blocks the key for longer than lease. It is released
+// when the task completes or is dead-lettered.
+func (c *Client) AcquireSingleton(ctx context.Context, task tasks.Task, lease time.Duration) (bool, error) {
+	acquired, err := c.runScript(ctx, acquireSingletonScript,
+		[]string{singletonLockKey(task.SingletonKey), singletonPendingKey(task.SingletonKey)},
+		task.ID,
+		lease.Milliseconds(),
+	).Int()
+	return acquired == 1, err
+}
+
+// releaseSingletonScript releases the lock of a singleton key, clears its
+// pending task and admits the next waiting task, if any.
+//
+// KEYS[1]: Lock of the singleton key
+// KEYS[2]: Pending task of the singleton key
+// KEYS[3]: Waiting list of the singleton key
+// ARGV[1]: Task ID
+// ARGV[2]: Pending TTL of tasks without a deadline (ms)
+var releaseSingletonScript = newScript(singletonAdmitLua + `
+	if redis.call('GET', KEYS[1]) == ARGV[1] then
+		redis.call('DEL', KEYS[1])
+	end
+	if redis.call('HGET', KEYS[2], 'id') == ARGV[1] then
+		redis.call('DEL', KEYS[2])
+	end
+	admit_next(KEYS[1], KEYS[2], KEYS[3], tonumber(ARGV[2]))
+	return 1
+`)
+
+// releaseSingleton is called when a singleton task completes, is dead-lettered or
+// expires. It releases the lock of its key if the task holds it, clears the task as
+// the pending task of its key (e.g. when it expired before running), and releases
+// the first task waiting for the key to its priority queue.
+func (c *Client) releaseSingleton(ctx context.Context, task tasks.Task) error {
+	return c.runScript(ctx, releaseSingletonScript,
+		[]string{singletonLockKey(task.SingletonKey), singletonPendingKey(task.SingletonKey), singletonWaitingKey(task.SingletonKey)},
+		task.ID,
+		singletonPendingTTL.Milliseconds(),
+	).Err()
+}
@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+func singletonTask(id, policy string) tasks.Task {
+	return tasks.Task{ID: id, Type: "report", Priority: tasks.PriorityHigh, SingletonKey: "nightly-report", SingletonPolicy: policy}
+}
+
+func TestSingletonSkip(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if err := client.Enqueue(ctx, singletonTask("s1", "")); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	// Skipped while s1 is pending...
+	if err := client.Enqueue(ctx, singletonTask("s2", tasks.SingletonSkip)); !errors.Is(err, ErrSingletonSkipped) {
+		t.Fatalf("Expected ErrSingletonSkipped, got %v", err)
+	}
+
+	// ...and while it is running
+	task, raw, _ := client.Dequeue(ctx)
+	if ok, err := client.AcquireSingleton(ctx, *task, time.Minute); err != nil || !ok {
+		t.Fatalf("Expected the lock to be acquired (%v)", err)
+	}
+	if err := client.Enqueue(ctx, singletonTask("s3", tasks.SingletonSkip)); !errors.Is(err, ErrSingletonSkipped) {
+		t.Fatalf("Expected ErrSingletonSkipped, got %v", err)
+	}
+
+	// Completion releases the key
+	client.CompleteWithResult(ctx, *task, raw, nil)
+	if err := client.Enqueue(ctx, singletonTask("s4", tasks.SingletonSkip)); err != nil {
+		t.Errorf("Expected the task to be enqueued, got %v", err)
+	}
+
+	// So does completion without a result
+	task, raw, _ = client.Dequeue(ctx)
+	client.AcquireSingleton(ctx, *task, time.Minute)
+	if err := client.Complete(ctx, raw); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if err := client.Enqueue(ctx, singletonTask("s5", tasks.SingletonSkip)); err != nil {
+		t.Errorf("Expected the task to be enqueued, got %v", err)
+	}
+}
+
+func TestSingletonReplace(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	client.Enqueue(ctx, singletonTask("r1", tasks.SingletonReplace))
+	client.Enqueue(ctx, singletonTask("r2", tasks.SingletonReplace))
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "r2" {
+		t.Fatalf("Expected r2 to replace r1, got %v", ids)
+	}
+
+	running, raw, _ := client.Dequeue(ctx)
+	if ok, _ := client.AcquireSingleton(ctx, *running, time.Minute); !ok {
+		t.Fatal("Expected the lock to be acquired")
+	}
+
+	// r3 waits for the running r2, and r4 replaces it there
+	client.Enqueue(ctx, singletonTask("r3", tasks.SingletonReplace))
+	client.Enqueue(ctx, singletonTask("r4", tasks.SingletonReplace))
+	if ids := queuedIDs(t, client); len(ids) != 0 {
+		t.Fatalf("Expected no task to be queued while r2 runs, got %v", ids)
+	}
+
+	client.Complete(ctx, raw)
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "r4" {
+		t.Errorf("Expected r4 to be released, got %v", ids)
+	}
+
+	// A pending task postponed to the delayed queue is replaced there too
+	task, raw, _ := client.Dequeue(ctx)
+	client.Requeue(ctx, *task, raw, time.Minute)
+	client.Enqueue(ctx, singletonTask("r5", tasks.SingletonReplace))
+	if depth := client.GetQueueDepths(ctx)["delayed_queue"]; depth != 0 {
+		t.Errorf("Expected r4 to be dropped from the delayed queue, got depth %d", depth)
+	}
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "r5" {
+		t.Errorf("Expected r5 to be queued, got %v", ids)
+	}
+}
+
+func TestSingletonQueue(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, id := range []string{"q1", "q2", "q3"} {
+		if err := client.Enqueue(ctx, singletonTask(id, tasks.SingletonQueue)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	// Only the first task is released; the others wait in enqueue order
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "q1" {
+		t.Fatalf("Expected only q1 to be queued, got %v", ids)
+	}
+
+	first, raw, _ := client.Dequeue(ctx)
+	if ok, _ := client.AcquireSingleton(ctx, *first, time.Minute); !ok {
+		t.Fatal("Expected q1 to acquire the lock")
+	}
+	if ids := queuedIDs(t, client); len(ids) != 0 {
+		t.Fatalf("Expected q2 to wait while q1 runs, got %v", ids)
+	}
+
+	// Completion releases the next task, and so does dead-lettering
+	client.Complete(ctx, raw)
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "q2" {
+		t.Fatalf("Expected q2 to be released, got %v", ids)
+	}
+	second, raw, _ := client.Dequeue(ctx)
+	if ok, _ := client.AcquireSingleton(ctx, *second, time.Minute); !ok {
+		t.Fatal("Expected q2 to acquire the lock")
+	}
+	client.Fail(ctx, *second, raw)
+	if ids := queuedIDs(t, client); len(ids) != 1 || ids[0] != "q3" {
+		t.Errorf("Expected q3 to be released, got %v", ids)
+	}
+}
+
+func TestSingletonPendingExpiry(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// The pending marker of a task with a deadline expires with the task
+	task := singletonTask("d1", tasks.SingletonSkip)
+	task.ExpiresAt = time.Now().Add(time.Minute)
+	client.Enqueue(ctx, task)
+	if ttl := s.TTL(singletonPendingKey("nightly-report")); ttl <= 50*time.Second || ttl > time.Minute {
+		t.Errorf("Expected the marker to expire with the task, got TTL %s", ttl)
+	}
+
+	// A lost task without a deadline blocks the key for singletonPendingTTL at most
+	s.FlushAll()
+	client.Enqueue(ctx, singletonTask("lost", tasks.SingletonSkip))
+	s.FastForward(singletonPendingTTL)
+	if err := client.Enqueue(ctx, singletonTask("s2", tasks.SingletonSkip)); err != nil {
+		t.Errorf("Expected the lost task to stop blocking the key, got %v", err)
+	}
+
+	// A task that expires in its queue frees the key
+	s.FlushAll()
+	task = singletonTask("e1", tasks.SingletonSkip)
+	task.ExpiresAt = time.Now().Add(-time.Second)
+	client.Enqueue(ctx, task)
+	if _, _, err := client.Dequeue(ctx); err != redis.Nil {
+		t.Fatalf("Expected the expired task to be skipped, got %v", err)
+	}
+	if err := client.Enqueue(ctx, singletonTask("e2", tasks.SingletonSkip)); err != nil {
+		t.Errorf("Expected the expired task to free the key, got %v", err)
+	}
+}
+
+func TestSingletonLeaseExpiry(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	crashed := singletonTask("crashed", tasks.SingletonQueue)
+	if ok, _ := client.AcquireSingleton(ctx, crashed, time.Second); !ok {
+		t.Fatal("Expected the lock to be acquired")
+	}
+
+	// The holder never renews its lease
+	s.FastForward(2 * time.Second)
+	if ok, _ := client.AcquireSingleton(ctx, singletonTask("next", tasks.SingletonQueue), time.Second); !ok {
+		t.Error("Expected the expired lock to be taken over")
+	}
+}
+
+func TestSingletonInvalidPolicy(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if err := client.Enqueue(ctx, singletonTask("x", "sometimes")); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+
+	// A singleton task cannot also be ordered, whatever its policy
+	for _, policy := range []string{tasks.SingletonSkip, tasks.SingletonQueue, tasks.SingletonReplace} {
+		task := singletonTask("o-"+policy, policy)
+		task.OrderingKey = "customer-a"
+		if err := client.Enqueue(ctx, task); err == nil {
+			t.Errorf("Expected an error for an ordered %s singleton", policy)
+		}
+	}
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 0 {
+		t.Errorf("Expected no task to be enqueued, got %d", depth)
+	}
+
+	errs, err := client.EnqueueBatch(ctx, []tasks.Task{
+		singletonTask("b1", tasks.SingletonSkip),
+		singletonTask("b2", tasks.SingletonSkip),
+		singletonTask("b3", "sometimes"),
+	})
+	if err != nil {
+		t.Fatalf("EnqueueBatch failed: %v", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], ErrSingletonSkipped) || errs[2] == nil {
+		t.Errorf("Unexpected item errors: %v", errs)
+	}
+}
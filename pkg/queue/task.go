@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// NewTask builds a task of the given type whose payload is the JSON encoding of payload.
+// The task gets a fresh UUID, the current time as CreatedAt and the default priority;
+// callers may adjust any field before enqueuing it.
+//
+// Example:
+//
+//	type EmailPayload struct {
+//		To string `json:"to"`
+//	}
+//
+//	task, err := queue.NewTask("email", EmailPayload{To: "user@example.com"})
+//	if err != nil {
+//		return err
+//	}
+//	err = client.Enqueue(ctx, task)
+func NewTask[T any](taskType string, payload T) (tasks.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return tasks.Task{}, err
+	}
+
+	return tasks.Task{
+		ID:        uuid.New().String(),
+		Type:      taskType,
+		Payload:   data,
+		CreatedAt: time.Now(),
+		Priority:  tasks.PriorityDefault,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+type testPayload struct {
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+func TestNewTask(t *testing.T) {
+	task, err := NewTask("email", testPayload{To: "test@example.com", Count: 3})
+	if err != nil {
+		t.Fatalf("NewTask failed: %v", err)
+	}
+
+	if task.ID == "" {
+		t.Error("Expected task ID to be generated")
+	}
+	if task.Type != "email" {
+		t.Errorf("Expected type email, got %s", task.Type)
+	}
+	if task.Priority != tasks.PriorityDefault {
+		t.Errorf("Expected default priority, got %d", task.Priority)
+	}
+	if task.CreatedAt.IsZero() {
+		t.Error("Expected CreatedAt to be set")
+	}
+	if string(task.Payload) != `{"to":"test@example.com","count":3}` {
+		t.Errorf("Unexpected payload: %s", task.Payload)
+	}
+}
+
+func TestNewTaskPayloadRoundTrip(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	task, err := NewTask("email", testPayload{To: "test@example.com", Count: 3})
+	if err != nil {
+		t.Fatalf("NewTask failed: %v", err)
+	}
+	if err := client.Enqueue(ctx, task); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	dequeued, _, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+
+	var payload testPayload
+	if err := json.Unmarshal(dequeued.Payload, &payload); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if payload.To != "test@example.com" || payload.Count != 3 {
+		t.Errorf("Unexpected payload after round trip: %+v", payload)
+	}
+}
+
+func TestNewTaskInvalidPayload(t *testing.T) {
+	if _, err := NewTask("bad", make(chan int)); err == nil {
+		t.Error("Expected error for unserializable payload")
+	}
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// workerCommandsChannel is the Pub/Sub channel on which commands are sent to the workers.
+const workerCommandsChannel = "worker_commands"
+
+// Worker commands, sent with SendWorkerCommand.
+const (
+	WorkerPause    = "pause"    // Stop taking new tasks; running tasks finish
+	WorkerResume   = "resume"   // Take new tasks again after a pause
+	WorkerDrain    = "drain"    // Stop taking new tasks, finish the running ones, then exit
+	WorkerShutdown = "shutdown" // Exit now, cancelling the running tasks
+)
+
+// Worker states, reported in WorkerInfo.State.
+const (
+	WorkerRunning  = "running"
+	WorkerPaused   = "paused"
+	WorkerDraining = "draining"
+	WorkerStopping = "stopping"
+)
+
+// ErrInvalidWorkerCommand is returned by SendWorkerCommand for an unknown command.
+var ErrInvalidWorkerCommand = errors.New("invalid worker command")
+
+// WorkerCommand is a command sent to the workers, delivered by WorkerRegistration.Commands.
+type WorkerCommand struct {
+	Command  string    `json:"command"`
+	WorkerID string    `json:"worker_id,omitempty"` // Target worker; all workers if empty
+	Queue    string    `json:"queue,omitempty"`     // Only the workers taking tasks from this queue
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// targets reports whether the command is meant for the worker.
+func (cmd WorkerCommand) targets(info WorkerInfo) bool {
+	if cmd.WorkerID != "" && cmd.WorkerID != info.ID {
+		return false
+	}
+	return cmd.Queue == "" || slices.Contains(info.Queues, cmd.Queue)
+}
+
+// SendWorkerCommand sends a command to the live workers it targets, and returns
+// their IDs. It returns redis.Nil if cmd targets a single worker that is not
+// registered, and an error wrapping ErrInvalidWorkerCommand if the command is unknown.
+//
+// Commands are delivered with Pub/Sub, so only the workers subscribed when the
+// command is sent receive it: a worker started afterwards runs normally.
+func (c *Client) SendWorkerCommand(ctx context.Context, cmd WorkerCommand) ([]string, error) {
+	switch cmd.Command {
+	case WorkerPause, WorkerResume, WorkerDrain, WorkerShutdown:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidWorkerCommand, cmd.Command)
+	}
+
+	workers, err := c.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targets := []string{}
+	for _, info := range workers {
+		if cmd.targets(info) {
+			targets = append(targets, info.ID)
+		}
+	}
+	if cmd.WorkerID != "" && len(targets) == 0 && !slices.ContainsFunc(workers, func(info WorkerInfo) bool {
+		return info.ID == cmd.WorkerID
+	}) {
+		return nil, redis.Nil
+	}
+	if len(targets) == 0 {
+		return targets, nil
+	}
+
+	cmd.SentAt = time.Now()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.rdb.Publish(ctx, workerCommandsChannel, data).Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// runWorker runs the registration of a worker until the test ends, and waits
+// for it to be listed.
+func runWorker(t *testing.T, client *Client, info WorkerInfo) *WorkerRegistration {
+	t.Helper()
+	reg := NewWorkerRegistration(client, info)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reg.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		workers, err := client.ListWorkers(context.Background())
+		if err != nil {
+			t.Fatalf("ListWorkers failed: %v", err)
+		}
+		for _, w := range workers {
+			if w.ID == reg.ID() {
+				return reg
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Worker %s not registered", reg.ID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expectCommand fails the test unless the worker receives the command.
+func expectCommand(t *testing.T, reg *WorkerRegistration, command string) {
+	t.Helper()
+	select {
+	case cmd := <-reg.Commands():
+		if cmd.Command != command {
+			t.Errorf("Expected %q, got %q", command, cmd.Command)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Worker %s did not receive %q", reg.ID(), command)
+	}
+}
+
+// expectNoCommand fails the test if the worker has a pending command.
+func expectNoCommand(t *testing.T, reg *WorkerRegistration) {
+	t.Helper()
+	select {
+	case cmd := <-reg.Commands():
+		t.Errorf("Worker %s unexpectedly received %q", reg.ID(), cmd.Command)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendWorkerCommand(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	all := runWorker(t, client, WorkerInfo{})
+	high := runWorker(t, client, WorkerInfo{Queues: []string{"queue:high"}})
+
+	// A single worker
+	targets, err := client.SendWorkerCommand(ctx, WorkerCommand{Command: WorkerPause, WorkerID: high.ID()})
+	if err != nil || len(targets) != 1 || targets[0] != high.ID() {
+		t.Fatalf("Expected to target %s, got %v, %v", high.ID(), targets, err)
+	}
+	expectCommand(t, high, WorkerPause)
+	expectNoCommand(t, all)
+
+	// The workers of a queue
+	targets, err = client.SendWorkerCommand(ctx, WorkerCommand{Command: WorkerDrain, Queue: "queue:low"})
+	if err != nil || len(targets) != 1 || targets[0] != all.ID() {
+		t.Fatalf("Expected to target %s, got %v, %v", all.ID(), targets, err)
+	}
+	expectCommand(t, all, WorkerDrain)
+	expectNoCommand(t, high)
+
+	// All workers
+	targets, err = client.SendWorkerCommand(ctx, WorkerCommand{Command: WorkerResume})
+	if err != nil || len(targets) != 2 {
+		t.Fatalf("Expected to target both workers, got %v, %v", targets, err)
+	}
+	expectCommand(t, all, WorkerResume)
+	expectCommand(t, high, WorkerResume)
+
+	if _, err := client.SendWorkerCommand(ctx, WorkerCommand{Command: WorkerShutdown, WorkerID: "missing"}); err != redis.Nil {
+		t.Errorf("Expected redis.Nil for an unknown worker, got %v", err)
+	}
+	if _, err := client.SendWorkerCommand(ctx, WorkerCommand{Command: "restart"}); !errors.Is(err, ErrInvalidWorkerCommand) {
+		t.Errorf("Expected ErrInvalidWorkerCommand, got %v", err)
+	}
+}
+
+func TestWorkerState(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	reg := NewWorkerRegistration(client, WorkerInfo{})
+	reg.SetState(WorkerPaused)
+	if err := reg.heartbeat(ctx, time.Now()); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+
+	workers, err := client.ListWorkers(ctx)
+	if err != nil || len(workers) != 1 {
+		t.Fatalf("Expected 1 worker, got %v, %v", workers, err)
+	}
+	if workers[0].State != WorkerPaused {
+		t.Errorf("Expected the paused state, got %q", workers[0].State)
+	}
+}
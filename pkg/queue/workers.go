@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// workersKey is the sorted set of the registered workers: member = worker ID,
+// score = time of the last heartbeat (Unix ms).
+const workersKey = "workers"
+
+// workerHeartbeatInterval is how often a worker refreshes its registration.
+const workerHeartbeatInterval = 5 * time.Second
+
+// workerStaleAfter is how long after its last heartbeat a worker is considered
+// dead and pruned from the registry.
+const workerStaleAfter = 30 * time.Second
+
+// workerCommandsBuffer is how many commands a worker can have pending before new
+// ones are dropped.
+const workerCommandsBuffer = 16
+
+// dequeueQueues are the queues Dequeue takes tasks from, in priority order.
+var dequeueQueues = []string{"queue:high", "queue:default", "queue:low"}
+
+// workerKey returns the Redis key holding the WorkerInfo of a worker.
+func workerKey(id string) string {
+	return fmt.Sprintf("worker:%s", id)
+}
+
+// WorkerInfo describes a worker process, as of its last heartbeat.
+type WorkerInfo struct {
+	ID          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	PID         int       `json:"pid"`
+	Queues      []string  `json:"queues"`      // Queues the worker takes tasks from
+	Concurrency int       `json:"concurrency"` // Tasks the worker runs at the same time
+	Version     string    `json:"version"`
+	State       string    `json:"state"` // WorkerRunning, WorkerPaused, WorkerDraining or WorkerStopping
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	ActiveTasks []string  `json:"active_tasks"` // IDs of the tasks running on the worker
+}
+
+// WorkerRegistration registers a worker process in Redis, so that ListWorkers
+// reports it, and keeps its registration alive with heartbeats.
+//
+// A worker that stops heartbeating (e.g. because it crashed) is pruned from the
+// registry workerStaleAfter after its last heartbeat; a worker that shuts down
+// cleanly deregisters right away.
+//
+// Redis layout: sorted set "workers" (member = worker ID, score = last heartbeat),
+// and string "worker:{id}" (WorkerInfo JSON, expiring with the worker).
+//
+// While registered, the worker receives the commands sent to it with
+// SendWorkerCommand on the channel returned by Commands.
+type WorkerRegistration struct {
+	client   *Client
+	commands chan WorkerCommand
+
+	mu     sync.Mutex
+	info   WorkerInfo
+	active map[string]struct{}
+}
+
+// NewWorkerRegistration returns the registration of a worker. The fields of info
+// left empty default to: a new ID, the hostname and PID of the process, the
+// queues Dequeue takes tasks from, a concurrency of 1, the current time as start
+// time and the WorkerRunning state. Call Run to register the worker.
+func NewWorkerRegistration(client *Client, info WorkerInfo) *WorkerRegistration {
+	if info.ID == "" {
+		info.ID = uuid.New().String()
+	}
+	if info.Hostname == "" {
+		info.Hostname, _ = os.Hostname()
+	}
+	if info.PID == 0 {
+		info.PID = os.Getpid()
+	}
+	if len(info.Queues) == 0 {
+		info.Queues = append([]string(nil), dequeueQueues...)
+	}
+	if info.Concurrency == 0 {
+		info.Concurrency = 1
+	}
+	if info.StartedAt.IsZero() {
+		info.StartedAt = time.Now()
+	}
+	if info.State == "" {
+		info.State = WorkerRunning
+	}
+	return &WorkerRegistration{
+		client:   client,
+		commands: make(chan WorkerCommand, workerCommandsBuffer),
+		info:     info,
+		active:   make(map[string]struct{}),
+	}
+}
+
+// ID returns the ID of the worker.
+func (w *WorkerRegistration) ID() string {
+	return w.info.ID
+}
+
+// Commands returns the channel on which Run delivers the commands targeting the
+// worker. The worker is expected to apply them, and report its new state with SetState.
+func (w *WorkerRegistration) Commands() <-chan WorkerCommand {
+	return w.commands
+}
+
+// SetState records the state of the worker. It is reported from the next
+// heartbeat on.
+func (w *WorkerRegistration) SetState(state string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.State = state
+}
+
+// TaskStarted records that the worker started running a task. It is reported
+// from the next heartbeat on.
+func (w *WorkerRegistration) TaskStarted(taskID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active[taskID] = struct{}{}
+}
+
+// TaskFinished records that the worker is done with a task.
+func (w *WorkerRegistration) TaskFinished(taskID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.active, taskID)
+}
+
+// Run registers the worker and heartbeats every workerHeartbeatInterval until ctx
+// is cancelled, delivering the commands targeting the worker to Commands. It then
+// deregisters the worker.
+//
+// The worker subscribes to the commands before its first heartbeat, so that a
+// worker listed by ListWorkers receives the commands sent to it.
+func (w *WorkerRegistration) Run(ctx context.Context) {
+	pubsub := w.client.rdb.Subscribe(ctx, workerCommandsChannel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil && ctx.Err() == nil {
+		logger.Log.Error().Err(err).Str("worker_id", w.info.ID).Msg("Failed to subscribe to worker commands")
+	}
+	messages := pubsub.Channel()
+
+	ticker := time.NewTicker(workerHeartbeatInterval)
+	defer ticker.Stop()
+
+	w.beat(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			// The worker context is cancelled: deregister with a fresh one
+			if err := w.Deregister(context.Background()); err != nil {
+				logger.Log.Error().Err(err).Str("worker_id", w.info.ID).Msg("Failed to deregister worker")
+			}
+			return
+		case msg := <-messages:
+			w.deliver(msg.Payload)
+		case <-ticker.C:
+			w.beat(ctx)
+		}
+	}
+}
+
+// beat heartbeats, logging failures.
+func (w *WorkerRegistration) beat(ctx context.Context) {
+	if err := w.heartbeat(ctx, time.Now()); err != nil && ctx.Err() == nil {
+		logger.Log.Error().Err(err).Str("worker_id", w.info.ID).Msg("Worker heartbeat failed")
+	}
+}
+
+// deliver passes a command received on the commands channel to Commands, if it
+// targets the worker.
+func (w *WorkerRegistration) deliver(payload string) {
+	var cmd WorkerCommand
+	if err := json.Unmarshal([]byte(payload), &cmd); err != nil {
+		logger.Log.Error().Err(err).Str("worker_id", w.info.ID).Msg("Invalid worker command")
+		return
+	}
+	w.mu.Lock()
+	targeted := cmd.targets(w.info)
+	w.mu.Unlock()
+	if !targeted {
+		return
+	}
+
+	select {
+	case w.commands <- cmd:
+	default:
+		logger.Log.Warn().Str("worker_id", w.info.ID).Str("command", cmd.Command).Msg("Worker command dropped, too many pending")
+	}
+}
+
+// Deregister removes the worker from the registry.
+func (w *WorkerRegistration) Deregister(ctx context.Context) error {
+	pipe := w.client.rdb.TxPipeline()
+	pipe.ZRem(ctx, workersKey, w.info.ID)
+	pipe.Del(ctx, workerKey(w.info.ID))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// heartbeat publishes the current state of the worker, and prunes the workers
+// that stopped heartbeating.
+func (w *WorkerRegistration) heartbeat(ctx context.Context, now time.Time) error {
+	w.mu.Lock()
+	info := w.info
+	info.HeartbeatAt = now
+	info.ActiveTasks = make([]string, 0, len(w.active))
+	for taskID := range w.active {
+		info.ActiveTasks = append(info.ActiveTasks, taskID)
+	}
+	w.mu.Unlock()
+	sort.Strings(info.ActiveTasks)
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	pipe := w.client.rdb.TxPipeline()
+	pipe.Set(ctx, workerKey(info.ID), data, workerStaleAfter)
+	pipe.ZAdd(ctx, workersKey, redis.Z{Score: float64(now.UnixMilli()), Member: info.ID})
+	queuePruneWorkers(ctx, pipe, now)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// queuePruneWorkers adds the command removing the workers that stopped heartbeating
+// to the pipeline. Their "worker:{id}" keys expire on their own.
+func queuePruneWorkers(ctx context.Context, pipe redis.Pipeliner, now time.Time) {
+	stale := now.Add(-workerStaleAfter).UnixMilli()
+	pipe.ZRemRangeByScore(ctx, workersKey, "-inf", "("+strconv.FormatInt(stale, 10))
+}
+
+// ListWorkers returns the live workers, sorted by start time, after pruning the
+// workers that stopped heartbeating.
+func (c *Client) ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	pipe := c.rdb.TxPipeline()
+	queuePruneWorkers(ctx, pipe, time.Now())
+	ids := pipe.ZRange(ctx, workersKey, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	workers := []WorkerInfo{}
+	if len(ids.Val()) == 0 {
+		return workers, nil
+	}
+	keys := make([]string, 0, len(ids.Val()))
+	for _, id := range ids.Val() {
+		keys = append(keys, workerKey(id))
+	}
+	values, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between the two calls
+			continue
+		}
+		var info WorkerInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			logger.Log.Error().Err(err).Str("worker_id", ids.Val()[i]).Msg("Invalid worker info")
+			continue
+		}
+		workers = append(workers, info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		if !workers[i].StartedAt.Equal(workers[j].StartedAt) {
+			return workers[i].StartedAt.Before(workers[j].StartedAt)
+		}
+		return workers[i].ID < workers[j].ID
+	})
+	return workers, nil
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListWorkers(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	if workers, err := client.ListWorkers(ctx); err != nil || len(workers) != 0 {
+		t.Fatalf("Expected no workers, got %v, %v", workers, err)
+	}
+
+	first := NewWorkerRegistration(client, WorkerInfo{Version: "1.2.0", StartedAt: time.Now().Add(-time.Minute)})
+	second := NewWorkerRegistration(client, WorkerInfo{Queues: []string{"queue:high"}, Concurrency: 4})
+	first.TaskStarted("task-2")
+	first.TaskStarted("task-1")
+	first.TaskStarted("task-3")
+	first.TaskFinished("task-3")
+	for _, w := range []*WorkerRegistration{first, second} {
+		if err := w.heartbeat(ctx, time.Now()); err != nil {
+			t.Fatalf("heartbeat failed: %v", err)
+		}
+	}
+
+	workers, err := client.ListWorkers(ctx)
+	if err != nil {
+		t.Fatalf("ListWorkers failed: %v", err)
+	}
+	if len(workers) != 2 || workers[0].ID != first.ID() || workers[1].ID != second.ID() {
+		t.Fatalf("Expected both workers, oldest first, got %+v", workers)
+	}
+	hostname, _ := os.Hostname()
+	w := workers[0]
+	if w.Hostname != hostname || w.PID != os.Getpid() || w.Version != "1.2.0" || w.Concurrency != 1 || len(w.Queues) != 3 || w.HeartbeatAt.IsZero() {
+		t.Errorf("Unexpected worker %+v", w)
+	}
+	if len(w.ActiveTasks) != 2 || w.ActiveTasks[0] != "task-1" || w.ActiveTasks[1] != "task-2" {
+		t.Errorf("Expected the active tasks task-1 and task-2, got %v", w.ActiveTasks)
+	}
+	if w := workers[1]; w.Concurrency != 4 || len(w.Queues) != 1 || len(w.ActiveTasks) != 0 {
+		t.Errorf("Unexpected worker %+v", w)
+	}
+}
+
+func TestWorkersPruned(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	// The first worker crashed a minute ago
+	crashed := NewWorkerRegistration(client, WorkerInfo{})
+	if err := crashed.heartbeat(ctx, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	alive := NewWorkerRegistration(client, WorkerInfo{})
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		alive.Run(runCtx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		workers, err := client.ListWorkers(ctx)
+		if err != nil {
+			t.Fatalf("ListWorkers failed: %v", err)
+		}
+		if len(workers) == 1 && workers[0].ID == alive.ID() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected only the live worker, got %+v", workers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, _ := client.rdb.ZCard(ctx, workersKey).Result(); n != 1 {
+		t.Errorf("Expected the crashed worker to be pruned, got %d workers", n)
+	}
+
+	// A worker shutting down deregisters right away
+	cancel()
+	<-done
+	if workers, _ := client.ListWorkers(ctx); len(workers) != 0 {
+		t.Errorf("Expected no workers after shutdown, got %+v", workers)
+	}
+}
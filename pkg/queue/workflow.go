@@ -0,0 +1,455 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// Workflow states.
+const (
+	WorkflowRunning   = "running"
+	WorkflowCompleted = "completed"
+	WorkflowFailed    = "failed"
+)
+
+// Workflow node states.
+const (
+	NodeBlocked   = "blocked"   // Waiting for its parents to complete
+	NodePending   = "pending"   // Released to its queue (queued or running)
+	NodeCompleted = "completed" // Completed successfully
+	NodeFailed    = "failed"    // Moved to the Dead Letter Queue
+	NodeCancelled = "cancelled" // Never released because an ancestor failed
+)
+
+// workflowTTL is how long workflow state is kept in Redis after its last update.
+const workflowTTL = 7 * 24 * time.Hour
+
+// Workflow is a directed acyclic graph of tasks.
+// Each task lists the IDs of its parents in DependsOn; it stays blocked until all
+// of them complete successfully and is then released to its priority queue.
+// When a node is moved to the Dead Letter Queue the workflow fails and all of
+// its blocked descendants are cancelled.
+type Workflow struct {
+	// ID identifies the workflow. A UUID is generated if empty.
+	ID string
+
+	// Tasks are the nodes of the graph. Tasks referenced by DependsOn must have
+	// an ID; tasks without one get a generated ID.
+	Tasks []tasks.Task
+}
+
+// WorkflowNode describes one node of a workflow.
+type WorkflowNode struct {
+	TaskID    string   `json:"task_id"`
+	Type      string   `json:"type"`
+	State     string   `json:"state"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// WorkflowState is the current state of a workflow as returned by GetWorkflow.
+// SagaState and SagaChainID are set once a failed workflow starts compensating
+// its completed steps. SagaErrors maps the task ID of each compensation moved to
+// the Dead Letter Queue to its error.
+type WorkflowState struct {
+	ID          string            `json:"id"`
+	State       string            `json:"state"`
+	Nodes       []WorkflowNode    `json:"nodes"`
+	Error       string            `json:"error,omitempty"`
+	SagaState   string            `json:"saga_state,omitempty"`
+	SagaChainID string            `json:"saga_chain_id,omitempty"`
+	SagaErrors  map[string]string `json:"saga_errors,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// workflowKey returns the Redis hash holding the state of a workflow.
+func workflowKey(workflowID string) string {
+	return fmt.Sprintf("workflow:%s", workflowID)
+}
+
+// workflowCompletedKey returns the Redis list of workflow node IDs in completion order.
+func workflowCompletedKey(workflowID string) string {
+	return fmt.Sprintf("workflow:%s:completed", workflowID)
+}
+
+// EnqueueWorkflow validates the graph, stores the workflow state and releases
+// the root nodes (those without dependencies) to their queues.
+// It returns the workflow ID, which can be passed to GetWorkflow to follow progress.
+//
+// Redis layout (hash "workflow:{id}"):
+//   - state, total, completed, error, updated_at: workflow-level fields
+//   - nodes: JSON array of node IDs, in declaration order
+//   - state:{node}: node state (blocked, pending, completed, failed, cancelled)
+//   - deps:{node}: number of parents not yet completed
+//   - children:{node}: JSON array of the IDs of the node's dependents
+//   - task:{node}, queue:{node}: task JSON and queue used when the node is released
+//   - result:{node}: handler result of a completed node
+//   - compensable: set to 1 if any node declares a Compensation
+//   - saga_state, saga_chain: compensation progress of a failed workflow
+//   - saga_error:{task}: error of a compensation moved to the Dead Letter Queue
+//
+// Completed node IDs are also appended to the list "workflow:{id}:completed".
+func (c *Client) EnqueueWorkflow(ctx context.Context, workflow Workflow) (string, error) {
+	if len(workflow.Tasks) == 0 {
+		return "", errors.New("workflow has no tasks")
+	}
+	if workflow.ID == "" {
+		workflow.ID = uuid.New().String()
+	}
+
+	now := time.Now()
+	nodes := make([]tasks.Task, len(workflow.Tasks))
+	index := make(map[string]int, len(nodes))
+	for i, task := range workflow.Tasks {
+		if task.ID == "" {
+			task.ID = uuid.New().String()
+		}
+		if _, exists := index[task.ID]; exists {
+			return "", fmt.Errorf("duplicate workflow task ID %q", task.ID)
+		}
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = now
+		}
+		if task.Compensation != nil {
+			if _, err := payloadObject(task.Compensation.Payload); err != nil {
+				return "", fmt.Errorf("compensation of task %q: %w", task.ID, err)
+			}
+		}
+		task.WorkflowID = workflow.ID
+		nodes[i] = task
+		index[task.ID] = i
+	}
+
+	children := make(map[string][]string, len(nodes))
+	for _, task := range nodes {
+		for _, parent := range task.DependsOn {
+			if _, exists := index[parent]; !exists {
+				return "", fmt.Errorf("task %q depends on unknown task %q", task.ID, parent)
+			}
+			children[parent] = append(children[parent], task.ID)
+		}
+	}
+	if err := checkAcyclic(nodes, children); err != nil {
+		return "", err
+	}
+
+	order := make([]string, len(nodes))
+	fields := map[string]interface{}{
+		"state":      WorkflowRunning,
+		"total":      len(nodes),
+		"completed":  0,
+		"updated_at": now.Format(time.RFC3339Nano),
+	}
+
+	key := workflowKey(workflow.ID)
+	pipe := c.rdb.TxPipeline()
+	for i, task := range nodes {
+		order[i] = task.ID
+
+		data, err := json.Marshal(task)
+		if err != nil {
+			return "", err
+		}
+		childData, err := json.Marshal(append([]string{}, children[task.ID]...))
+		if err != nil {
+			return "", err
+		}
+
+		fields["task:"+task.ID] = data
+		fields["queue:"+task.ID] = queueName(task.Priority)
+		fields["children:"+task.ID] = childData
+		fields["deps:"+task.ID] = len(task.DependsOn)
+		if task.Compensation != nil {
+			fields["compensable"] = 1
+		}
+		if len(task.DependsOn) == 0 {
+			fields["state:"+task.ID] = NodePending
+			pipe.RPush(ctx, queueName(task.Priority), data)
+		} else {
+			fields["state:"+task.ID] = NodeBlocked
+		}
+	}
+
+	orderData, err := json.Marshal(order)
+	if err != nil {
+		return "", err
+	}
+	fields["nodes"] = orderData
+
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, workflowTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return workflow.ID, nil
+}
+
+// checkAcyclic returns an error if the dependency graph contains a cycle (Kahn's algorithm).
+func checkAcyclic(nodes []tasks.Task, children map[string][]string) error {
+	remaining := make(map[string]int, len(nodes))
+	var ready []string
+	for _, task := range nodes {
+		remaining[task.ID] = len(task.DependsOn)
+		if len(task.DependsOn) == 0 {
+			ready = append(ready, task.ID)
+		}
+	}
+
+	visited := 0
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, child := range children[id] {
+			remaining[child]--
+			if remaining[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	if visited != len(nodes) {
+		return errors.New("workflow dependencies contain a cycle")
+	}
+	return nil
+}
+
+// GetWorkflow returns the current state of a workflow and all of its nodes.
+// It returns redis.Nil if the workflow does not exist or has expired.
+func (c *Client) GetWorkflow(ctx context.Context, workflowID string) (*WorkflowState, error) {
+	fields, err := c.rdb.HGetAll(ctx, workflowKey(workflowID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+
+	state := &WorkflowState{
+		ID:          workflowID,
+		State:       fields["state"],
+		Error:       fields["error"],
+		SagaState:   fields["saga_state"],
+		SagaChainID: fields["saga_chain"],
+	}
+	state.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields["updated_at"])
+	for field, value := range fields {
+		if taskID, ok := strings.CutPrefix(field, "saga_error:"); ok {
+			if state.SagaErrors == nil {
+				state.SagaErrors = make(map[string]string)
+			}
+			state.SagaErrors[taskID] = value
+		}
+	}
+
+	var order []string
+	if err := json.Unmarshal([]byte(fields["nodes"]), &order); err != nil {
+		return nil, err
+	}
+	for _, id := range order {
+		var task tasks.Task
+		if err := json.Unmarshal([]byte(fields["task:"+id]), &task); err != nil {
+			return nil, err
+		}
+		state.Nodes = append(state.Nodes, WorkflowNode{
+			TaskID:    id,
+			Type:      task.Type,
+			State:     fields["state:"+id],
+			DependsOn: task.DependsOn,
+		})
+	}
+	return state, nil
+}
+
+// completeWorkflowNodeScript records a completed workflow node and releases its
+// ready dependents.
+//
+// KEYS[1]: Workflow hash
+// KEYS[2]: Completion order list
+// ARGV[1]: Completed node ID
+// ARGV[2]: Node result
+// ARGV[3]: Current timestamp
+// ARGV[4]: Workflow TTL (seconds)
+// Returns 1 on success, 2 if the workflow is compensating, 0 if ignored.
+var completeWorkflowNodeScript = newScript(`
+	local key = KEYS[1]
+	local node = ARGV[1]
+
+	if redis.call('HGET', key, 'state:' .. node) ~= 'pending' then
+		return 0 -- Unknown node or duplicate completion
+	end
+	redis.call('HSET', key, 'state:' .. node, 'completed', 'result:' .. node, ARGV[2], 'updated_at', ARGV[3])
+	redis.call('RPUSH', KEYS[2], node)
+	redis.call('EXPIRE', KEYS[2], ARGV[4])
+	redis.call('EXPIRE', key, ARGV[4])
+	local completed = redis.call('HINCRBY', key, 'completed', 1)
+
+	if redis.call('HEXISTS', key, 'saga_state') == 1 then
+		return 2
+	end
+
+	local children = cjson.decode(redis.call('HGET', key, 'children:' .. node))
+	for _, child in ipairs(children) do
+		local remaining = redis.call('HINCRBY', key, 'deps:' .. child, -1)
+		if remaining == 0 and redis.call('HGET', key, 'state:' .. child) == 'blocked' then
+			redis.call('HSET', key, 'state:' .. child, 'pending')
+			redis.call('RPUSH', redis.call('HGET', key, 'queue:' .. child), redis.call('HGET', key, 'task:' .. child))
+		end
+	end
+
+	if completed == tonumber(redis.call('HGET', key, 'total')) then
+		redis.call('HSET', key, 'state', 'completed')
+	end
+	return 1
+`)
+
+// completeWorkflowNode marks a workflow node as completed and atomically releases
+// every dependent whose parents have now all completed. The workflow itself is
+// marked completed once all nodes have completed.
+//
+// Once a failed workflow is compensating, completions release nothing; a node
+// that was still running when the saga started gets its compensation enqueued
+// right away (see compensateLateStep).
+func (c *Client) completeWorkflowNode(ctx context.Context, task tasks.Task, result json.RawMessage) error {
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+
+	status, err := c.runScript(ctx, completeWorkflowNodeScript,
+		[]string{workflowKey(task.WorkflowID), workflowCompletedKey(task.WorkflowID)},
+		task.ID,
+		[]byte(result),
+		time.Now().Format(time.RFC3339Nano),
+		int(workflowTTL.Seconds()),
+	).Int()
+	if err != nil || status != 2 || task.Compensation == nil {
+		return err
+	}
+	return c.compensateLateStep(ctx, task, result)
+}
+
+// failWorkflowNodeScript records a failed workflow node. If the workflow is a
+// saga, the first failure also starts the compensation chain planned by planSaga.
+//
+// KEYS[1]: Workflow hash
+// KEYS[2]: Completion order list
+// KEYS[3]: Compensation chain hash
+// KEYS[4]: Queue of the first compensation
+// ARGV[1]: Failed node ID
+// ARGV[2]: Error message
+// ARGV[3]: Current timestamp
+// ARGV[4]: Workflow TTL (seconds)
+// ARGV[5]: Number of completed nodes the compensations were planned from
+// ARGV[6]: First compensation JSON (empty if there is nothing to undo)
+// ARGV[7]: Chain TTL (seconds)
+// ARGV[8]: Compensation chain ID
+// ARGV[9...]: Compensation chain hash fields and values
+// Returns -1 if a node completed since the compensations were planned, 1 if
+// the saga was started, 0 otherwise.
+var failWorkflowNodeScript = newScript(`
+	local key = KEYS[1]
+	local node = ARGV[1]
+
+	if redis.call('HGET', key, 'state:' .. node) ~= 'pending' then
+		return 0
+	end
+	-- The first failed node fails the workflow; later ones only cancel their own descendants
+	local first = redis.call('HGET', key, 'state') ~= 'failed'
+	local saga = first and redis.call('HGET', key, 'compensable') == '1'
+	if saga and redis.call('LLEN', KEYS[2]) ~= tonumber(ARGV[5]) then
+		return -1
+	end
+
+	redis.call('HSET', key, 'state:' .. node, 'failed', 'updated_at', ARGV[3])
+	redis.call('EXPIRE', key, ARGV[4])
+	if first then
+		redis.call('HSET', key, 'state', 'failed', 'error', ARGV[2])
+	end
+
+	if saga then
+		-- Abort: cancel every node that has not been released yet
+		for _, id in ipairs(cjson.decode(redis.call('HGET', key, 'nodes'))) do
+			if redis.call('HGET', key, 'state:' .. id) == 'blocked' then
+				redis.call('HSET', key, 'state:' .. id, 'cancelled')
+			end
+		end
+
+		if ARGV[6] == '' then
+			redis.call('HSET', key, 'saga_state', 'compensated')
+		else
+			redis.call('HSET', KEYS[3], unpack(ARGV, 9))
+			redis.call('EXPIRE', KEYS[3], ARGV[7])
+			redis.call('RPUSH', KEYS[4], ARGV[6])
+			redis.call('HSET', key, 'saga_state', 'compensating', 'saga_chain', ARGV[8])
+		end
+		return 1
+	end
+
+	-- Breadth-first cancellation of blocked descendants
+	local pending = cjson.decode(redis.call('HGET', key, 'children:' .. node))
+	local i = 1
+	while i <= #pending do
+		local child = pending[i]
+		i = i + 1
+		if redis.call('HGET', key, 'state:' .. child) == 'blocked' then
+			redis.call('HSET', key, 'state:' .. child, 'cancelled')
+			for _, grandchild in ipairs(cjson.decode(redis.call('HGET', key, 'children:' .. child))) do
+				table.insert(pending, grandchild)
+			end
+		end
+	end
+	return 0
+`)
+
+// failWorkflowNode marks a workflow node as failed, fails the workflow and cancels
+// every blocked descendant of the node. Nodes already released on other branches
+// are left to finish; if one of them fails too, its own descendants are cancelled
+// but the workflow keeps the error of the first failure.
+//
+// If any node of the workflow declares a Compensation, the first failure also
+// aborts the whole workflow: every blocked node is cancelled and the saga is
+// started in the same script, so a failure can never leave a workflow
+// compensating without its compensations enqueued (see planSaga).
+func (c *Client) failWorkflowNode(ctx context.Context, task tasks.Task) error {
+	// A node completing between planning and the script invalidates the plan.
+	// Each retry follows a new completion, so the loop ends.
+	for {
+		saga, err := c.planSaga(ctx, task.WorkflowID)
+		if err != nil {
+			return err
+		}
+
+		firstQueue := ""
+		if len(saga.chain.Steps) > 0 {
+			firstQueue = queueName(saga.chain.Steps[0].Priority)
+		}
+		args := []interface{}{
+			task.ID,
+			task.LastError,
+			time.Now().Format(time.RFC3339Nano),
+			int(workflowTTL.Seconds()),
+			saga.completed,
+			saga.first,
+			int(chainTTL.Seconds()),
+			saga.chain.ID,
+		}
+		for field, value := range saga.fields {
+			args = append(args, field, value)
+		}
+
+		status, err := c.runScript(ctx, failWorkflowNodeScript,
+			[]string{workflowKey(task.WorkflowID), workflowCompletedKey(task.WorkflowID), chainKey(saga.chain.ID), firstQueue},
+			args...,
+		).Int()
+		if err != nil || status != -1 {
+			return err
+		}
+	}
+}
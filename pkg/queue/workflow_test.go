@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// etlWorkflow returns a diamond-shaped DAG: extract -> (clean, enrich) -> load.
+func etlWorkflow() Workflow {
+	return Workflow{
+		Tasks: []tasks.Task{
+			{ID: "extract", Type: "extract", Priority: tasks.PriorityHigh},
+			{ID: "clean", Type: "clean", Priority: tasks.PriorityHigh, DependsOn: []string{"extract"}},
+			{ID: "enrich", Type: "enrich", Priority: tasks.PriorityHigh, DependsOn: []string{"extract"}},
+			{ID: "load", Type: "load", Priority: tasks.PriorityHigh, DependsOn: []string{"clean", "enrich"}},
+		},
+	}
+}
+
+func nodeStates(t *testing.T, client *Client, workflowID string) map[string]string {
+	t.Helper()
+	state, err := client.GetWorkflow(context.Background(), workflowID)
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	states := make(map[string]string)
+	for _, node := range state.Nodes {
+		states[node.TaskID] = node.State
+	}
+	states[""] = state.State
+	return states
+}
+
+func TestWorkflowReleasesDependents(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	workflowID, err := client.EnqueueWorkflow(ctx, etlWorkflow())
+	if err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	complete := func(want ...string) {
+		t.Helper()
+		for range want {
+			task, raw, err := client.Dequeue(ctx)
+			if err != nil {
+				t.Fatalf("Dequeue failed: %v", err)
+			}
+			found := false
+			for _, id := range want {
+				found = found || task.ID == id
+			}
+			if !found {
+				t.Fatalf("Expected one of %v, got %s", want, task.ID)
+			}
+			if err := client.CompleteWithResult(ctx, *task, raw, nil); err != nil {
+				t.Fatalf("CompleteWithResult failed: %v", err)
+			}
+		}
+	}
+
+	states := nodeStates(t, client, workflowID)
+	if states["extract"] != NodePending || states["clean"] != NodeBlocked || states["load"] != NodeBlocked {
+		t.Fatalf("Unexpected initial states: %v", states)
+	}
+
+	complete("extract")
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 2 {
+		t.Fatalf("Expected clean and enrich to be released, got %d queued", depth)
+	}
+
+	// load waits for both parents
+	complete("clean")
+	if states := nodeStates(t, client, workflowID); states["load"] != NodeBlocked {
+		t.Fatalf("Expected load to stay blocked, got %s", states["load"])
+	}
+	complete("enrich")
+	complete("load")
+
+	states = nodeStates(t, client, workflowID)
+	if states[""] != WorkflowCompleted {
+		t.Errorf("Expected workflow completed, got %v", states)
+	}
+}
+
+func TestWorkflowCascadingCancellation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	workflowID, err := client.EnqueueWorkflow(ctx, etlWorkflow())
+	if err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	task, raw, err := client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	// Fail "clean" (first released child); "enrich" keeps running, "load" is cancelled
+	task, raw, err = client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if task.ID != "clean" {
+		t.Fatalf("Expected clean, got %s", task.ID)
+	}
+	task.LastError = "bad rows"
+	if err := client.Fail(ctx, *task, raw); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	states := nodeStates(t, client, workflowID)
+	if states[""] != WorkflowFailed || states["clean"] != NodeFailed || states["load"] != NodeCancelled || states["enrich"] != NodePending {
+		t.Fatalf("Unexpected states after failure: %v", states)
+	}
+
+	// Completing the surviving branch must not release the cancelled node
+	task, raw, err = client.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	client.CompleteWithResult(ctx, *task, raw, nil)
+
+	if depth := client.GetQueueDepths(ctx)["queue:high"]; depth != 0 {
+		t.Errorf("Expected no node to be released, got %d queued", depth)
+	}
+	if states := nodeStates(t, client, workflowID); states["load"] != NodeCancelled {
+		t.Errorf("Expected load to stay cancelled, got %s", states["load"])
+	}
+}
+
+func TestWorkflowFailureOnIndependentBranches(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	workflowID, err := client.EnqueueWorkflow(ctx, Workflow{
+		Tasks: []tasks.Task{
+			{ID: "a", Type: "step", Priority: tasks.PriorityHigh},
+			{ID: "b", Type: "step", Priority: tasks.PriorityHigh},
+			{ID: "a2", Type: "step", Priority: tasks.PriorityHigh, DependsOn: []string{"a"}},
+			{ID: "b2", Type: "step", Priority: tasks.PriorityHigh, DependsOn: []string{"b"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueWorkflow failed: %v", err)
+	}
+
+	// The second failure must still cancel the descendants of its own branch
+	for _, errMsg := range []string{"first", "second"} {
+		task, raw, err := client.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("Dequeue failed: %v", err)
+		}
+		task.LastError = errMsg
+		if err := client.Fail(ctx, *task, raw); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+	}
+
+	states := nodeStates(t, client, workflowID)
+	if states[""] != WorkflowFailed || states["a2"] != NodeCancelled || states["b2"] != NodeCancelled {
+		t.Fatalf("Unexpected states after both failures: %v", states)
+	}
+	state, err := client.GetWorkflow(ctx, workflowID)
+	if err != nil {
+		t.Fatalf("GetWorkflow failed: %v", err)
+	}
+	if state.Error != "first" {
+		t.Errorf("Expected the first failure to be kept, got %q", state.Error)
+	}
+}
+
+func TestEnqueueWorkflowValidation(t *testing.T) {
+	s, client := setupTestRedis()
+	defer s.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		tasks []tasks.Task
+	}{
+		{"empty", nil},
+		{"duplicate ID", []tasks.Task{{ID: "a"}, {ID: "a"}}},
+		{"unknown dependency", []tasks.Task{{ID: "a", DependsOn: []string{"missing"}}}},
+		{"cycle", []tasks.Task{{ID: "a", DependsOn: []string{"b"}}, {ID: "b", DependsOn: []string{"a"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.EnqueueWorkflow(ctx, Workflow{Tasks: tt.tasks}); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+
+	if _, err := client.GetWorkflow(ctx, "missing"); err != redis.Nil {
+		t.Errorf("Expected redis.Nil for missing workflow, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@
 package tasks
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -19,9 +20,10 @@ type Task struct {
 	// Type categorizes the task for routing and metrics (e.g., "email", "notification").
 	Type string `json:"type"`
 
-	// Payload contains the job-specific data as a generic interface.
-	// Workers are responsible for type assertion based on the Type field.
-	Payload interface{} `json:"payload"`
+	// Payload contains the job-specific data as raw JSON.
+	// It is kept undecoded so that handlers can unmarshal it directly into
+	// a concrete type (see queue.NewTask and worker.HandleTyped).
+	Payload json.RawMessage `json:"payload"`
 
 	// CreatedAt is the timestamp when the task was first enqueued.
 	CreatedAt time.Time `json:"created_at"`
@@ -34,8 +36,105 @@ type Task struct {
 	// Higher priority tasks are processed before lower priority ones.
 	// 0 = Low, 1 = Default, 2 = High
 	Priority int `json:"priority"`
+
+	// ResultTTL controls how long the task result is retained after the task
+	// completes or permanently fails. Zero means the queue default (24h).
+	ResultTTL time.Duration `json:"result_ttl,omitempty"`
+
+	// ExpiresAt is the deadline after which the task is worthless. An expired task
+	// is archived instead of being handed to a handler, and is not retried past
+	// this time. The zero value means the task never expires.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// ConcurrencyKey selects the cluster-wide concurrency limit that applies to
+	// the task. Empty means the limit configured for its Type, if any.
+	ConcurrencyKey string `json:"concurrency_key,omitempty"`
+
+	// OrderingKey groups tasks that must run strictly one after another, in
+	// enqueue order (e.g. a customer ID). Tasks of different keys run in parallel.
+	OrderingKey string `json:"ordering_key,omitempty"`
+
+	// SingletonKey makes the task mutually exclusive with the other tasks of the
+	// same key: at most one of them runs at a time, cluster-wide. SingletonPolicy
+	// decides what happens to a task enqueued while another one of its key is
+	// pending or running (SingletonSkip if empty).
+	SingletonKey    string `json:"singleton_key,omitempty"`
+	SingletonPolicy string `json:"singleton_policy,omitempty"`
+
+	// DebounceKey coalesces bursts of tasks: the task is delayed by DebounceWindow,
+	// and replaced by any task enqueued with the same key in the meantime, so only
+	// the last task of a burst runs, DebounceWindow after it was enqueued.
+	DebounceKey    string        `json:"debounce_key,omitempty"`
+	DebounceWindow time.Duration `json:"debounce_window,omitempty"`
+
+	// ThrottleKey limits the tasks of the same key to one per ThrottleWindow. A task
+	// enqueued while the window is open waits for the next window, replacing any
+	// task already waiting for it, so the last task of a burst is never lost.
+	ThrottleKey    string        `json:"throttle_key,omitempty"`
+	ThrottleWindow time.Duration `json:"throttle_window,omitempty"`
+
+	// AggregationKey collects the task into a group of tasks of the same key instead
+	// of enqueuing it. The group is delivered to the worker as a single batch task
+	// once it holds AggregationSize tasks or AggregationDelay after its first task
+	// was added, whichever comes first. Tasks of a group should share a Type.
+	AggregationKey   string        `json:"aggregation_key,omitempty"`
+	AggregationSize  int           `json:"aggregation_size,omitempty"`
+	AggregationDelay time.Duration `json:"aggregation_delay,omitempty"`
+
+	// ScheduleID identifies the cron schedule that enqueued the task, if any, and
+	// RunKey the firing of the schedule ("{schedule ID}:{fire time}"), which is
+	// enqueued at most once.
+	ScheduleID string `json:"schedule_id,omitempty"`
+	RunKey     string `json:"run_key,omitempty"`
+
+	// Aggregated is set on the batch task of an aggregation group, whose Payload
+	// is the JSON array of the tasks of the group.
+	Aggregated bool `json:"aggregated,omitempty"`
+
+	// LastError holds the error message of the most recent failed attempt.
+	// It is set by the worker before the task is retried or dead-lettered.
+	LastError string `json:"last_error,omitempty"`
+
+	// ChainID identifies the chain this task is a step of, if any.
+	// ChainStep is the zero-based position of the task within the chain.
+	ChainID   string `json:"chain_id,omitempty"`
+	ChainStep int    `json:"chain_step,omitempty"`
+
+	// GroupID identifies the group this task belongs to, if any.
+	GroupID string `json:"group_id,omitempty"`
+
+	// WorkflowID identifies the workflow (DAG) this task is a node of, if any.
+	WorkflowID string `json:"workflow_id,omitempty"`
+
+	// DependsOn lists the IDs of the tasks in the same workflow that must
+	// complete successfully before this task is released to its queue.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// Compensation is the task that undoes the effects of this workflow step
+	// (e.g. a refund for a payment). It is enqueued if the step completed but the
+	// workflow later fails, in reverse order of step completion (saga pattern).
+	Compensation *Task `json:"compensation,omitempty"`
+}
+
+// Expired reports whether the task has a deadline that is not after now.
+func (t Task) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
 }
 
+// Singleton policies, applied to a task enqueued while another task of the same
+// SingletonKey is pending or running.
+const (
+	// SingletonSkip drops the new task.
+	SingletonSkip = "skip"
+
+	// SingletonQueue enqueues the new task, which waits for the running task to finish.
+	SingletonQueue = "queue"
+
+	// SingletonReplace drops the pending task, if any, in favor of the new task,
+	// which then waits for the running task to finish.
+	SingletonReplace = "replace"
+)
+
 const (
 	PriorityLow     = 0
 	PriorityDefault = 1
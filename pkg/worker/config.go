@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// Config holds the worker settings that are loaded from a JSON file, e.g.:
+//
+//	{
+//	  "concurrency_limits": {"image_resize": 4},
+//	  "default_rate_limit": {"rate": 10, "burst": 20},
+//	  "rate_limits": {"email": {"rate": 5, "burst": 5}},
+//	  "queue_rate_limits": {"low": {"rate": 50, "burst": 100}}
+//	}
+type Config struct {
+	// ConcurrencyLimits caps the number of tasks running at the same time across
+	// all workers of the cluster, by task type or by tasks.Task.ConcurrencyKey.
+	ConcurrencyLimits map[string]int `json:"concurrency_limits"`
+
+	// DefaultRateLimit applies to task types without an entry in RateLimits.
+	// Set it to null to leave those types unlimited.
+	DefaultRateLimit *RateLimit `json:"default_rate_limit"`
+
+	// RateLimits are token buckets shared by all workers, by task type.
+	RateLimits map[string]RateLimit `json:"rate_limits"`
+
+	// QueueRateLimits are token buckets shared by all workers, by priority queue
+	// ("high", "default" or "low"). They apply in addition to the per-type limits.
+	QueueRateLimits map[string]RateLimit `json:"queue_rate_limits"`
+}
+
+// RateLimit configures a token bucket.
+type RateLimit struct {
+	Rate  int `json:"rate"`  // Tokens added per second
+	Burst int `json:"burst"` // Bucket capacity
+}
+
+// RateLimitRule is a rate limit together with the key of the token bucket it applies to.
+type RateLimitRule struct {
+	Key string
+	RateLimit
+}
+
+// DefaultConfig returns the configuration used when no file is given:
+// no concurrency limits and a rate limit of 10 tasks/sec (burst 20) per task type.
+func DefaultConfig() *Config {
+	return &Config{
+		DefaultRateLimit: &RateLimit{Rate: 10, Burst: 20},
+	}
+}
+
+// LoadConfig reads the worker configuration from a JSON file. Settings missing
+// from the file keep their DefaultConfig value; an empty path yields DefaultConfig.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+// validate rejects rate limits that could never grant a token.
+func (c *Config) validate() error {
+	check := func(name string, limit RateLimit) error {
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			return fmt.Errorf("rate limit %q: rate and burst must be positive", name)
+		}
+		return nil
+	}
+
+	if c.DefaultRateLimit != nil {
+		if err := check("default", *c.DefaultRateLimit); err != nil {
+			return err
+		}
+	}
+	for name, limit := range c.RateLimits {
+		if err := check(name, limit); err != nil {
+			return err
+		}
+	}
+	for name, limit := range c.QueueRateLimits {
+		if err := check(name, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConcurrencyLimit returns the semaphore key and cluster-wide limit that apply to
+// the task. The key is the task's ConcurrencyKey, or its Type if unset; a limit of
+// zero means the task is not limited.
+func (c *Config) ConcurrencyLimit(task *tasks.Task) (string, int) {
+	key := task.ConcurrencyKey
+	if key == "" {
+		key = task.Type
+	}
+	return key, c.ConcurrencyLimits[key]
+}
+
+// RateLimitsFor returns the rate limits that apply to the task: the limit of its
+// type (or DefaultRateLimit), then the limit of its priority queue, if configured.
+//
+// Bucket keys are "ratelimit:{type}" and "ratelimit:queue:{queue}".
+func (c *Config) RateLimitsFor(task *tasks.Task) []RateLimitRule {
+	var rules []RateLimitRule
+	if limit, ok := c.RateLimits[task.Type]; ok {
+		rules = append(rules, RateLimitRule{Key: "ratelimit:" + task.Type, RateLimit: limit})
+	} else if c.DefaultRateLimit != nil {
+		rules = append(rules, RateLimitRule{Key: "ratelimit:" + task.Type, RateLimit: *c.DefaultRateLimit})
+	}
+
+	queue := priorityName(task.Priority)
+	if limit, ok := c.QueueRateLimits[queue]; ok {
+		rules = append(rules, RateLimitRule{Key: "ratelimit:queue:" + queue, RateLimit: limit})
+	}
+	return rules
+}
+
+// priorityName returns the name of the priority queue of the given priority, as used in QueueRateLimits.
+func priorityName(priority int) string {
+	switch priority {
+	case tasks.PriorityHigh:
+		return "high"
+	case tasks.PriorityLow:
+		return "low"
+	}
+	return "default"
+}
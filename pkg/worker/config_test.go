@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worker.json")
+	if err := os.WriteFile(path, []byte(`{"concurrency_limits": {"image_resize": 4, "gpu": 1}}`), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		task      tasks.Task
+		wantKey   string
+		wantLimit int
+	}{
+		{tasks.Task{Type: "image_resize"}, "image_resize", 4},
+		{tasks.Task{Type: "image_resize", ConcurrencyKey: "gpu"}, "gpu", 1},
+		{tasks.Task{Type: "email"}, "email", 0},
+	}
+	for _, tt := range tests {
+		key, limit := cfg.ConcurrencyLimit(&tt.task)
+		if key != tt.wantKey || limit != tt.wantLimit {
+			t.Errorf("ConcurrencyLimit(%+v) = %s, %d; want %s, %d", tt.task, key, limit, tt.wantKey, tt.wantLimit)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if _, limit := cfg.ConcurrencyLimit(&tasks.Task{Type: "image_resize"}); limit != 0 {
+		t.Errorf("Expected no limit by default, got %d", limit)
+	}
+	rules := cfg.RateLimitsFor(&tasks.Task{Type: "email"})
+	if len(rules) != 1 || rules[0].Key != "ratelimit:email" || rules[0].Rate != 10 || rules[0].Burst != 20 {
+		t.Errorf("Expected the default rate limit, got %+v", rules)
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestRateLimitsFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worker.json")
+	data := `{
+		"default_rate_limit": null,
+		"rate_limits": {"email": {"rate": 5, "burst": 5}},
+		"queue_rate_limits": {"low": {"rate": 50, "burst": 100}}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		task tasks.Task
+		want []RateLimitRule
+	}{
+		{tasks.Task{Type: "email", Priority: tasks.PriorityHigh}, []RateLimitRule{
+			{Key: "ratelimit:email", RateLimit: RateLimit{Rate: 5, Burst: 5}},
+		}},
+		{tasks.Task{Type: "email", Priority: tasks.PriorityLow}, []RateLimitRule{
+			{Key: "ratelimit:email", RateLimit: RateLimit{Rate: 5, Burst: 5}},
+			{Key: "ratelimit:queue:low", RateLimit: RateLimit{Rate: 50, Burst: 100}},
+		}},
+		{tasks.Task{Type: "report", Priority: tasks.PriorityDefault}, nil},
+	}
+	for _, tt := range tests {
+		got := cfg.RateLimitsFor(&tt.task)
+		if len(got) != len(tt.want) {
+			t.Errorf("RateLimitsFor(%+v) = %+v, want %+v", tt.task, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("RateLimitsFor(%+v)[%d] = %+v, want %+v", tt.task, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLoadConfigInvalidRateLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worker.json")
+	if err := os.WriteFile(path, []byte(`{"rate_limits": {"email": {"rate": 0, "burst": 5}}}`), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected an error for a zero rate")
+	}
+}
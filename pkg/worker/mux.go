@@ -0,0 +1,197 @@
+// Package worker provides the building blocks used by worker processes to
+// dispatch dequeued tasks to their handlers.
+//
+// Handlers are registered on a ServeMux by task type. Typed handlers can be
+// registered with HandleTyped, which decodes the task payload into a concrete
+// Go type before invoking the handler. Handlers may return a result value,
+// which the worker stores so that clients can retrieve it by task ID.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+// ErrSkipRetry marks a task failure as permanent.
+// Workers should move tasks failing with an error wrapping ErrSkipRetry
+// straight to the Dead Letter Queue instead of scheduling a retry.
+var ErrSkipRetry = errors.New("skip retry for the task")
+
+// ErrHandlerNotFound is returned by ServeMux.ProcessTask when no handler is
+// registered for the task type and no default handler is set.
+var ErrHandlerNotFound = errors.New("no handler registered for task type")
+
+// Handler processes a single task.
+// The returned result, if non-nil, is serialized to JSON and stored as the task result.
+type Handler interface {
+	ProcessTask(ctx context.Context, task *tasks.Task) (interface{}, error)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as task handlers.
+type HandlerFunc func(ctx context.Context, task *tasks.Task) (interface{}, error)
+
+// ProcessTask calls f(ctx, task).
+func (f HandlerFunc) ProcessTask(ctx context.Context, task *tasks.Task) (interface{}, error) {
+	return f(ctx, task)
+}
+
+// ServeMux routes tasks to handlers based on their Type.
+// It is safe for concurrent use.
+type ServeMux struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	fallback Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for the given task type.
+// It panics if a handler already exists for the type.
+func (m *ServeMux) Handle(taskType string, handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if handler == nil {
+		panic("worker: nil handler")
+	}
+	if _, exists := m.handlers[taskType]; exists {
+		panic(fmt.Sprintf("worker: multiple registrations for %q", taskType))
+	}
+	m.handlers[taskType] = handler
+}
+
+// HandleFunc registers the handler function for the given task type.
+func (m *ServeMux) HandleFunc(taskType string, handler func(ctx context.Context, task *tasks.Task) (interface{}, error)) {
+	m.Handle(taskType, HandlerFunc(handler))
+}
+
+// HandleDefault registers the handler used for task types without a dedicated handler.
+func (m *ServeMux) HandleDefault(handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = handler
+}
+
+// ProcessTask dispatches the task to the handler registered for its type.
+// It returns an error wrapping ErrHandlerNotFound (and ErrSkipRetry, since
+// retrying cannot help) if no handler matches.
+func (m *ServeMux) ProcessTask(ctx context.Context, task *tasks.Task) (interface{}, error) {
+	m.mu.RLock()
+	handler, ok := m.handlers[task.Type]
+	if !ok {
+		handler = m.fallback
+	}
+	m.mu.RUnlock()
+
+	if handler == nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrHandlerNotFound, task.Type, ErrSkipRetry)
+	}
+	return handler.ProcessTask(ctx, task)
+}
+
+// BatchHandlerFunc processes the tasks of an aggregation group at once.
+// The returned result, if non-nil, is stored as the result of the batch task.
+type BatchHandlerFunc func(ctx context.Context, batch []*tasks.Task) (interface{}, error)
+
+// HandleBatch registers a handler for tasks enqueued with an AggregationKey: it
+// receives the tasks of each aggregation group as a slice, in enqueue order.
+// A task of the type enqueued without an AggregationKey is delivered as a batch of one.
+// If the batch cannot be decoded the handler is not invoked and the batch task
+// fails with an error wrapping ErrSkipRetry.
+//
+// Example:
+//
+//	mux.HandleBatch("webhook", func(ctx context.Context, batch []*tasks.Task) (interface{}, error) {
+//		return nil, deliver(batch)
+//	})
+func (m *ServeMux) HandleBatch(taskType string, handler BatchHandlerFunc) {
+	m.HandleFunc(taskType, func(ctx context.Context, task *tasks.Task) (interface{}, error) {
+		if !task.Aggregated {
+			return handler(ctx, []*tasks.Task{task})
+		}
+		var batch []*tasks.Task
+		if err := json.Unmarshal(task.Payload, &batch); err != nil {
+			return nil, fmt.Errorf("decode %s batch: %v: %w", task.Type, err, ErrSkipRetry)
+		}
+		return handler(ctx, batch)
+	})
+}
+
+// HandleTyped registers a handler that receives the task payload decoded into T.
+// An empty payload leaves T at its zero value. The task itself is available
+// from the handler context with TaskFromContext.
+// If the payload cannot be decoded the handler is not invoked and the task fails
+// with an error wrapping ErrSkipRetry, since retrying would fail the same way.
+//
+// Example:
+//
+//	worker.HandleTyped(mux, "email", func(ctx context.Context, p EmailPayload) error {
+//		return send(p.To)
+//	})
+func HandleTyped[T any](mux *ServeMux, taskType string, handler func(ctx context.Context, payload T) error) {
+	mux.HandleFunc(taskType, func(ctx context.Context, task *tasks.Task) (interface{}, error) {
+		payload, err := decodePayload[T](task)
+		if err != nil {
+			return nil, err
+		}
+		return nil, handler(withTask(ctx, task), payload)
+	})
+}
+
+// HandleTypedResult is like HandleTyped for handlers that produce a result.
+// The returned value of type R is stored as the task result on success.
+//
+// Example:
+//
+//	worker.HandleTypedResult(mux, "image_resize", func(ctx context.Context, p ResizePayload) (ResizeResult, error) {
+//		return resize(p)
+//	})
+func HandleTypedResult[T, R any](mux *ServeMux, taskType string, handler func(ctx context.Context, payload T) (R, error)) {
+	mux.HandleFunc(taskType, func(ctx context.Context, task *tasks.Task) (interface{}, error) {
+		payload, err := decodePayload[T](task)
+		if err != nil {
+			return nil, err
+		}
+		result, err := handler(withTask(ctx, task), payload)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+}
+
+// taskContextKey is the context key under which typed handlers find their task.
+type taskContextKey struct{}
+
+// withTask returns a copy of ctx carrying the task.
+func withTask(ctx context.Context, task *tasks.Task) context.Context {
+	return context.WithValue(ctx, taskContextKey{}, task)
+}
+
+// TaskFromContext returns the task being processed by a typed handler (see
+// HandleTyped), e.g. to log its ID.
+func TaskFromContext(ctx context.Context) (*tasks.Task, bool) {
+	task, ok := ctx.Value(taskContextKey{}).(*tasks.Task)
+	return task, ok
+}
+
+// decodePayload unmarshals the task payload into T, wrapping failures with ErrSkipRetry.
+func decodePayload[T any](task *tasks.Task) (T, error) {
+	var payload T
+	if len(task.Payload) > 0 {
+		if err := json.Unmarshal(task.Payload, &payload); err != nil {
+			return payload, fmt.Errorf("decode %s payload: %v: %w", task.Type, err, ErrSkipRetry)
+		}
+	}
+	return payload, nil
+}
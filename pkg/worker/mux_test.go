@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/guido-cesarano/distributedq/pkg/tasks"
+)
+
+type emailPayload struct {
+	To string `json:"to"`
+}
+
+func TestServeMuxRouting(t *testing.T) {
+	mux := NewServeMux()
+
+	var called string
+	mux.HandleFunc("email", func(ctx context.Context, task *tasks.Task) (interface{}, error) {
+		called = "email"
+		return nil, nil
+	})
+	mux.HandleDefault(HandlerFunc(func(ctx context.Context, task *tasks.Task) (interface{}, error) {
+		called = "default"
+		return nil, nil
+	}))
+
+	if _, err := mux.ProcessTask(context.Background(), &tasks.Task{Type: "email"}); err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+	if called != "email" {
+		t.Errorf("Expected email handler, got %s", called)
+	}
+
+	if _, err := mux.ProcessTask(context.Background(), &tasks.Task{Type: "unknown"}); err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+	if called != "default" {
+		t.Errorf("Expected default handler, got %s", called)
+	}
+}
+
+func TestServeMuxHandlerNotFound(t *testing.T) {
+	mux := NewServeMux()
+
+	_, err := mux.ProcessTask(context.Background(), &tasks.Task{Type: "unknown"})
+	if !errors.Is(err, ErrHandlerNotFound) {
+		t.Errorf("Expected ErrHandlerNotFound, got %v", err)
+	}
+	if !errors.Is(err, ErrSkipRetry) {
+		t.Errorf("Expected missing handler to skip retries, got %v", err)
+	}
+}
+
+func TestHandleTyped(t *testing.T) {
+	mux := NewServeMux()
+
+	var got emailPayload
+	var gotTask *tasks.Task
+	HandleTyped(mux, "email", func(ctx context.Context, p emailPayload) error {
+		got = p
+		gotTask, _ = TaskFromContext(ctx)
+		return nil
+	})
+
+	task := &tasks.Task{ID: "email-1", Type: "email", Payload: json.RawMessage(`{"to":"user@example.com"}`)}
+	if _, err := mux.ProcessTask(context.Background(), task); err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+	if got.To != "user@example.com" {
+		t.Errorf("Expected decoded payload, got %+v", got)
+	}
+	if gotTask != task {
+		t.Errorf("Expected the task in the handler context, got %+v", gotTask)
+	}
+}
+
+func TestHandleTypedResult(t *testing.T) {
+	mux := NewServeMux()
+
+	HandleTypedResult(mux, "email", func(ctx context.Context, p emailPayload) (map[string]string, error) {
+		return map[string]string{"sent_to": p.To}, nil
+	})
+
+	task := &tasks.Task{Type: "email", Payload: json.RawMessage(`{"to":"user@example.com"}`)}
+	result, err := mux.ProcessTask(context.Background(), task)
+	if err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+
+	got, ok := result.(map[string]string)
+	if !ok || got["sent_to"] != "user@example.com" {
+		t.Errorf("Expected handler result, got %#v", result)
+	}
+}
+
+func TestHandleTypedResultError(t *testing.T) {
+	mux := NewServeMux()
+
+	HandleTypedResult(mux, "email", func(ctx context.Context, p emailPayload) (*emailPayload, error) {
+		return &p, errors.New("smtp unavailable")
+	})
+
+	result, err := mux.ProcessTask(context.Background(), &tasks.Task{Type: "email"})
+	if err == nil {
+		t.Fatal("Expected handler error")
+	}
+	if result != nil {
+		t.Errorf("Expected no result on failure, got %#v", result)
+	}
+}
+
+func TestHandleTypedDecodeError(t *testing.T) {
+	mux := NewServeMux()
+
+	called := false
+	HandleTyped(mux, "email", func(ctx context.Context, p emailPayload) error {
+		called = true
+		return nil
+	})
+
+	task := &tasks.Task{Type: "email", Payload: json.RawMessage(`{"to":42}`)}
+	_, err := mux.ProcessTask(context.Background(), task)
+	if !errors.Is(err, ErrSkipRetry) {
+		t.Errorf("Expected ErrSkipRetry on decode failure, got %v", err)
+	}
+	if called {
+		t.Error("Expected handler not to be called on decode failure")
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	mux := NewServeMux()
+
+	var got []string
+	mux.HandleBatch("webhook", func(ctx context.Context, batch []*tasks.Task) (interface{}, error) {
+		got = got[:0]
+		for _, task := range batch {
+			got = append(got, task.ID)
+		}
+		return len(batch), nil
+	})
+
+	batch := &tasks.Task{
+		Type:       "webhook",
+		Aggregated: true,
+		Payload:    json.RawMessage(`[{"id":"w1","type":"webhook"},{"id":"w2","type":"webhook"}]`),
+	}
+	result, err := mux.ProcessTask(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+	if result != 2 || len(got) != 2 || got[0] != "w1" || got[1] != "w2" {
+		t.Errorf("Expected the batch [w1 w2], got %v (result %v)", got, result)
+	}
+
+	// A task enqueued without aggregation is a batch of one
+	if _, err := mux.ProcessTask(context.Background(), &tasks.Task{ID: "w3", Type: "webhook"}); err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != "w3" {
+		t.Errorf("Expected the batch [w3], got %v", got)
+	}
+
+	batch.Payload = json.RawMessage(`{"id":"w1"}`)
+	if _, err := mux.ProcessTask(context.Background(), batch); !errors.Is(err, ErrSkipRetry) {
+		t.Errorf("Expected an invalid batch to skip retries, got %v", err)
+	}
+}
+
+func TestHandleDuplicatePanics(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("email", func(ctx context.Context, task *tasks.Task) (interface{}, error) { return nil, nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected duplicate registration to panic")
+		}
+	}()
+	mux.HandleFunc("email", func(ctx context.Context, task *tasks.Task) (interface{}, error) { return nil, nil })
+}